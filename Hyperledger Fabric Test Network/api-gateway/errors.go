@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"google.golang.org/grpc/status"
+)
+
+// contractErrorStatus maps the error codes returned by the chaincode to HTTP
+// status codes. Errors without a known code are reported as 500.
+var contractErrorStatus = map[string]int{
+	"INVALID_ARGUMENT":   http.StatusBadRequest,
+	"INVALID_STATUS":     http.StatusBadRequest,
+	"INVALID_TRANSITION": http.StatusConflict,
+	"FORBIDDEN":          http.StatusForbidden,
+	"LIMIT_EXCEEDED":     http.StatusUnprocessableEntity,
+}
+
+// contractErrorCode matches the "CODE: " prefix the chaincode puts on its
+// typed errors, either at the start of a message or after the peer's
+// "chaincode response 500, " wrapping.
+var contractErrorCode = regexp.MustCompile(`(?:^|, )([A-Z][A-Z_]*): `)
+
+// remainingAllowance matches the allowance a LIMIT_EXCEEDED error reports
+var remainingAllowance = regexp.MustCompile(`remaining allowance: ([0-9]+(?:\.[0-9]+)?)`)
+
+// errorCode returns the chaincode error code carried by err, looking first at
+// the per-peer details of a gRPC status and then at the error text itself
+func errorCode(err error) string {
+	messages := []string{}
+	if st, ok := status.FromError(err); ok {
+		for _, detail := range st.Details() {
+			if errorDetail, ok := detail.(*gateway.ErrorDetail); ok {
+				messages = append(messages, errorDetail.GetMessage())
+			}
+		}
+	}
+	messages = append(messages, err.Error())
+
+	for _, message := range messages {
+		if match := contractErrorCode.FindStringSubmatch(message); match != nil {
+			if _, known := contractErrorStatus[match[1]]; known {
+				return match[1]
+			}
+		}
+	}
+	return ""
+}
+
+// respondWithError reports a failed chaincode call, using the HTTP status that
+// matches its error code
+func respondWithError(c *gin.Context, err error) {
+	code := errorCode(err)
+	httpStatus, ok := contractErrorStatus[code]
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	body := gin.H{"error": err.Error(), "code": code}
+	if match := remainingAllowance.FindStringSubmatch(err.Error()); code == "LIMIT_EXCEEDED" && match != nil {
+		body["remainingAllowance"] = match[1]
+	}
+	c.JSON(httpStatus, body)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	// defaultCheckpointFile records the last chaincode event received, so a
+	// restarted gateway resumes from there. Override it with CHECKPOINT_FILE.
+	defaultCheckpointFile = "event-checkpoint.json"
+	// eventRetryDelay is how long to wait before reconnecting a broken event stream
+	eventRetryDelay = 5 * time.Second
+	// subscriberBuffer is how many events a slow SSE client may fall behind
+	// before further events are dropped for it
+	subscriberBuffer = 64
+)
+
+// ChaincodeEvent is a chaincode event as streamed to clients. Payload is the
+// Transaction the chaincode recorded for the change.
+type ChaincodeEvent struct {
+	BlockNumber   uint64          `json:"blockNumber"`
+	TransactionID string          `json:"transactionId"`
+	EventName     string          `json:"eventName"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// eventBroker fans chaincode events out to every connected SSE client
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan ChaincodeEvent]struct{}
+}
+
+var broker = &eventBroker{subscribers: map[chan ChaincodeEvent]struct{}{}}
+
+func (b *eventBroker) subscribe() chan ChaincodeEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	events := make(chan ChaincodeEvent, subscriberBuffer)
+	b.subscribers[events] = struct{}{}
+	return events
+}
+
+func (b *eventBroker) unsubscribe(events chan ChaincodeEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers, events)
+}
+
+func (b *eventBroker) publish(event ChaincodeEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+			log.Printf("Dropping %s event %s for a slow client", event.EventName, event.TransactionID)
+		}
+	}
+}
+
+// checkpointFile returns the path of the event checkpoint file
+func checkpointFile() string {
+	if file := os.Getenv("CHECKPOINT_FILE"); file != "" {
+		return file
+	}
+	return defaultCheckpointFile
+}
+
+// listenForEvents receives the chaincode's events, starting after the last
+// checkpointed one, and publishes them to SSE clients. It reconnects whenever
+// the stream breaks and never returns. An event is checkpointed once it has
+// been published, whether or not any client received it: the stream is a live
+// feed, and clients that were disconnected or fell behind catch up from the
+// transaction history.
+func listenForEvents(network *client.Network, checkpointer *client.FileCheckpointer) {
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		events, err := network.ChaincodeEvents(ctx, chaincodeName, client.WithCheckpoint(checkpointer))
+		if err != nil {
+			log.Printf("Failed to start chaincode event listening: %v", err)
+		} else {
+			for event := range events {
+				broker.publish(ChaincodeEvent{
+					BlockNumber:   event.BlockNumber,
+					TransactionID: event.TransactionID,
+					EventName:     event.EventName,
+					Payload:       event.Payload,
+				})
+				if err := checkpointer.CheckpointChaincodeEvent(event); err != nil {
+					log.Printf("Failed to checkpoint chaincode event %s: %v", event.TransactionID, err)
+				}
+			}
+			log.Println("Chaincode event stream closed")
+		}
+		cancel()
+
+		time.Sleep(eventRetryDelay)
+	}
+}
+
+// streamEvents sends chaincode events to the client as Server-Sent Events
+// until it disconnects. The SSE event name is the chaincode event name. Only
+// events published while the client is connected are sent.
+func streamEvents(c *gin.Context) {
+	events := broker.subscribe()
+	defer broker.unsubscribe(events)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-events:
+			c.SSEvent(event.EventName, event)
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
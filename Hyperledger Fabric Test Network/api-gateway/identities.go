@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/hash"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"google.golang.org/grpc"
+)
+
+const (
+	// defaultIdentitiesFile lists the identities the gateway signs with, as
+	// written by scripts/enrollUsers.sh. Override it with IDENTITIES_FILE.
+	defaultIdentitiesFile = cryptoPath + "/gateway-identities.json"
+	// defaultEventIdentity names the identity chaincode events are received
+	// with. Override it with EVENT_IDENTITY.
+	defaultEventIdentity = "admin"
+	// contractKey is the gin context key of the authenticated caller's contract
+	contractKey = "contract"
+	// roleKey is the gin context key of the authenticated caller's role
+	roleKey = "role"
+)
+
+// attributesOID identifies the certificate extension in which Fabric CA
+// stores the attributes of an enrolled identity, as {"attrs": {...}}
+var attributesOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// gatewayIdentity is a client identity, enrolled with role attributes, that
+// the gateway signs transactions with. HTTP callers select one by presenting
+// its API key. Relative certificate and key paths are resolved against the
+// directory of the identities file.
+type gatewayIdentity struct {
+	Name     string `json:"name"`
+	APIKey   string `json:"apiKey"`
+	MSPID    string `json:"mspId"`
+	CertPath string `json:"certPath"`
+	KeyPath  string `json:"keyPath"`
+}
+
+// callerContract is the contract signed for by the identity with apiKey, and
+// the role attribute of that identity's certificate
+type callerContract struct {
+	apiKey   []byte
+	contract *client.Contract
+	role     string
+}
+
+var callers []callerContract
+
+// identitiesFile returns the path of the gateway identities file
+func identitiesFile() string {
+	if file := os.Getenv("IDENTITIES_FILE"); file != "" {
+		return file
+	}
+	return defaultIdentitiesFile
+}
+
+// eventIdentity returns the name of the identity chaincode events are received with
+func eventIdentity() string {
+	if name := os.Getenv("EVENT_IDENTITY"); name != "" {
+		return name
+	}
+	return defaultEventIdentity
+}
+
+// loadIdentities reads the gateway identities from file
+func loadIdentities(file string) ([]gatewayIdentity, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identities: %w", err)
+	}
+
+	var identities []gatewayIdentity
+	if err := json.Unmarshal(data, &identities); err != nil {
+		return nil, fmt.Errorf("failed to parse identities: %w", err)
+	}
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no identities found in %s", file)
+	}
+
+	dir := filepath.Dir(file)
+	for i := range identities {
+		if identities[i].APIKey == "" {
+			return nil, fmt.Errorf("identity %s has no API key", identities[i].Name)
+		}
+		if !filepath.IsAbs(identities[i].CertPath) {
+			identities[i].CertPath = filepath.Join(dir, identities[i].CertPath)
+		}
+		if !filepath.IsAbs(identities[i].KeyPath) {
+			identities[i].KeyPath = filepath.Join(dir, identities[i].KeyPath)
+		}
+	}
+	return identities, nil
+}
+
+// connectIdentity connects a gateway that signs as id over the shared gRPC
+// connection. It also returns the role attribute of id's certificate.
+func connectIdentity(connection *grpc.ClientConn, id gatewayIdentity) (*client.Gateway, string, error) {
+	// Load client certificate
+	clientCertPem, err := ioutil.ReadFile(id.CertPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read client certificate: %w", err)
+	}
+
+	clientCert, err := identity.CertificateFromPEM(clientCertPem)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse client certificate: %w", err)
+	}
+	role, err := certificateRole(clientCert)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Load client private key
+	keyDir, err := ioutil.ReadDir(id.KeyPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read private key directory: %w", err)
+	}
+
+	if len(keyDir) == 0 {
+		return nil, "", fmt.Errorf("no private key files found in %s", id.KeyPath)
+	}
+
+	clientKeyPem, err := ioutil.ReadFile(filepath.Join(id.KeyPath, keyDir[0].Name()))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	clientKey, err := identity.PrivateKeyFromPEM(clientKeyPem)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	x509Identity, err := identity.NewX509Identity(id.MSPID, clientCert)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create client identity: %w", err)
+	}
+
+	sign, err := identity.NewPrivateKeySign(clientKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create signer: %w", err)
+	}
+
+	gw, err := client.Connect(
+		x509Identity,
+		client.WithSign(sign),
+		client.WithHash(hash.SHA256),
+		client.WithClientConnection(connection),
+		client.WithEvaluateTimeout(5*time.Second),
+		client.WithEndorseTimeout(15*time.Second),
+		client.WithSubmitTimeout(5*time.Second),
+		client.WithCommitStatusTimeout(1*time.Minute),
+	)
+	return gw, role, err
+}
+
+// certificateRole returns the role attribute that Fabric CA enrolled cert with,
+// or "" if it has none
+func certificateRole(cert *x509.Certificate) (string, error) {
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(attributesOID) {
+			continue
+		}
+		var attributes struct {
+			Attrs map[string]string `json:"attrs"`
+		}
+		if err := json.Unmarshal(extension.Value, &attributes); err != nil {
+			return "", fmt.Errorf("failed to parse certificate attributes: %w", err)
+		}
+		return attributes.Attrs["role"], nil
+	}
+	return "", nil
+}
+
+// authenticate selects the contract of the identity whose API key the caller
+// presents as "Authorization: Bearer <key>". The chaincode then authorizes the
+// request against that identity's role and dealer ID. Callers without a valid
+// key are refused with 401.
+func authenticate(c *gin.Context) {
+	key := []byte(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	for _, caller := range callers {
+		if subtle.ConstantTimeCompare(key, caller.apiKey) == 1 {
+			c.Set(contractKey, caller.contract)
+			c.Set(roleKey, caller.role)
+			c.Next()
+			return
+		}
+	}
+
+	c.Header("WWW-Authenticate", "Bearer")
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "a valid API key is required", "code": "UNAUTHENTICATED"})
+}
+
+// contractFor returns the contract signed for by the authenticated caller's identity
+func contractFor(c *gin.Context) *client.Contract {
+	return c.MustGet(contractKey).(*client.Contract)
+}
+
+// requireRole refuses callers whose identity does not have one of roles with
+// 403. The chaincode authorizes every contract call itself; this guards
+// gateway endpoints that do not go through the contract.
+func requireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString(roleKey)
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "this endpoint requires one of the roles " + strings.Join(roles, ", "), "code": "FORBIDDEN"})
+	}
+}
@@ -2,12 +2,12 @@ package main
 
 import (
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
-	"path"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -18,70 +18,292 @@ import (
 )
 
 const (
-	mspID        = "Org1MSP"
 	cryptoPath   = "../organizations/peerOrganizations/org1.example.com"
-	certPath     = cryptoPath + "/users/User1@org1.example.com/msp/signcerts/cert.pem"
-	keyPath      = cryptoPath + "/users/User1@org1.example.com/msp/keystore/"
 	tlsCertPath  = cryptoPath + "/peers/peer0.org1.example.com/tls/ca.crt"
 	peerEndpoint = "localhost:7051"
 	gatewayPeer  = "peer0.org1.example.com"
-)
 
-var (
-	contract *client.Contract
+	channelName   = "mychannel"
+	chaincodeName = "basic"
+
+	// defaultPageSize applies when a bookmark is given without a pageSize
+	defaultPageSize = 100
 )
 
+// Money is an amount held as integer minor units of an ISO-4217 currency
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
 // Asset represents the asset structure
 type Asset struct {
-	Balance      float64   `json:"balance"`
-	DealerID     string    `json:"dealerId"`
-	MPIN         string    `json:"mpin"`
-	MSISDN       string    `json:"msisdn"`
-	Remarks      string    `json:"remarks"`
-	Status       string    `json:"status"`
-	TransAmount  float64   `json:"transAmount"`
-	TransType    string    `json:"transType"`
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
+	AvailableBalance   Money     `json:"availableBalance"`
+	Balance            Money     `json:"balance"`
+	DealerID           string    `json:"dealerId"`
+	DocType            string    `json:"docType"`
+	FailedMPINAttempts int       `json:"failedMpinAttempts"`
+	HeldBalance        Money     `json:"heldBalance"`
+	KYCTier            string    `json:"kycTier"`
+	MSISDN             string    `json:"msisdn"`
+	Remarks            string    `json:"remarks"`
+	Status             string    `json:"status"`
+	TransAmount        Money     `json:"transAmount"`
+	TransType          string    `json:"transType"`
+	CreatedAt          time.Time `json:"createdAt"`
+	UpdatedAt          time.Time `json:"updatedAt"`
 }
 
 // Transaction represents a transaction history entry
 type Transaction struct {
-	ID          string    `json:"id"`
-	AssetID     string    `json:"assetId"`
-	TransType   string    `json:"transType"`
-	Amount      float64   `json:"amount"`
-	PrevBalance float64   `json:"prevBalance"`
-	NewBalance  float64   `json:"newBalance"`
-	Remarks     string    `json:"remarks"`
-	Timestamp   time.Time `json:"timestamp"`
-	TxID        string    `json:"txId"`
-}
-
-// CreateAssetRequest represents the request body for creating an asset
+	DocType        string    `json:"docType"`
+	ID             string    `json:"id"`
+	AssetID        string    `json:"assetId"`
+	TransType      string    `json:"transType"`
+	Amount         Money     `json:"amount"`
+	PrevBalance    Money     `json:"prevBalance"`
+	NewBalance     Money     `json:"newBalance"`
+	Remarks        string    `json:"remarks"`
+	Timestamp      time.Time `json:"timestamp"`
+	TxID           string    `json:"txId"`
+	CounterpartyID string    `json:"counterpartyId,omitempty"`
+	LinkedTxnID    string    `json:"linkedTxnId,omitempty"`
+	Actor          string    `json:"actor,omitempty"`
+	PrevStatus     string    `json:"prevStatus,omitempty"`
+	NewStatus      string    `json:"newStatus,omitempty"`
+	Status         string    `json:"status,omitempty"`
+	ReversedBy     string    `json:"reversedBy,omitempty"`
+	ReversalOf     string    `json:"reversalOf,omitempty"`
+	PrevKYCTier    string    `json:"prevKycTier,omitempty"`
+	NewKYCTier     string    `json:"newKycTier,omitempty"`
+	EvidenceHash   string    `json:"evidenceHash,omitempty"`
+}
+
+// Dealer represents a dealer and its float wallet
+type Dealer struct {
+	CreatedAt    time.Time `json:"createdAt"`
+	CreditLimit  Money     `json:"creditLimit"`
+	DocType      string    `json:"docType"`
+	FloatBalance Money     `json:"floatBalance"`
+	ID           string    `json:"id"`
+	MSPID        string    `json:"mspId"`
+	Name         string    `json:"name"`
+	Status       string    `json:"status"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// Amounts in requests are json.Number so the decimal text the client sent, either
+// as a JSON number or a string, reaches the chaincode without a float64 round trip.
+// The chaincode parses it strictly against the currency's decimal places.
+
+// CreateAssetRequest represents the request body for creating an asset. Assets
+// open with a zero balance, so balance may be left out.
 type CreateAssetRequest struct {
-	MSISDN   string  `json:"msisdn" binding:"required"`
-	DealerID string  `json:"dealerId" binding:"required"`
-	MPIN     string  `json:"mpin" binding:"required"`
-	Balance  float64 `json:"balance" binding:"required"`
-	Status   string  `json:"status" binding:"required"`
-	Remarks  string  `json:"remarks"`
+	MSISDN   string      `json:"msisdn" binding:"required,msisdn"`
+	DealerID string      `json:"dealerId" binding:"required,dealerid"`
+	MPIN     string      `json:"mpin" binding:"required,mpin"`
+	Balance  json.Number `json:"balance" binding:"omitempty,amount"`
+	Currency string      `json:"currency" binding:"required,len=3,uppercase"`
+	Status   string      `json:"status" binding:"required,oneof=PENDING_KYC ACTIVE"`
+	Remarks  string      `json:"remarks"`
 }
 
 // UpdateBalanceRequest represents the request body for updating balance
 type UpdateBalanceRequest struct {
-	MPIN      string  `json:"mpin" binding:"required"`
-	Amount    float64 `json:"amount" binding:"required"`
-	TransType string  `json:"transType" binding:"required"`
-	Remarks   string  `json:"remarks"`
+	MPIN      string      `json:"mpin" binding:"required,mpin"`
+	Amount    json.Number `json:"amount" binding:"required,positiveamount"`
+	TransType string      `json:"transType" binding:"required,oneof=CREDIT DEBIT"`
+	Remarks   string      `json:"remarks"`
+}
+
+// TransferRequest represents the request body for a peer-to-peer transfer
+type TransferRequest struct {
+	FromMSISDN string      `json:"fromMsisdn" binding:"required,msisdn"`
+	ToMSISDN   string      `json:"toMsisdn" binding:"required,msisdn"`
+	MPIN       string      `json:"mpin" binding:"required,mpin"`
+	Amount     json.Number `json:"amount" binding:"required,positiveamount"`
+	Remarks    string      `json:"remarks"`
+}
+
+// ProfileRequest represents the request body for updating a subscriber
+// profile. It is passed to the chaincode in the transient map, as the profile
+// is private data.
+type ProfileRequest struct {
+	Name           string `json:"name" binding:"required"`
+	IDDocumentHash string `json:"idDocumentHash" binding:"required"`
+	Address        string `json:"address"`
+}
+
+// HoldRequest represents the request body for placing a hold. Expiry is an
+// RFC 3339 timestamp.
+type HoldRequest struct {
+	MPIN      string      `json:"mpin" binding:"required,mpin"`
+	Amount    json.Number `json:"amount" binding:"required,positiveamount"`
+	Expiry    string      `json:"expiry" binding:"required,datetime=2006-01-02T15:04:05Z07:00"`
+	Reference string      `json:"reference" binding:"required"`
+}
+
+// CaptureRequest represents the request body for capturing a hold
+type CaptureRequest struct {
+	Amount json.Number `json:"amount" binding:"required,positiveamount"`
+}
+
+// KYCRequest represents the request body for changing the KYC tier of an
+// asset. EvidenceHash is the hash of the verification documents.
+type KYCRequest struct {
+	Tier         string `json:"tier" binding:"required,oneof=TIER0 TIER1 TIER2"`
+	EvidenceHash string `json:"evidenceHash" binding:"required"`
+	Remarks      string `json:"remarks"`
+}
+
+// UnlockRequest represents the request body for unlocking an asset
+type UnlockRequest struct {
+	Remarks string `json:"remarks"`
+}
+
+// RestoreRequest represents the request body for reopening a closed asset
+type RestoreRequest struct {
+	Remarks string `json:"remarks"`
+}
+
+// DeleteAssetQuery holds the query parameters for closing an asset. An asset
+// with a remaining balance is only closed when settlementMsisdn names the
+// account that receives it.
+type DeleteAssetQuery struct {
+	SettlementMSISDN string `form:"settlementMsisdn" binding:"omitempty,msisdn"`
+	Remarks          string `form:"remarks"`
+}
+
+// AssetListQuery holds the filters of GET /assets
+type AssetListQuery struct {
+	IncludeClosed bool `form:"includeClosed"`
 }
 
 // UpdateStatusRequest represents the request body for updating status
 type UpdateStatusRequest struct {
-	Status  string `json:"status" binding:"required"`
+	Status  string `json:"status" binding:"required,oneof=PENDING_KYC ACTIVE SUSPENDED BLOCKED LOCKED"`
 	Remarks string `json:"remarks"`
 }
 
+// CreateDealerRequest represents the request body for registering a dealer
+type CreateDealerRequest struct {
+	ID          string      `json:"id" binding:"required,dealerid"`
+	Name        string      `json:"name" binding:"required"`
+	MSPID       string      `json:"mspId" binding:"required"`
+	CreditLimit json.Number `json:"creditLimit" binding:"required,amount"`
+	Currency    string      `json:"currency" binding:"required,len=3,uppercase"`
+}
+
+// UpdateDealerRequest represents the request body for updating a dealer
+type UpdateDealerRequest struct {
+	Name        string      `json:"name" binding:"required"`
+	MSPID       string      `json:"mspId" binding:"required"`
+	Status      string      `json:"status" binding:"required,oneof=ACTIVE SUSPENDED CLOSED"`
+	CreditLimit json.Number `json:"creditLimit" binding:"required,amount"`
+}
+
+// SupplyRequest represents the request body for issuing float to, or burning
+// float of, a dealer
+type SupplyRequest struct {
+	DealerID  string      `json:"dealerId" binding:"required,dealerid"`
+	Amount    json.Number `json:"amount" binding:"required,positiveamount"`
+	Reference string      `json:"reference" binding:"required"`
+}
+
+// SupplyQuery holds the query parameters of GET /supply
+type SupplyQuery struct {
+	Currency string `form:"currency" binding:"required,len=3,uppercase"`
+}
+
+// LimitProfileRequest represents the request body for setting a limit
+// profile. A zero cap means no limit; maxBalance may be left out.
+type LimitProfileRequest struct {
+	Currency       string      `json:"currency" binding:"required,len=3,uppercase"`
+	PerTransaction json.Number `json:"perTransaction" binding:"required,amount"`
+	Daily          json.Number `json:"daily" binding:"required,amount"`
+	Monthly        json.Number `json:"monthly" binding:"required,amount"`
+	MaxBalance     json.Number `json:"maxBalance" binding:"omitempty,amount"`
+}
+
+// LimitProfileQuery holds the query parameters of GET /limits/:id
+type LimitProfileQuery struct {
+	Currency string `form:"currency" binding:"required,len=3,uppercase"`
+}
+
+// FeeScheduleRequest represents the request body for setting the fee of a
+// transaction type. Schedule is passed to the chaincode as is.
+type FeeScheduleRequest struct {
+	Currency string          `json:"currency" binding:"required,len=3,uppercase"`
+	Schedule json.RawMessage `json:"schedule" binding:"required"`
+}
+
+// FeeScheduleQuery holds the query parameters of GET /fees/:transType
+type FeeScheduleQuery struct {
+	Currency string `form:"currency" binding:"required,len=3,uppercase"`
+}
+
+// ReverseRequest represents the request body for reversing a transaction
+type ReverseRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// FloatRequest represents the request body for moving float between a dealer
+// and one of its assets
+type FloatRequest struct {
+	MSISDN  string      `json:"msisdn" binding:"required,msisdn"`
+	Amount  json.Number `json:"amount" binding:"required,positiveamount"`
+	Remarks string      `json:"remarks"`
+}
+
+// PageQuery holds the optional pagination parameters of list endpoints
+type PageQuery struct {
+	PageSize int    `form:"pageSize" binding:"omitempty,min=1,max=1000"`
+	Bookmark string `form:"bookmark"`
+}
+
+// AssetSearchQuery holds the filters of GET /assets/search. They are passed to
+// the QueryAssets chaincode function as its JSON filter.
+type AssetSearchQuery struct {
+	DealerID    string `form:"dealerId" json:"dealerId,omitempty" binding:"omitempty,dealerid"`
+	Status      string `form:"status" json:"status,omitempty"`
+	Currency    string `form:"currency" json:"currency,omitempty" binding:"required_with=MinBalance MaxBalance,omitempty,len=3,uppercase"`
+	MinBalance  string `form:"minBalance" json:"minBalance,omitempty" binding:"omitempty,amount"`
+	MaxBalance  string `form:"maxBalance" json:"maxBalance,omitempty" binding:"omitempty,amount"`
+	UpdatedFrom string `form:"updatedFrom" json:"updatedFrom,omitempty" binding:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	UpdatedTo   string `form:"updatedTo" json:"updatedTo,omitempty" binding:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	// IncludeClosed also matches CLOSED assets when no status is given
+	IncludeClosed bool `form:"includeClosed" json:"includeClosed,omitempty"`
+}
+
+// AssetPath holds the path parameter of /assets/:msisdn routes
+type AssetPath struct {
+	MSISDN string `uri:"msisdn" binding:"required,msisdn"`
+}
+
+// DealerPath holds the path parameter of /dealers/:id routes
+type DealerPath struct {
+	ID string `uri:"id" binding:"required,dealerid"`
+}
+
+// TransactionPath holds the path parameter of /holds/:id and
+// /transactions/:id routes; a hold is identified by its HOLD transaction
+type TransactionPath struct {
+	ID string `uri:"id" binding:"required,transactionid"`
+}
+
+// paginated reports whether the client asked for a single page instead of the full list
+func (q PageQuery) paginated() bool {
+	return q.PageSize != 0 || q.Bookmark != ""
+}
+
+// pageSize returns the requested page size as a chaincode argument
+func (q PageQuery) pageSize() string {
+	if q.PageSize == 0 {
+		return strconv.Itoa(defaultPageSize)
+	}
+	return strconv.Itoa(q.PageSize)
+}
+
 func main() {
 	// Initialize the gateway connection
 	err := initGateway()
@@ -90,6 +312,9 @@ func main() {
 	}
 
 	// Setup Gin router
+	if err := registerValidators(); err != nil {
+		log.Fatalf("Failed to register request validators: %v", err)
+	}
 	router := gin.Default()
 
 	// Add CORS middleware
@@ -106,17 +331,49 @@ func main() {
 		c.Next()
 	})
 
-	// API routes
-	api := router.Group("/api/v1")
+	// API routes. Every request is signed with the identity of the API key it carries.
+	api := router.Group("/api/v1", authenticate)
 	{
 		api.POST("/assets", createAsset)
 		api.GET("/assets/:msisdn", getAsset)
 		api.GET("/assets", getAllAssets)
+		api.GET("/assets/search", searchAssets)
 		api.PUT("/assets/:msisdn/balance", updateBalance)
 		api.PUT("/assets/:msisdn/status", updateStatus)
+		api.POST("/assets/:msisdn/unlock", unlockAsset)
+		api.POST("/assets/:msisdn/kyc/upgrade", upgradeKYC)
+		api.POST("/assets/:msisdn/kyc/downgrade", downgradeKYC)
 		api.DELETE("/assets/:msisdn", deleteAsset)
+		api.POST("/assets/:msisdn/restore", restoreAsset)
 		api.GET("/assets/:msisdn/transactions", getTransactionHistory)
+		api.GET("/assets/:msisdn/history", getAssetHistory)
+		api.GET("/assets/:msisdn/profile", getProfile)
+		api.PUT("/assets/:msisdn/profile", updateProfile)
+		api.POST("/transfers", createTransfer)
+		api.POST("/assets/:msisdn/holds", placeHold)
+		api.GET("/holds/:id", getHold)
+		api.POST("/holds/:id/capture", captureHold)
+		api.POST("/holds/:id/release", releaseHold)
+		api.POST("/transactions/:id/reverse", reverseTransaction)
+		api.POST("/dealers", createDealer)
+		api.GET("/dealers/:id", getDealer)
+		api.PUT("/dealers/:id", updateDealer)
+		api.GET("/dealers/:id/assets", getDealerAssets)
+		api.GET("/dealers/:id/transactions", getDealerTransactions)
+		api.POST("/dealers/:id/distribute", distributeFloat)
+		api.POST("/dealers/:id/reclaim", reclaimFloat)
+		api.GET("/supply", getTotalSupply)
+		api.POST("/supply/issue", issueFloat)
+		api.POST("/supply/burn", burnFloat)
+		api.GET("/supply/check", checkSupply)
+		api.GET("/limits/:id", getLimitProfile)
+		api.PUT("/limits/:id", setLimitProfile)
+		api.GET("/fees/:transType", getFeeSchedule)
+		api.PUT("/fees/:transType", setFeeSchedule)
 		api.POST("/ledger/init", initLedger)
+		// Events carry every dealer's assets and transactions, so only
+		// admins may stream them
+		api.GET("/events", requireRole("admin"), streamEvents)
 	}
 
 	// Health check endpoint
@@ -129,39 +386,10 @@ func main() {
 	log.Fatal(router.Run(":8080"))
 }
 
+// initGateway connects a gateway for every identity in the identities file,
+// all sharing one gRPC connection to the peer, and starts listening for
+// chaincode events
 func initGateway() error {
-	// Load client certificate
-	clientCertPem, err := ioutil.ReadFile(certPath)
-	if err != nil {
-		return fmt.Errorf("failed to read client certificate: %w", err)
-	}
-
-	clientCert, err := identity.CertificateFromPEM(clientCertPem)
-	if err != nil {
-		return fmt.Errorf("failed to parse client certificate: %w", err)
-	}
-
-	// Load client private key
-	keyDir, err := ioutil.ReadDir(keyPath)
-	if err != nil {
-		return fmt.Errorf("failed to read private key directory: %w", err)
-	}
-
-	if len(keyDir) == 0 {
-		return fmt.Errorf("no private key files found in %s", keyPath)
-	}
-
-	keyFile := path.Join(keyPath, keyDir[0].Name())
-	clientKeyPem, err := ioutil.ReadFile(keyFile)
-	if err != nil {
-		return fmt.Errorf("failed to read private key: %w", err)
-	}
-
-	clientKey, err := identity.PrivateKeyFromPEM(clientKeyPem)
-	if err != nil {
-		return fmt.Errorf("failed to parse private key: %w", err)
-	}
-
 	// Load TLS certificate
 	tlsCertPem, err := ioutil.ReadFile(tlsCertPath)
 	if err != nil {
@@ -183,27 +411,36 @@ func initGateway() error {
 		return fmt.Errorf("failed to create gRPC connection: %w", err)
 	}
 
-	// Create client identity
-	id := identity.NewX509Identity(mspID, clientCert, clientKey)
-
-	// Create gateway
-	gw, err := client.Connect(
-		id,
-		client.WithSign(identity.NewPrivateKeySign(clientKey)),
-		client.WithHash(identity.SHA256),
-		client.WithClientConnection(connection),
-		client.WithEvaluateTimeout(5*time.Second),
-		client.WithEndorseTimeout(15*time.Second),
-		client.WithSubmitTimeout(5*time.Second),
-		client.WithCommitStatusTimeout(1*time.Minute),
-	)
+	identities, err := loadIdentities(identitiesFile())
 	if err != nil {
-		return fmt.Errorf("failed to connect to gateway: %w", err)
+		return err
+	}
+
+	var events *client.Network
+	for _, id := range identities {
+		gw, role, err := connectIdentity(connection, id)
+		if err != nil {
+			return fmt.Errorf("failed to connect to gateway as %s: %w", id.Name, err)
+		}
+
+		// Get network and contract
+		network := gw.GetNetwork(channelName)
+		callers = append(callers, callerContract{apiKey: []byte(id.APIKey), contract: network.GetContract(chaincodeName), role: role})
+		if id.Name == eventIdentity() {
+			events = network
+		}
+	}
+	if events == nil {
+		return fmt.Errorf("event identity %s not found in %s", eventIdentity(), identitiesFile())
 	}
 
-	// Get network and contract
-	network := gw.GetNetwork("mychannel")
-	contract = network.GetContract("basic")
+	// Stream chaincode events to SSE clients, resuming after the last one
+	// received from the peer. Clients that are not connected then miss it.
+	checkpointer, err := client.NewFileCheckpointer(checkpointFile())
+	if err != nil {
+		return fmt.Errorf("failed to open event checkpoint: %w", err)
+	}
+	go listenForEvents(events, checkpointer)
 
 	return nil
 }
@@ -213,14 +450,20 @@ func initGateway() error {
 func createAsset(c *gin.Context) {
 	var req CreateAssetRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondWithBindError(c, err)
 		return
 	}
 
-	_, err := contract.SubmitTransaction("CreateAsset", req.MSISDN, req.DealerID, req.MPIN, 
-		fmt.Sprintf("%.2f", req.Balance), req.Status, req.Remarks)
+	balance := req.Balance.String()
+	if balance == "" {
+		balance = "0"
+	}
+
+	_, err := contractFor(c).Submit("CreateAsset",
+		client.WithArguments(req.MSISDN, req.DealerID, balance, req.Currency, req.Status, req.Remarks),
+		withMPIN(req.MPIN))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondWithError(c, err)
 		return
 	}
 
@@ -228,10 +471,17 @@ func createAsset(c *gin.Context) {
 }
 
 func getAsset(c *gin.Context) {
-	msisdn := c.Param("msisdn")
+	msisdn, ok := msisdnParam(c)
+	if !ok {
+		return
+	}
 
-	result, err := contract.EvaluateTransaction("ReadAsset", msisdn)
+	result, err := contractFor(c).EvaluateTransaction("ReadAsset", msisdn)
 	if err != nil {
+		if errorCode(err) != "" {
+			respondWithError(c, err)
+			return
+		}
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
@@ -240,78 +490,661 @@ func getAsset(c *gin.Context) {
 }
 
 func getAllAssets(c *gin.Context) {
-	result, err := contract.EvaluateTransaction("GetAllAssets")
+	var query PageQuery
+	var filter AssetListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+	includeClosed := strconv.FormatBool(filter.IncludeClosed)
+
+	var result []byte
+	var err error
+	if query.paginated() {
+		result, err = contractFor(c).EvaluateTransaction("GetAssetsWithPagination", query.pageSize(), query.Bookmark, includeClosed)
+	} else {
+		result, err = contractFor(c).EvaluateTransaction("GetAllAssets", includeClosed)
+	}
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", result)
+}
+
+func searchAssets(c *gin.Context) {
+	var query PageQuery
+	var filter AssetSearchQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	filterJSON, err := json.Marshal(filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	result, err := contractFor(c).EvaluateTransaction("QueryAssets", string(filterJSON), query.pageSize(), query.Bookmark)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
 	c.Data(http.StatusOK, "application/json", result)
 }
 
 func updateBalance(c *gin.Context) {
-	msisdn := c.Param("msisdn")
+	msisdn, ok := msisdnParam(c)
+	if !ok {
+		return
+	}
 	var req UpdateBalanceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondWithBindError(c, err)
+		return
+	}
+
+	result, err := contractFor(c).Submit("UpdateAssetBalance",
+		client.WithArguments(msisdn, req.Amount.String(), req.TransType, req.Remarks),
+		withMPIN(req.MPIN))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respondWithTransaction(c, result, "Balance updated successfully")
+}
+
+func createTransfer(c *gin.Context) {
+	var req TransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	result, err := contractFor(c).Submit("TransferBalance",
+		client.WithArguments(req.FromMSISDN, req.ToMSISDN, req.Amount.String(), req.Remarks),
+		withMPIN(req.MPIN))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respondWithTransaction(c, result, "Transfer completed successfully")
+}
+
+func placeHold(c *gin.Context) {
+	msisdn, ok := msisdnParam(c)
+	if !ok {
+		return
+	}
+	var req HoldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	result, err := contractFor(c).Submit("PlaceHold",
+		client.WithArguments(msisdn, req.Amount.String(), req.Expiry, req.Reference),
+		withMPIN(req.MPIN))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respondWithTransaction(c, result, "Hold placed successfully")
+}
+
+func getHold(c *gin.Context) {
+	id, ok := transactionIDParam(c)
+	if !ok {
+		return
+	}
+
+	result, err := contractFor(c).EvaluateTransaction("ReadHold", id)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", result)
+}
+
+func captureHold(c *gin.Context) {
+	id, ok := transactionIDParam(c)
+	if !ok {
+		return
+	}
+	var req CaptureRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	result, err := contractFor(c).SubmitTransaction("CaptureHold", id, req.Amount.String())
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respondWithTransaction(c, result, "Hold captured successfully")
+}
+
+func releaseHold(c *gin.Context) {
+	id, ok := transactionIDParam(c)
+	if !ok {
 		return
 	}
 
-	_, err := contract.SubmitTransaction("UpdateAssetBalance", msisdn, req.MPIN, 
-		fmt.Sprintf("%.2f", req.Amount), req.TransType, req.Remarks)
+	result, err := contractFor(c).SubmitTransaction("ReleaseHold", id)
 	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respondWithTransaction(c, result, "Hold released successfully")
+}
+
+// withMPIN passes an MPIN to the chaincode in the transient map, so that it is
+// not written to the block with the proposal arguments
+func withMPIN(mpin string) client.ProposalOption {
+	return client.WithTransient(map[string][]byte{"mpin": []byte(mpin)})
+}
+
+// respondWithTransaction reports the transaction recorded by a balance-changing
+// chaincode call. A wrong MPIN is committed on the ledger as an MPIN_FAILED
+// record so that the failed-attempt counter sticks; it is surfaced as 401 here.
+func respondWithTransaction(c *gin.Context, result []byte, message string) {
+	var transaction Transaction
+	if err := json.Unmarshal(result, &transaction); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Balance updated successfully"})
+	if transaction.TransType == "MPIN_FAILED" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": transaction.Remarks})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message, "transaction": transaction})
+}
+
+func reverseTransaction(c *gin.Context) {
+	id, ok := transactionIDParam(c)
+	if !ok {
+		return
+	}
+	var req ReverseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	result, err := contractFor(c).SubmitTransaction("ReverseTransaction", id, req.Reason)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respondWithTransaction(c, result, "Transaction reversed successfully")
+}
+
+func upgradeKYC(c *gin.Context) {
+	changeKYC(c, "UpgradeKYC", "KYC tier upgraded successfully")
+}
+
+func downgradeKYC(c *gin.Context) {
+	changeKYC(c, "DowngradeKYC", "KYC tier downgraded successfully")
+}
+
+// changeKYC submits a KYC tier change for the asset in the path
+func changeKYC(c *gin.Context, function, message string) {
+	msisdn, ok := msisdnParam(c)
+	if !ok {
+		return
+	}
+	var req KYCRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	_, err := contractFor(c).SubmitTransaction(function, msisdn, req.Tier, req.EvidenceHash, req.Remarks)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+func getProfile(c *gin.Context) {
+	msisdn, ok := msisdnParam(c)
+	if !ok {
+		return
+	}
+
+	result, err := contractFor(c).EvaluateTransaction("ReadSubscriberProfile", msisdn)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", result)
+}
+
+func updateProfile(c *gin.Context) {
+	msisdn, ok := msisdnParam(c)
+	if !ok {
+		return
+	}
+	var req ProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	profile, err := json.Marshal(req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err = contractFor(c).Submit("UpdateSubscriberProfile",
+		client.WithArguments(msisdn),
+		client.WithTransient(map[string][]byte{"profile": profile}))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Profile updated successfully"})
 }
 
 func updateStatus(c *gin.Context) {
-	msisdn := c.Param("msisdn")
+	msisdn, ok := msisdnParam(c)
+	if !ok {
+		return
+	}
 	var req UpdateStatusRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondWithBindError(c, err)
 		return
 	}
 
-	_, err := contract.SubmitTransaction("UpdateAssetStatus", msisdn, req.Status, req.Remarks)
+	_, err := contractFor(c).SubmitTransaction("UpdateAssetStatus", msisdn, req.Status, req.Remarks)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondWithError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Status updated successfully"})
 }
 
+func unlockAsset(c *gin.Context) {
+	msisdn, ok := msisdnParam(c)
+	if !ok {
+		return
+	}
+	var req UnlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	_, err := contractFor(c).SubmitTransaction("UnlockAsset", msisdn, req.Remarks)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Asset unlocked successfully"})
+}
+
 func deleteAsset(c *gin.Context) {
-	msisdn := c.Param("msisdn")
+	msisdn, ok := msisdnParam(c)
+	if !ok {
+		return
+	}
+	var query DeleteAssetQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
 
-	_, err := contract.SubmitTransaction("DeleteAsset", msisdn)
+	_, err := contractFor(c).SubmitTransaction("DeleteAsset", msisdn, query.SettlementMSISDN, query.Remarks)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Asset closed successfully"})
+}
+
+func restoreAsset(c *gin.Context) {
+	msisdn, ok := msisdnParam(c)
+	if !ok {
+		return
+	}
+	var req RestoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	_, err := contractFor(c).SubmitTransaction("RestoreAsset", msisdn, req.Remarks)
+	if err != nil {
+		respondWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Asset deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Asset restored successfully"})
 }
 
 func getTransactionHistory(c *gin.Context) {
-	msisdn := c.Param("msisdn")
+	msisdn, ok := msisdnParam(c)
+	if !ok {
+		return
+	}
+	var query PageQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
 
-	result, err := contract.EvaluateTransaction("GetTransactionHistory", msisdn)
+	var result []byte
+	var err error
+	if query.paginated() {
+		result, err = contractFor(c).EvaluateTransaction("GetTransactionHistoryWithPagination", msisdn, query.pageSize(), query.Bookmark)
+	} else {
+		result, err = contractFor(c).EvaluateTransaction("GetTransactionHistory", msisdn)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondWithError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", result)
+}
+
+func getAssetHistory(c *gin.Context) {
+	msisdn, ok := msisdnParam(c)
+	if !ok {
+		return
+	}
+
+	result, err := contractFor(c).EvaluateTransaction("GetAssetHistory", msisdn)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", result)
+}
+
+func createDealer(c *gin.Context) {
+	var req CreateDealerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	_, err := contractFor(c).SubmitTransaction("CreateDealer", req.ID, req.Name, req.MSPID,
+		req.CreditLimit.String(), req.Currency)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Dealer created successfully"})
+}
+
+func getDealer(c *gin.Context) {
+	id, ok := dealerIDParam(c)
+	if !ok {
+		return
+	}
+
+	result, err := contractFor(c).EvaluateTransaction("ReadDealer", id)
+	if err != nil {
+		if errorCode(err) != "" {
+			respondWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", result)
+}
+
+func updateDealer(c *gin.Context) {
+	id, ok := dealerIDParam(c)
+	if !ok {
+		return
+	}
+	var req UpdateDealerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	_, err := contractFor(c).SubmitTransaction("UpdateDealer", id, req.Name, req.MSPID, req.Status,
+		req.CreditLimit.String())
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Dealer updated successfully"})
+}
+
+func getDealerAssets(c *gin.Context) {
+	id, ok := dealerIDParam(c)
+	if !ok {
+		return
+	}
+	var filter AssetListQuery
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	result, err := contractFor(c).EvaluateTransaction("ListDealerAssets", id, strconv.FormatBool(filter.IncludeClosed))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", result)
+}
+
+func getDealerTransactions(c *gin.Context) {
+	id, ok := dealerIDParam(c)
+	if !ok {
+		return
+	}
+
+	result, err := contractFor(c).EvaluateTransaction("GetDealerTransactionHistory", id)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", result)
+}
+
+func distributeFloat(c *gin.Context) {
+	moveFloat(c, "DistributeFloat", "Float distributed successfully")
+}
+
+func reclaimFloat(c *gin.Context) {
+	moveFloat(c, "ReclaimFloat", "Float reclaimed successfully")
+}
+
+// moveFloat submits a float movement between the dealer in the path and the
+// asset in the request body
+func moveFloat(c *gin.Context, function, message string) {
+	id, ok := dealerIDParam(c)
+	if !ok {
+		return
+	}
+	var req FloatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	result, err := contractFor(c).SubmitTransaction(function, id, req.MSISDN, req.Amount.String(), req.Remarks)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respondWithTransaction(c, result, message)
+}
+
+func getTotalSupply(c *gin.Context) {
+	var query SupplyQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	result, err := contractFor(c).EvaluateTransaction("GetTotalSupply", query.Currency)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", result)
+}
+
+func issueFloat(c *gin.Context) {
+	changeSupply(c, "Issue", "Float issued successfully")
+}
+
+func burnFloat(c *gin.Context) {
+	changeSupply(c, "Burn", "Float burnt successfully")
+}
+
+// changeSupply submits an issue or burn of the dealer float named in the
+// request body
+func changeSupply(c *gin.Context, function, message string) {
+	var req SupplyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	result, err := contractFor(c).SubmitTransaction(function, req.DealerID, req.Amount.String(), req.Reference)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respondWithTransaction(c, result, message)
+}
+
+func checkSupply(c *gin.Context) {
+	result, err := contractFor(c).EvaluateTransaction("CheckSupply")
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", result)
+}
+
+func getLimitProfile(c *gin.Context) {
+	id := c.Param("id")
+	var query LimitProfileQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	result, err := contractFor(c).EvaluateTransaction("GetLimitProfile", id, query.Currency)
+	if err != nil {
+		respondWithError(c, err)
 		return
 	}
 
 	c.Data(http.StatusOK, "application/json", result)
 }
 
+func setLimitProfile(c *gin.Context) {
+	id := c.Param("id")
+	var req LimitProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	maxBalance := req.MaxBalance.String()
+	if maxBalance == "" {
+		maxBalance = "0"
+	}
+
+	_, err := contractFor(c).SubmitTransaction("SetLimitProfile", id, req.Currency,
+		req.PerTransaction.String(), req.Daily.String(), req.Monthly.String(), maxBalance)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Limit profile updated successfully"})
+}
+
+func getFeeSchedule(c *gin.Context) {
+	transType := c.Param("transType")
+	var query FeeScheduleQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	result, err := contractFor(c).EvaluateTransaction("GetFeeSchedule", transType, query.Currency)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", result)
+}
+
+func setFeeSchedule(c *gin.Context) {
+	transType := c.Param("transType")
+	var req FeeScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithBindError(c, err)
+		return
+	}
+
+	_, err := contractFor(c).SubmitTransaction("SetFeeSchedule", transType, req.Currency, string(req.Schedule))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Fee schedule updated successfully"})
+}
+
 func initLedger(c *gin.Context) {
-	_, err := contract.SubmitTransaction("InitLedger")
+	_, err := contractFor(c).SubmitTransaction("InitLedger")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondWithError(c, err)
 		return
 	}
 
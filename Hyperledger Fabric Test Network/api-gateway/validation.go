@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// These mirror the argument formats the chaincode enforces, so malformed
+// requests are refused before they are endorsed. The chaincode still checks
+// them, along with the per-currency decimal places and range of amounts.
+var (
+	msisdnPattern   = regexp.MustCompile(`^(\+[1-9][0-9]{6,14}|[0-9]{7,15})$`)
+	dealerIDPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_-]{2,31}$`)
+	mpinPattern     = regexp.MustCompile(`^[0-9]{4,6}$`)
+	amountPattern   = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+	// transactionIDPattern admits the "<msisdn>-<type>-<Fabric tx ID>" IDs the
+	// chaincode assigns to transactions and holds
+	transactionIDPattern = regexp.MustCompile(`^\+?[0-9]{7,15}-[A-Z_]+-[0-9A-Za-z]+$`)
+)
+
+// validationMessages describes each failed binding rule in field-level errors
+var validationMessages = map[string]string{
+	"required":       "is required",
+	"msisdn":         "must be an E.164 or national number",
+	"dealerid":       "must be 3 to 32 upper case letters, digits, '-' or '_'",
+	"mpin":           "must be 4 to 6 digits",
+	"amount":         "must be a non-negative decimal amount",
+	"positiveamount": "must be a positive decimal amount",
+	"transactionid":  "must be a transaction ID",
+	"uppercase":      "must be upper case",
+	"datetime":       "must be an RFC 3339 timestamp",
+	"required_with":  "is required with a balance bound",
+}
+
+// registerValidators adds the custom binding rules to gin's validator and
+// makes it report fields by their JSON names, or for query and path
+// parameters by their form and uri names
+func registerValidators() error {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return errors.New("unexpected binding validator engine")
+	}
+
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "" {
+			name = strings.SplitN(field.Tag.Get("form"), ",", 2)[0]
+		}
+		if name == "" {
+			name = strings.SplitN(field.Tag.Get("uri"), ",", 2)[0]
+		}
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	rules := map[string]validator.Func{
+		"msisdn":        matches(msisdnPattern),
+		"dealerid":      matches(dealerIDPattern),
+		"mpin":          matches(mpinPattern),
+		"amount":        matches(amountPattern),
+		"transactionid": matches(transactionIDPattern),
+		"positiveamount": func(fl validator.FieldLevel) bool {
+			value := fl.Field().String()
+			return amountPattern.MatchString(value) && strings.Trim(value, "0.") != ""
+		},
+	}
+	for tag, rule := range rules {
+		if err := v.RegisterValidation(tag, rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches returns a rule accepting string fields that match pattern
+func matches(pattern *regexp.Regexp) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		return pattern.MatchString(fl.Field().String())
+	}
+}
+
+// respondWithBindError reports a request that failed to bind. Failed
+// binding rules are listed per field, e.g. {"fields": {"mpin": "must be 4 to
+// 6 digits"}}; malformed JSON and query parameters of the wrong type are
+// reported as is.
+func respondWithBindError(c *gin.Context, err error) {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fields := map[string]string{}
+	for _, fieldError := range validationErrors {
+		message, ok := validationMessages[fieldError.Tag()]
+		switch {
+		case fieldError.Tag() == "oneof":
+			message = "must be one of: " + fieldError.Param()
+		case fieldError.Tag() == "len":
+			message = "must be " + fieldError.Param() + " characters long"
+		case !ok:
+			message = "is invalid"
+		}
+		fields[fieldError.Field()] = message
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "code": "INVALID_ARGUMENT", "fields": fields})
+}
+
+// msisdnParam returns the :msisdn path parameter, or reports it with 400 and
+// returns false if it is malformed
+func msisdnParam(c *gin.Context) (string, bool) {
+	var path AssetPath
+	if err := c.ShouldBindUri(&path); err != nil {
+		respondWithBindError(c, err)
+		return "", false
+	}
+	return path.MSISDN, true
+}
+
+// dealerIDParam returns the :id path parameter of dealer routes, or reports
+// it with 400 and returns false if it is malformed
+func dealerIDParam(c *gin.Context) (string, bool) {
+	var path DealerPath
+	if err := c.ShouldBindUri(&path); err != nil {
+		respondWithBindError(c, err)
+		return "", false
+	}
+	return path.ID, true
+}
+
+// transactionIDParam returns the :id path parameter of hold and transaction
+// routes, or reports it with 400 and returns false if it is malformed
+func transactionIDParam(c *gin.Context) (string, bool) {
+	var path TransactionPath
+	if err := c.ShouldBindUri(&path); err != nil {
+		respondWithBindError(c, err)
+		return "", false
+	}
+	return path.ID, true
+}
@@ -18,57 +18,179 @@ type SmartContract struct {
 // Insert struct field in alphabetic order => to achieve determinism across languages
 // golang keeps the order when marshal to json but doesn't order automatically
 type Asset struct {
-	Balance      float64   `json:"balance"`
-	DealerID     string    `json:"dealerId"`
-	MPIN         string    `json:"mpin"`
-	MSISDN       string    `json:"msisdn"`
-	Remarks      string    `json:"remarks"`
-	Status       string    `json:"status"`
-	TransAmount  float64   `json:"transAmount"`
-	TransType    string    `json:"transType"`
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
+	AvailableBalance   Money     `json:"availableBalance"`
+	Balance            Money     `json:"balance"`
+	DealerID           string    `json:"dealerId"`
+	DocType            string    `json:"docType"`
+	FailedMPINAttempts int       `json:"failedMpinAttempts"`
+	HeldBalance        Money     `json:"heldBalance"`
+	KYCTier            string    `json:"kycTier"`
+	MSISDN             string    `json:"msisdn"`
+	Remarks            string    `json:"remarks"`
+	Status             string    `json:"status"`
+	TransAmount        Money     `json:"transAmount"`
+	TransType          string    `json:"transType"`
+	CreatedAt          time.Time `json:"createdAt"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+}
+
+// MarshalJSON writes the timestamps in assetTimeLayout rather than the variable
+// width RFC 3339 form encoding/json uses for time.Time
+func (a Asset) MarshalJSON() ([]byte, error) {
+	type asset Asset
+	return json.Marshal(struct {
+		asset
+		CreatedAt string `json:"createdAt"`
+		UpdatedAt string `json:"updatedAt"`
+	}{asset(a), a.CreatedAt.UTC().Format(assetTimeLayout), a.UpdatedAt.UTC().Format(assetTimeLayout)})
 }
 
 // Transaction represents a transaction history entry
 type Transaction struct {
+	DocType     string    `json:"docType"`
 	ID          string    `json:"id"`
 	AssetID     string    `json:"assetId"`
 	TransType   string    `json:"transType"`
-	Amount      float64   `json:"amount"`
-	PrevBalance float64   `json:"prevBalance"`
-	NewBalance  float64   `json:"newBalance"`
+	Amount      Money     `json:"amount"`
+	PrevBalance Money     `json:"prevBalance"`
+	NewBalance  Money     `json:"newBalance"`
 	Remarks     string    `json:"remarks"`
 	Timestamp   time.Time `json:"timestamp"`
 	TxID        string    `json:"txId"`
+	// CounterpartyID and LinkedTxnID are only set on the two legs of a balance
+	// moved between assets, such as a transfer or a settlement. A FEE record
+	// links to the transaction the fee was charged on.
+	CounterpartyID string `json:"counterpartyId,omitempty" metadata:",optional"`
+	LinkedTxnID    string `json:"linkedTxnId,omitempty" metadata:",optional"`
+	// Actor, PrevStatus and NewStatus are only set on STATUS_CHANGE records;
+	// reversals carry an Actor too
+	Actor      string `json:"actor,omitempty" metadata:",optional"`
+	PrevStatus string `json:"prevStatus,omitempty" metadata:",optional"`
+	NewStatus  string `json:"newStatus,omitempty" metadata:",optional"`
+	// Status is REVERSED once ReverseTransaction has undone the transaction,
+	// and ReversedBy names the reversal record. ReversalOf is set on reversal
+	// records and names the record they undo.
+	Status     string `json:"status,omitempty" metadata:",optional"`
+	ReversedBy string `json:"reversedBy,omitempty" metadata:",optional"`
+	ReversalOf string `json:"reversalOf,omitempty" metadata:",optional"`
+	// PrevKYCTier, NewKYCTier and EvidenceHash are only set on KYC_CHANGE
+	// records, which carry an Actor too
+	PrevKYCTier  string `json:"prevKycTier,omitempty" metadata:",optional"`
+	NewKYCTier   string `json:"newKycTier,omitempty" metadata:",optional"`
+	EvidenceHash string `json:"evidenceHash,omitempty" metadata:",optional"`
 }
 
-// InitLedger adds a base set of assets to the ledger
+// Every record carries a docType so that scans and rich queries can tell the
+// entities sharing the chaincode namespace apart
+const (
+	assetDocType       = "asset"
+	transactionDocType = "transaction"
+)
+
+const (
+	transactionObjectType = "txn"
+	// transactionIDObjectType indexes transaction records by ID; the value is
+	// the composite key of the record
+	transactionIDObjectType = "txnid"
+	// transactionTimestampLayout is fixed width so that keys sort chronologically
+	transactionTimestampLayout = "2006-01-02T15:04:05.000000000Z"
+	// assetTimeLayout is the same fixed width UTC form, so that CouchDB's string
+	// comparison of updatedAt in QueryAssets orders assets chronologically
+	assetTimeLayout = transactionTimestampLayout
+	// legacyTransactionPrefix is the key prefix used before transaction records
+	// moved to composite keys
+	legacyTransactionPrefix = "TXN_"
+)
+
+// InitLedger adds a base set of dealers and assets to the ledger, and the
+// default limit profile of each KYC tier
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
-	assets := []Asset{
-		{DealerID: "DEALER001", MSISDN: "1234567890", MPIN: "1234", Balance: 1000.0, Status: "ACTIVE", TransAmount: 0, TransType: "INITIAL", Remarks: "Initial balance", CreatedAt: time.Now(), UpdatedAt: time.Now()},
-		{DealerID: "DEALER002", MSISDN: "1234567891", MPIN: "5678", Balance: 2000.0, Status: "ACTIVE", TransAmount: 0, TransType: "INITIAL", Remarks: "Initial balance", CreatedAt: time.Now(), UpdatedAt: time.Now()},
-		{DealerID: "DEALER003", MSISDN: "1234567892", MPIN: "9012", Balance: 1500.0, Status: "ACTIVE", TransAmount: 0, TransType: "INITIAL", Remarks: "Initial balance", CreatedAt: time.Now(), UpdatedAt: time.Now()},
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
 	}
 
-	for _, asset := range assets {
-		assetJSON, err := json.Marshal(asset)
+	dealers := []Dealer{
+		{ID: "DEALER001", Name: "Dealer One", MSPID: adminMSPID, Status: dealerStatusActive, CreditLimit: Money{Currency: defaultCurrency}, FloatBalance: Money{Currency: defaultCurrency}, CreatedAt: now, UpdatedAt: now},
+		{ID: "DEALER002", Name: "Dealer Two", MSPID: adminMSPID, Status: dealerStatusActive, CreditLimit: Money{Currency: defaultCurrency}, FloatBalance: Money{Currency: defaultCurrency}, CreatedAt: now, UpdatedAt: now},
+		{ID: "DEALER003", Name: "Dealer Three", MSPID: adminMSPID, Status: dealerStatusActive, CreditLimit: Money{Currency: defaultCurrency}, FloatBalance: Money{Currency: defaultCurrency}, CreatedAt: now, UpdatedAt: now},
+	}
+	for i := range dealers {
+		err = s.putDealer(ctx, &dealers[i])
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to put to world state. %v", err)
 		}
+	}
+
+	profiles := defaultLimitProfiles(now)
+	for i := range profiles {
+		err = s.putLimitProfile(ctx, &profiles[i])
+		if err != nil {
+			return fmt.Errorf("failed to put to world state. %v", err)
+		}
+	}
+
+	zero := Money{Currency: defaultCurrency}
+	assets := []Asset{
+		{DealerID: "DEALER001", MSISDN: "1234567890", Balance: Money{100000, defaultCurrency}, KYCTier: kycTier2, Status: statusActive, TransAmount: zero, TransType: "INITIAL", Remarks: "Initial balance", CreatedAt: now, UpdatedAt: now},
+		{DealerID: "DEALER002", MSISDN: "1234567891", Balance: Money{200000, defaultCurrency}, KYCTier: kycTier2, Status: statusActive, TransAmount: zero, TransType: "INITIAL", Remarks: "Initial balance", CreatedAt: now, UpdatedAt: now},
+		{DealerID: "DEALER003", MSISDN: "1234567892", Balance: Money{150000, defaultCurrency}, KYCTier: kycTier2, Status: statusActive, TransAmount: zero, TransType: "INITIAL", Remarks: "Initial balance", CreatedAt: now, UpdatedAt: now},
+	}
+	mpins := map[string]string{
+		"1234567890": "1234",
+		"1234567891": "5678",
+		"1234567892": "9012",
+	}
 
-		err = ctx.GetStub().PutState(asset.MSISDN, assetJSON)
+	for i := range assets {
+		asset := &assets[i]
+		err = s.putAsset(ctx, asset)
 		if err != nil {
 			return fmt.Errorf("failed to put to world state. %v", err)
 		}
+
+		err = s.setMPIN(ctx, asset.MSISDN, mpins[asset.MSISDN])
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
+	// The initial balances are the only float issued on a fresh ledger
+	return s.putIssued(ctx, Money{450000, defaultCurrency})
 }
 
 // CreateAsset issues a new asset to the world state with given details.
-func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface, msisdn, dealerId, mpin string, balance float64, status, remarks string) error {
-	exists, err := s.AssetExists(ctx, msisdn)
+// The opening balance is a decimal string in the given ISO-4217 currency and
+// must be zero. The MPIN is passed in the transient map so that it is never
+// written to the block.
+func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface, msisdn, dealerId, balance, currency, status, remarks string) error {
+	c, err := authorize(ctx, roleAdmin, roleDealer)
+	if err != nil {
+		return err
+	}
+	if err := validateMSISDN(msisdn); err != nil {
+		return err
+	}
+	if err := validateDealerID(dealerId); err != nil {
+		return err
+	}
+	if !c.canAccessDealer(dealerId) {
+		return newContractError(errForbidden, "dealer %s cannot create assets for dealer %s", c.DealerID, dealerId)
+	}
+
+	mpin, err := transientMPIN(ctx)
+	if err != nil {
+		return err
+	}
+	if err := validateMPIN(mpin); err != nil {
+		return err
+	}
+
+	exists, err := s.assetExists(ctx, msisdn)
 	if err != nil {
 		return err
 	}
@@ -76,24 +198,52 @@ func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface,
 		return fmt.Errorf("the asset %s already exists", msisdn)
 	}
 
+	dealer, err := s.requireActiveDealer(ctx, dealerId)
+	if err != nil {
+		return err
+	}
+	if c.Role == roleDealer && c.MSPID != dealer.MSPID {
+		return newContractError(errForbidden, "dealer %s belongs to %s, not %s", dealerId, dealer.MSPID, c.MSPID)
+	}
+
+	// New assets start either pending KYC or active
+	if status != statusPendingKYC && status != statusActive {
+		return newContractError(errInvalidStatus, "new assets must be %s or %s, not %q", statusPendingKYC, statusActive, status)
+	}
+
+	// Value only enters the system through Issue, so assets open empty and
+	// are funded with DistributeFloat
+	openingBalance, err := ParseMoney(balance, currency)
+	if err != nil {
+		return err
+	}
+	if openingBalance.IsPositive() {
+		return newContractError(errInvalidArgument, "new assets must open with a zero balance; fund them with DistributeFloat")
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
 	asset := Asset{
 		DealerID:    dealerId,
 		MSISDN:      msisdn,
-		MPIN:        mpin,
-		Balance:     balance,
+		Balance:     openingBalance,
+		KYCTier:     kycTier0,
 		Status:      status,
-		TransAmount: 0,
+		TransAmount: Money{Currency: currency},
 		TransType:   "CREATE",
 		Remarks:     remarks,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
 	}
-	assetJSON, err := json.Marshal(asset)
+	err = s.putAsset(ctx, &asset)
 	if err != nil {
 		return err
 	}
-
-	err = ctx.GetStub().PutState(msisdn, assetJSON)
+	// Only a salted hash of the MPIN is kept on the ledger
+	err = s.setMPIN(ctx, msisdn, mpin)
 	if err != nil {
 		return err
 	}
@@ -101,22 +251,45 @@ func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface,
 	// Record the creation transaction
 	txID := ctx.GetStub().GetTxID()
 	transaction := Transaction{
-		ID:          fmt.Sprintf("%s-CREATE-%d", msisdn, time.Now().Unix()),
+		ID:          transactionID(ctx, msisdn, "CREATE"),
 		AssetID:     msisdn,
 		TransType:   "CREATE",
-		Amount:      balance,
-		PrevBalance: 0,
-		NewBalance:  balance,
+		Amount:      openingBalance,
+		PrevBalance: Money{Currency: currency},
+		NewBalance:  openingBalance,
 		Remarks:     remarks,
-		Timestamp:   time.Now(),
+		Timestamp:   now,
 		TxID:        txID,
 	}
 
-	return s.recordTransaction(ctx, transaction)
+	err = s.recordTransaction(ctx, transaction)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, eventAssetCreated, transaction)
 }
 
 // ReadAsset returns the asset stored in the world state with given id.
 func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, msisdn string) (*Asset, error) {
+	c, err := authorize(ctx, roleAdmin, roleDealer, roleAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	asset, err := s.readAsset(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkAsset(asset); err != nil {
+		return nil, err
+	}
+
+	return asset, nil
+}
+
+// readAsset loads an asset without checking the caller's access to it
+func (s *SmartContract) readAsset(ctx contractapi.TransactionContextInterface, msisdn string) (*Asset, error) {
 	assetJSON, err := ctx.GetStub().GetState(msisdn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from world state: %v", err)
@@ -125,113 +298,466 @@ func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, m
 		return nil, fmt.Errorf("the asset %s does not exist", msisdn)
 	}
 
-	var asset Asset
-	err = json.Unmarshal(assetJSON, &asset)
+	asset, ok, err := assetFromRecord(msisdn, assetJSON)
 	if err != nil {
 		return nil, err
 	}
+	if !ok {
+		return nil, fmt.Errorf("the asset %s does not exist", msisdn)
+	}
+	if err := s.excludeExpiredHolds(ctx, asset); err != nil {
+		return nil, err
+	}
 
-	return &asset, nil
+	return asset, nil
 }
 
 // UpdateAssetBalance updates the balance of an existing asset in the world state.
-func (s *SmartContract) UpdateAssetBalance(ctx contractapi.TransactionContextInterface, msisdn, mpin string, amount float64, transType, remarks string) error {
-	asset, err := s.ReadAsset(ctx, msisdn)
+// It returns the recorded transaction. A wrong MPIN is not an error: the failed
+// attempt is committed and returned as an MPIN_FAILED transaction instead.
+// The amount is a decimal string in the asset's currency. Any DEBIT fee is
+// charged on top of it and recorded as a linked FEE transaction. The MPIN is
+// read from the transient map.
+func (s *SmartContract) UpdateAssetBalance(ctx contractapi.TransactionContextInterface, msisdn, amount, transType, remarks string) (*Transaction, error) {
+	c, err := authorize(ctx, roleAdmin, roleDealer, roleAgent)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := validateBalanceTransType(transType); err != nil {
+		return nil, err
+	}
+
+	asset, err := s.readAsset(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkAsset(asset); err != nil {
+		return nil, err
+	}
+
+	// Malformed requests are refused before they can count as an MPIN attempt
+	value, err := ParseMoney(amount, asset.Balance.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if !value.IsPositive() {
+		return nil, newContractError(errInvalidArgument, "amount must be positive")
 	}
 
 	// Verify MPIN
-	if asset.MPIN != mpin {
-		return fmt.Errorf("invalid MPIN for asset %s", msisdn)
+	rejected, err := s.checkMPIN(ctx, asset)
+	if err != nil || rejected != nil {
+		return rejected, err
 	}
 
 	// Check if account is active
-	if asset.Status != "ACTIVE" {
-		return fmt.Errorf("account %s is not active", msisdn)
+	if asset.Status != statusActive {
+		return nil, newContractError(errInvalidStatus, "account %s is not active", msisdn)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	prevBalance := asset.Balance
 
-	// Update balance based on transaction type
+	// Update balance based on transaction type. A debit pays value out of the
+	// system, so it burns float. Credits would create value with nothing
+	// debited in return; only Issue may do that.
+	var issuedDelta, fee Money
+	var feeCollector *Asset
 	switch transType {
 	case "CREDIT":
-		asset.Balance += amount
+		return nil, newContractError(errInvalidArgument, "CREDIT requires a debited counterparty; use TransferBalance or DistributeFloat")
 	case "DEBIT":
-		if asset.Balance < amount {
-			return fmt.Errorf("insufficient balance. Current balance: %.2f, Requested: %.2f", asset.Balance, amount)
+		issuedDelta = Money{Amount: -value.Amount, Currency: value.Currency}
+		fee, feeCollector, err = s.assessFee(ctx, transType, asset, value)
+		if err != nil {
+			return nil, err
+		}
+		total, err := value.Add(fee)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkAvailable(asset, total); err != nil {
+			return nil, err
+		}
+		if err := s.useLimits(ctx, asset, value, now); err != nil {
+			return nil, err
+		}
+		asset.Balance, err = asset.Balance.Sub(value)
+		if err != nil {
+			return nil, err
 		}
-		asset.Balance -= amount
-	default:
-		return fmt.Errorf("invalid transaction type: %s", transType)
 	}
 
-	asset.TransAmount = amount
+	asset.TransAmount = value
 	asset.TransType = transType
 	asset.Remarks = remarks
-	asset.UpdatedAt = time.Now()
+	asset.UpdatedAt = now
 
-	assetJSON, err := json.Marshal(asset)
+	err = s.putAsset(ctx, asset)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	err = ctx.GetStub().PutState(msisdn, assetJSON)
+	err = s.adjustIssued(ctx, issuedDelta)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Record the transaction
 	txID := ctx.GetStub().GetTxID()
 	transaction := Transaction{
-		ID:          fmt.Sprintf("%s-%s-%d", msisdn, transType, time.Now().Unix()),
+		ID:          transactionID(ctx, msisdn, transType),
 		AssetID:     msisdn,
 		TransType:   transType,
-		Amount:      amount,
+		Amount:      value,
 		PrevBalance: prevBalance,
 		NewBalance:  asset.Balance,
 		Remarks:     remarks,
-		Timestamp:   time.Now(),
+		Timestamp:   now,
 		TxID:        txID,
 	}
 
-	return s.recordTransaction(ctx, transaction)
+	err = s.recordTransaction(ctx, transaction)
+	if err != nil {
+		return nil, err
+	}
+	if feeCollector != nil {
+		err = s.chargeFee(ctx, asset, feeCollector, fee, &transaction, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = emitEvent(ctx, eventBalanceChanged, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transaction, nil
+}
+
+// TransferBalance moves amount from one asset to another. Both balances and the
+// linked pair of transaction records are written in the same Fabric transaction,
+// so either the whole transfer commits or none of it does. The sender's leg is
+// returned; like UpdateAssetBalance, a wrong MPIN yields an MPIN_FAILED record.
+// The sender also pays any TRANSFER fee, as a linked FEE record. The sender's
+// MPIN is read from the transient map.
+func (s *SmartContract) TransferBalance(ctx contractapi.TransactionContextInterface, fromMsisdn, toMsisdn, amount, remarks string) (*Transaction, error) {
+	if fromMsisdn == toMsisdn {
+		return nil, newContractError(errInvalidArgument, "cannot transfer from asset %s to itself", fromMsisdn)
+	}
+
+	c, err := authorize(ctx, roleAdmin, roleDealer, roleAgent)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMSISDN(fromMsisdn); err != nil {
+		return nil, err
+	}
+	if err := validateMSISDN(toMsisdn); err != nil {
+		return nil, err
+	}
+
+	// Dealers may send from their own assets to any asset
+	from, err := s.readAsset(ctx, fromMsisdn)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkAsset(from); err != nil {
+		return nil, err
+	}
+	to, err := s.readAsset(ctx, toMsisdn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Malformed requests are refused before they can count as an MPIN attempt
+	value, err := ParseMoney(amount, from.Balance.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if !value.IsPositive() {
+		return nil, newContractError(errInvalidArgument, "transfer amount must be positive")
+	}
+
+	// Verify MPIN of the sender
+	rejected, err := s.checkMPIN(ctx, from)
+	if err != nil || rejected != nil {
+		return rejected, err
+	}
+
+	// Both sides of the transfer must be active
+	if from.Status != statusActive {
+		return nil, newContractError(errInvalidStatus, "account %s is not active", fromMsisdn)
+	}
+	if err := checkCanTransfer(from); err != nil {
+		return nil, err
+	}
+	if to.Status != statusActive {
+		return nil, newContractError(errInvalidStatus, "account %s is not active", toMsisdn)
+	}
+	if from.Balance.Currency != to.Balance.Currency {
+		return nil, newContractError(errInvalidArgument, "cannot transfer from a %s account to a %s account", from.Balance.Currency, to.Balance.Currency)
+	}
+
+	// The sender pays the fee on top of the amount
+	fee, feeCollector, err := s.assessFee(ctx, "TRANSFER", from, value, to)
+	if err != nil {
+		return nil, err
+	}
+	total, err := value.Add(fee)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAvailable(from, total); err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The transfer counts against the limits of both accounts
+	if err := s.useLimits(ctx, from, value, now); err != nil {
+		return nil, err
+	}
+	if err := s.useLimits(ctx, to, value, now); err != nil {
+		return nil, err
+	}
+	if err := s.checkMaxBalance(ctx, to, value); err != nil {
+		return nil, err
+	}
+
+	debit, err := s.moveBalance(ctx, from, to, value, "TRANSFER", remarks, now)
+	if err != nil {
+		return nil, err
+	}
+	if feeCollector != nil {
+		if err := s.chargeFee(ctx, from, feeCollector, fee, debit, now); err != nil {
+			return nil, err
+		}
+	}
+	if err := emitEvent(ctx, eventBalanceChanged, *debit); err != nil {
+		return nil, err
+	}
+
+	return debit, nil
 }
 
-// UpdateAssetStatus updates the status of an existing asset.
+// moveBalance debits value from one asset and credits it to another, writes
+// both assets and records a linked pair of <kind>_OUT and <kind>_IN
+// transactions. It returns the debit leg. Callers check status and funds first.
+func (s *SmartContract) moveBalance(ctx contractapi.TransactionContextInterface, from, to *Asset, value Money, kind, remarks string, now time.Time) (*Transaction, error) {
+	var err error
+	fromPrev := from.Balance
+	toPrev := to.Balance
+	if from.Balance, err = from.Balance.Sub(value); err != nil {
+		return nil, err
+	}
+	if to.Balance, err = to.Balance.Add(value); err != nil {
+		return nil, err
+	}
+
+	for _, asset := range []*Asset{from, to} {
+		asset.TransAmount = value
+		asset.Remarks = remarks
+		asset.UpdatedAt = now
+	}
+	from.TransType = kind + "_OUT"
+	to.TransType = kind + "_IN"
+
+	if err := s.putAsset(ctx, from); err != nil {
+		return nil, err
+	}
+	if err := s.putAsset(ctx, to); err != nil {
+		return nil, err
+	}
+
+	// Record both legs, each pointing at the other
+	txID := ctx.GetStub().GetTxID()
+	debit := Transaction{
+		ID:             transactionID(ctx, from.MSISDN, from.TransType),
+		AssetID:        from.MSISDN,
+		TransType:      from.TransType,
+		Amount:         value,
+		PrevBalance:    fromPrev,
+		NewBalance:     from.Balance,
+		Remarks:        remarks,
+		Timestamp:      now,
+		TxID:           txID,
+		CounterpartyID: to.MSISDN,
+	}
+	credit := Transaction{
+		ID:             transactionID(ctx, to.MSISDN, to.TransType),
+		AssetID:        to.MSISDN,
+		TransType:      to.TransType,
+		Amount:         value,
+		PrevBalance:    toPrev,
+		NewBalance:     to.Balance,
+		Remarks:        remarks,
+		Timestamp:      now,
+		TxID:           txID,
+		CounterpartyID: from.MSISDN,
+	}
+	debit.LinkedTxnID = credit.ID
+	credit.LinkedTxnID = debit.ID
+
+	if err := s.recordTransaction(ctx, debit); err != nil {
+		return nil, err
+	}
+	if err := s.recordTransaction(ctx, credit); err != nil {
+		return nil, err
+	}
+
+	return &debit, nil
+}
+
+// UpdateAssetStatus moves an asset to newStatus if the status state machine
+// allows it and records a STATUS_CHANGE transaction with the reason given in
+// remarks.
 func (s *SmartContract) UpdateAssetStatus(ctx contractapi.TransactionContextInterface, msisdn, newStatus, remarks string) error {
-	asset, err := s.ReadAsset(ctx, msisdn)
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return err
+	}
+
+	asset, err := s.readAsset(ctx, msisdn)
 	if err != nil {
 		return err
 	}
+	if remarks == "" {
+		return newContractError(errInvalidArgument, "a reason is required to change the status of account %s", msisdn)
+	}
+	// Closing and reopening go through DeleteAsset and RestoreAsset, which
+	// settle the balance
+	if asset.Status == statusClosed {
+		return newContractError(errInvalidTransition, "account %s is closed; use RestoreAsset to reopen it", msisdn)
+	}
+	if newStatus == statusClosed {
+		return newContractError(errInvalidTransition, "use DeleteAsset to close account %s", msisdn)
+	}
+	// Reactivating a locked account goes through UnlockAsset
+	if asset.Status == statusLocked && newStatus == statusActive {
+		return newContractError(errInvalidTransition, "account %s is locked; use UnlockAsset to reactivate it", msisdn)
+	}
 
-	asset.Status = newStatus
-	asset.Remarks = remarks
-	asset.UpdatedAt = time.Now()
+	transaction, err := s.changeStatus(ctx, asset, newStatus, remarks)
+	if err != nil {
+		return err
+	}
 
-	assetJSON, err := json.Marshal(asset)
+	return emitEvent(ctx, eventStatusChanged, *transaction)
+}
+
+// DeleteAsset closes an asset. Assets are never removed from the world state:
+// a CLOSED asset keeps its history and MSISDN, so it cannot be recreated with a
+// fresh balance, and it can be reopened with RestoreAsset. An asset with a
+// remaining balance can only be closed by settling it to settlementMsisdn,
+// which is recorded as a linked SETTLEMENT_OUT/SETTLEMENT_IN pair. The
+// settlement counts against the limits and maximum balance of the receiving
+// account, but not of the account being closed: an admin closing it must be
+// able to pay out all of its balance.
+func (s *SmartContract) DeleteAsset(ctx contractapi.TransactionContextInterface, msisdn, settlementMsisdn, remarks string) error {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return err
+	}
+
+	asset, err := s.readAsset(ctx, msisdn)
 	if err != nil {
 		return err
 	}
+	if asset.Status == statusClosed {
+		return newContractError(errInvalidTransition, "account %s is already closed", msisdn)
+	}
+	if !canTransition(asset.Status, statusClosed) {
+		return newContractError(errInvalidTransition, "cannot change account %s from %s to %s", msisdn, asset.Status, statusClosed)
+	}
+
+	if asset.HeldBalance.IsPositive() {
+		return newContractError(errInvalidStatus, "account %s has %s held; capture or release its holds to close it", msisdn, asset.HeldBalance)
+	}
 
-	return ctx.GetStub().PutState(msisdn, assetJSON)
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if asset.Balance.IsPositive() {
+		if settlementMsisdn == "" {
+			return newContractError(errInvalidStatus, "account %s has a balance of %s; settle it to another account to close it", msisdn, asset.Balance)
+		}
+		if settlementMsisdn == msisdn {
+			return newContractError(errInvalidArgument, "cannot settle asset %s to itself", msisdn)
+		}
+
+		settlement, err := s.readAsset(ctx, settlementMsisdn)
+		if err != nil {
+			return err
+		}
+		if settlement.Status != statusActive {
+			return newContractError(errInvalidStatus, "account %s is not active", settlementMsisdn)
+		}
+		if settlement.Balance.Currency != asset.Balance.Currency {
+			return newContractError(errInvalidArgument, "currency mismatch: %s and %s", asset.Balance.Currency, settlement.Balance.Currency)
+		}
+		if err := s.useLimits(ctx, settlement, asset.Balance, now); err != nil {
+			return err
+		}
+		if err := s.checkMaxBalance(ctx, settlement, asset.Balance); err != nil {
+			return err
+		}
+
+		_, err = s.moveBalance(ctx, asset, settlement, asset.Balance, "SETTLEMENT", remarks, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	transaction, err := s.changeStatus(ctx, asset, statusClosed, remarks)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, eventAssetDeleted, *transaction)
 }
 
-// DeleteAsset deletes an given asset from the world state.
-func (s *SmartContract) DeleteAsset(ctx contractapi.TransactionContextInterface, msisdn string) error {
-	exists, err := s.AssetExists(ctx, msisdn)
+// RestoreAsset reopens a CLOSED asset as ACTIVE and records the STATUS_CHANGE.
+// The asset keeps its MPIN and starts again from a zero balance.
+func (s *SmartContract) RestoreAsset(ctx contractapi.TransactionContextInterface, msisdn, remarks string) error {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return err
+	}
+
+	asset, err := s.readAsset(ctx, msisdn)
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return fmt.Errorf("the asset %s does not exist", msisdn)
+	if asset.Status != statusClosed {
+		return newContractError(errInvalidTransition, "account %s is not closed", msisdn)
+	}
+
+	transaction, err := s.changeStatus(ctx, asset, statusActive, remarks)
+	if err != nil {
+		return err
 	}
 
-	return ctx.GetStub().DelState(msisdn)
+	return emitEvent(ctx, eventStatusChanged, *transaction)
 }
 
 // AssetExists returns true when asset with given ID exists in world state
 func (s *SmartContract) AssetExists(ctx contractapi.TransactionContextInterface, msisdn string) (bool, error) {
+	if _, err := authorize(ctx, roleAdmin, roleDealer, roleAgent); err != nil {
+		return false, err
+	}
+
+	return s.assetExists(ctx, msisdn)
+}
+
+// assetExists reports whether anything is stored under msisdn
+func (s *SmartContract) assetExists(ctx contractapi.TransactionContextInterface, msisdn string) (bool, error) {
 	assetJSON, err := ctx.GetStub().GetState(msisdn)
 	if err != nil {
 		return false, fmt.Errorf("failed to read from world state: %v", err)
@@ -240,8 +766,14 @@ func (s *SmartContract) AssetExists(ctx contractapi.TransactionContextInterface,
 	return assetJSON != nil, nil
 }
 
-// GetAllAssets returns all assets found in world state
-func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface) ([]*Asset, error) {
+// GetAllAssets returns all assets found in world state. CLOSED assets are
+// left out unless includeClosed is set, and dealers only see their own assets.
+func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface, includeClosed bool) ([]*Asset, error) {
+	c, err := authorize(ctx, roleAdmin, roleDealer, roleAgent)
+	if err != nil {
+		return nil, err
+	}
+
 	// range query with empty string for startKey and endKey does an
 	// open-ended query of all assets in the chaincode namespace.
 	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
@@ -257,35 +789,175 @@ func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface
 			return nil, err
 		}
 
-		var asset Asset
-		err = json.Unmarshal(queryResponse.Value, &asset)
+		asset, ok, err := assetFromRecord(queryResponse.Key, queryResponse.Value)
 		if err != nil {
 			return nil, err
 		}
-		assets = append(assets, &asset)
+		if !ok || (asset.Status == statusClosed && !includeClosed) || !c.canAccessDealer(asset.DealerID) {
+			continue
+		}
+		if err := s.excludeExpiredHolds(ctx, asset); err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
 	}
 
 	return assets, nil
 }
 
+// txTimestamp returns the timestamp the client set in the transaction proposal.
+// Unlike time.Now it is the same on every endorsing peer, so all times and IDs
+// the contract writes must be derived from it.
+func txTimestamp(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+	if timestamp == nil {
+		return time.Time{}, fmt.Errorf("transaction timestamp is not set")
+	}
+
+	return timestamp.AsTime(), nil
+}
+
+// assetFromRecord decodes a world state record as an Asset. It reports false
+// when the record under key is some other entity. Assets written before docType
+// was introduced are recognised by their MSISDN matching the key.
+func assetFromRecord(key string, value []byte) (*Asset, bool, error) {
+	var asset Asset
+	if err := json.Unmarshal(value, &asset); err != nil {
+		return nil, false, fmt.Errorf("failed to read record %s: %v", key, err)
+	}
+	if asset.DocType != assetDocType && (asset.DocType != "" || asset.MSISDN != key) {
+		return nil, false, nil
+	}
+
+	asset.DocType = assetDocType
+	if err := setAvailableBalance(&asset, Money{}); err != nil {
+		return nil, false, err
+	}
+	return &asset, true, nil
+}
+
+// putAsset writes an asset to the world state under its MSISDN and keeps it in
+// its dealer's index
+func (s *SmartContract) putAsset(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	asset.DocType = assetDocType
+	if err := setAvailableBalance(asset, Money{}); err != nil {
+		return err
+	}
+	assetJSON, err := json.Marshal(asset)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(asset.MSISDN, assetJSON)
+	if err != nil {
+		return err
+	}
+	return indexDealerAsset(ctx, asset)
+}
+
+// transactionID returns the ID of the transType record written for msisdn by
+// the current transaction. The Fabric transaction ID makes it unique even when
+// several transactions touch the same asset within one second.
+func transactionID(ctx contractapi.TransactionContextInterface, msisdn, transType string) string {
+	return fmt.Sprintf("%s-%s-%s", msisdn, transType, ctx.GetStub().GetTxID())
+}
+
+// transactionKey returns the composite key a transaction record is stored under.
+// Records sort by asset and then by timestamp, and the transaction type keeps
+// two records written for the same asset in one Fabric transaction apart.
+func transactionKey(ctx contractapi.TransactionContextInterface, transaction Transaction) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(transactionObjectType, []string{
+		transaction.AssetID,
+		transaction.Timestamp.UTC().Format(transactionTimestampLayout),
+		transaction.TxID,
+		transaction.TransType,
+	})
+}
+
 // recordTransaction records a transaction in the ledger
 func (s *SmartContract) recordTransaction(ctx contractapi.TransactionContextInterface, transaction Transaction) error {
+	transaction.DocType = transactionDocType
 	transactionJSON, err := json.Marshal(transaction)
 	if err != nil {
 		return err
 	}
 
-	transactionKey := fmt.Sprintf("TXN_%s", transaction.ID)
-	return ctx.GetStub().PutState(transactionKey, transactionJSON)
+	key, err := transactionKey(ctx, transaction)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(key, transactionJSON); err != nil {
+		return err
+	}
+
+	// The ID lacks the timestamp the record is keyed by, so index it to let
+	// readTransaction find the record without scanning the asset's history
+	indexKey, err := ctx.GetStub().CreateCompositeKey(transactionIDObjectType, []string{transaction.ID})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(indexKey, []byte(key))
 }
 
-// GetTransactionHistory returns the transaction history for a given asset
+// MigrateTransactions moves transaction records stored under the legacy
+// TXN_<id> keys to their composite keys. It returns the number of records moved
+// and is safe to run more than once.
+func (s *SmartContract) MigrateTransactions(ctx contractapi.TransactionContextInterface) (int, error) {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return 0, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange(legacyTransactionPrefix, legacyTransactionPrefix+"\uffff")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var transaction Transaction
+		if err := json.Unmarshal(queryResponse.Value, &transaction); err != nil {
+			return 0, fmt.Errorf("failed to read transaction %s: %v", queryResponse.Key, err)
+		}
+		if transaction.TxID == "" {
+			// Keep records that predate TxID apart by their old ID
+			transaction.TxID = transaction.ID
+		}
+
+		if err := s.recordTransaction(ctx, transaction); err != nil {
+			return 0, err
+		}
+		if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+			return 0, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// GetTransactionHistory returns the transaction history for a given asset,
+// oldest first
 func (s *SmartContract) GetTransactionHistory(ctx contractapi.TransactionContextInterface, msisdn string) ([]*Transaction, error) {
-	// Use range query to get all transaction records for this asset
-	startKey := fmt.Sprintf("TXN_%s-", msisdn)
-	endKey := fmt.Sprintf("TXN_%s~", msisdn)
+	if err := s.authorizeAssetRecords(ctx, msisdn); err != nil {
+		return nil, err
+	}
+
+	return s.transactionHistory(ctx, msisdn)
+}
 
-	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+// transactionHistory returns the transactions recorded under an asset or
+// dealer ID, oldest first
+func (s *SmartContract) transactionHistory(ctx contractapi.TransactionContextInterface, id string) ([]*Transaction, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(transactionObjectType, []string{id})
 	if err != nil {
 		return nil, err
 	}
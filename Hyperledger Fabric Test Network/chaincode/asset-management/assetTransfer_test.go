@@ -1,15 +1,21 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
-	"fmt"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric-samples/asset-management/mocks"
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 //go:generate counterfeiter -o mocks/transaction.go -fake-name TransactionContext . transactionContext
@@ -27,59 +33,83 @@ type stateQueryIterator interface {
 	shim.StateQueryIteratorInterface
 }
 
+//go:generate counterfeiter -o mocks/clientidentity.go -fake-name ClientIdentity . clientIdentity
+type clientIdentity interface {
+	cid.ClientIdentity
+}
+
+//go:generate counterfeiter -o mocks/historyqueryiterator.go -fake-name HistoryQueryIterator . historyQueryIterator
+type historyQueryIterator interface {
+	shim.HistoryQueryIteratorInterface
+}
+
+// testTxTimestamp is the proposal timestamp every fake stub reports
+var testTxTimestamp = time.Date(2024, time.January, 15, 10, 30, 0, 0, time.UTC)
+
+// testClientID is the X.509 identity of the client every fake world state reports
+const testClientID = "x509::CN=User1@org1.example.com::CN=ca.org1.example.com"
+
+func TestContractMetadata(t *testing.T) {
+	// contractapi rejects functions whose parameter or return types it cannot describe
+	_, err := contractapi.NewChaincode(&SmartContract{})
+	assert.Nil(t, err)
+}
+
 func TestInitLedger(t *testing.T) {
 	chaincodeStub := &mocks.ChaincodeStub{}
 	transactionContext := &mocks.TransactionContext{}
 	transactionContext.GetStubReturns(chaincodeStub)
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleAdmin, ""))
+	chaincodeStub.GetTxTimestampReturns(timestamppb.New(testTxTimestamp), nil)
 
 	assetTransfer := SmartContract{}
 	err := assetTransfer.InitLedger(transactionContext)
 	assert.Nil(t, err)
 
 	chaincodeStub.PutStateReturns(nil)
-	assert.Equal(t, 3, chaincodeStub.PutStateCallCount())
+	// Three dealers, three tier limit profiles, each asset together with its
+	// dealer index entry, and the issued float counter. The MPIN hashes are
+	// private data.
+	assert.Equal(t, 13, chaincodeStub.PutStateCallCount())
+	assert.Equal(t, 3, chaincodeStub.PutPrivateDataCallCount())
 }
 
 func TestCreateAsset(t *testing.T) {
-	chaincodeStub := &mocks.ChaincodeStub{}
-	transactionContext := &mocks.TransactionContext{}
-	transactionContext.GetStubReturns(chaincodeStub)
+	transactionContext, _, _ := newWorldState()
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER001", MSPID: "Org1MSP", Status: "ACTIVE"})
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER002", MSPID: "Org1MSP", Status: "SUSPENDED"})
 
 	assetTransfer := SmartContract{}
-	
-	// Test successful asset creation
-	chaincodeStub.GetStateReturns(nil, nil) // Asset doesn't exist
-	chaincodeStub.PutStateReturns(nil)
-	chaincodeStub.GetTxIDReturns("txid123")
 
-	err := assetTransfer.CreateAsset(transactionContext, "1234567890", "DEALER001", "1234", 1000.0, "ACTIVE", "Test asset")
+	// Assets open empty; value only enters the system through Issue
+	err := assetTransfer.CreateAsset(withMPIN(transactionContext, "1234"), "1234567890", "DEALER001", "1000.00", "USD", "ACTIVE", "Test asset")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: new assets must open with a zero balance; fund them with DistributeFloat")
+
+	// Test successful asset creation
+	err = assetTransfer.CreateAsset(withMPIN(transactionContext, "1234"), "1234567890", "DEALER001", "0.00", "USD", "ACTIVE", "Test asset")
 	assert.Nil(t, err)
 
 	// Test asset already exists
-	existingAsset := Asset{
-		DealerID: "DEALER001",
-		MSISDN:   "1234567890",
-		MPIN:     "1234",
-		Balance:  1000.0,
-		Status:   "ACTIVE",
-	}
-	existingAssetBytes, _ := json.Marshal(existingAsset)
-	chaincodeStub.GetStateReturns(existingAssetBytes, nil)
-
-	err = assetTransfer.CreateAsset(transactionContext, "1234567890", "DEALER001", "1234", 1000.0, "ACTIVE", "Test asset")
+	err = assetTransfer.CreateAsset(withMPIN(transactionContext, "1234"), "1234567890", "DEALER001", "0.00", "USD", "ACTIVE", "Test asset")
 	assert.EqualError(t, err, "the asset 1234567890 already exists")
+
+	// Assets can only be opened by an existing, active dealer
+	err = assetTransfer.CreateAsset(withMPIN(transactionContext, "1234"), "1234567891", "DEALER002", "0.00", "USD", "ACTIVE", "Test asset")
+	assert.EqualError(t, err, "INVALID_STATUS: dealer DEALER002 is not active")
+	err = assetTransfer.CreateAsset(withMPIN(transactionContext, "1234"), "1234567891", "DEALER404", "0.00", "USD", "ACTIVE", "Test asset")
+	assert.EqualError(t, err, "the dealer DEALER404 does not exist")
 }
 
 func TestReadAsset(t *testing.T) {
 	chaincodeStub := &mocks.ChaincodeStub{}
 	transactionContext := &mocks.TransactionContext{}
 	transactionContext.GetStubReturns(chaincodeStub)
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleAdmin, ""))
 
 	expectedAsset := &Asset{
 		DealerID:  "DEALER001",
 		MSISDN:    "1234567890",
-		MPIN:      "1234",
-		Balance:   1000.0,
+		Balance:   usd(100000),
 		Status:    "ACTIVE",
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
@@ -97,46 +127,124 @@ func TestReadAsset(t *testing.T) {
 }
 
 func TestUpdateAssetBalance(t *testing.T) {
-	chaincodeStub := &mocks.ChaincodeStub{}
-	transactionContext := &mocks.TransactionContext{}
-	transactionContext.GetStubReturns(chaincodeStub)
+	transactionContext, _, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(100000), Status: "ACTIVE"}, "1234")
+
+	assetTransfer := SmartContract{}
+
+	// Credits would create value with nothing debited in return
+	_, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "500.00", "CREDIT", "Credit test")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: CREDIT requires a debited counterparty; use TransferBalance or DistributeFloat")
+
+	// Test debit transaction
+	transaction, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "200.00", "DEBIT", "Debit test")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(80000), transaction.NewBalance)
+
+	// Test insufficient balance
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "2000.00", "DEBIT", "Insufficient balance test")
+	assert.EqualError(t, err, "insufficient balance. Current balance: 800.00, Requested: 2000.00")
 
-	asset := &Asset{
-		DealerID: "DEALER001",
-		MSISDN:   "1234567890",
-		MPIN:     "1234",
-		Balance:  1000.0,
-		Status:   "ACTIVE",
+	// Test invalid MPIN is committed as a failed attempt instead of an error
+	transaction, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "wrong"), "1234567890", "100.00", "DEBIT", "Wrong MPIN test")
+	assert.Nil(t, err)
+	assert.Equal(t, "MPIN_FAILED", transaction.TransType)
+	assert.Equal(t, "invalid MPIN for asset 1234567890", transaction.Remarks)
+	assert.Equal(t, usd(80000), transaction.NewBalance)
+
+	// The MPIN is only accepted from the transient map
+	transactionContext.GetStub().(*mocks.ChaincodeStub).GetTransientReturns(nil, nil)
+	_, err = assetTransfer.UpdateAssetBalance(transactionContext, "1234567890", "100.00", "DEBIT", "No MPIN test")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: the MPIN must be passed in the transient map under "mpin"`)
+}
+
+func TestGetAllAssets(t *testing.T) {
+	transactionContext, _, state := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(100000), Status: "ACTIVE"}, "1234")
+	// An asset written before docType existed and a transaction record under a legacy key
+	state["1234567891"] = []byte(`{"balance":{"amount":20000,"currency":"USD"},"dealerId":"DEALER002","msisdn":"1234567891","status":"ACTIVE"}`)
+	state["TXN_1234567890-CREATE-1"] = []byte(`{"id":"1234567890-CREATE-1","assetId":"1234567890","transType":"CREATE"}`)
+
+	assetTransfer := SmartContract{}
+	_, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "10.00", "DEBIT", "Debit test")
+	assert.Nil(t, err)
+
+	assets, err := assetTransfer.GetAllAssets(transactionContext, false)
+	assert.Nil(t, err)
+	assert.Len(t, assets, 2)
+	for _, asset := range assets {
+		assert.Equal(t, assetDocType, asset.DocType)
+		assert.NotEmpty(t, asset.MSISDN)
 	}
 
-	bytes, _ := json.Marshal(asset)
-	chaincodeStub.GetStateReturns(bytes, nil)
-	chaincodeStub.PutStateReturns(nil)
-	chaincodeStub.GetTxIDReturns("txid123")
+	// Other entities cannot be read as assets either
+	_, err = assetTransfer.ReadAsset(transactionContext, "TXN_1234567890-CREATE-1")
+	assert.EqualError(t, err, "the asset TXN_1234567890-CREATE-1 does not exist")
+}
+
+func TestDeleteAsset(t *testing.T) {
+	transactionContext, _, state := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(12345), Status: "ACTIVE"}, "1234")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567891", Balance: usd(100), Status: "ACTIVE"}, "5678")
 
 	assetTransfer := SmartContract{}
 
-	// Test credit transaction
-	err := assetTransfer.UpdateAssetBalance(transactionContext, "1234567890", "1234", 500.0, "CREDIT", "Credit test")
+	// A remaining balance must be settled to another account
+	err := assetTransfer.DeleteAsset(transactionContext, "1234567890", "", "Customer request")
+	assert.EqualError(t, err, "INVALID_STATUS: account 1234567890 has a balance of 123.45; settle it to another account to close it")
+	err = assetTransfer.DeleteAsset(transactionContext, "1234567890", "1234567890", "Customer request")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: cannot settle asset 1234567890 to itself")
+
+	assert.Nil(t, assetTransfer.DeleteAsset(transactionContext, "1234567890", "1234567891", "Customer request"))
+	closed, err := assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, "CLOSED", closed.Status)
+	assert.Equal(t, usd(0), closed.Balance)
+	settlement, err := assetTransfer.ReadAsset(transactionContext, "1234567891")
 	assert.Nil(t, err)
+	assert.Equal(t, usd(12445), settlement.Balance)
+	assert.Equal(t, "SETTLEMENT_IN", settlement.TransType)
 
-	// Test debit transaction
-	err = assetTransfer.UpdateAssetBalance(transactionContext, "1234567890", "1234", 200.0, "DEBIT", "Debit test")
+	// The history survives and the MSISDN cannot be reused
+	transactions, err := assetTransfer.GetTransactionHistory(transactionContext, "1234567890")
 	assert.Nil(t, err)
+	var types []string
+	for _, transaction := range transactions {
+		types = append(types, transaction.TransType)
+	}
+	assert.ElementsMatch(t, []string{"SETTLEMENT_OUT", "STATUS_CHANGE"}, types)
+	err = assetTransfer.CreateAsset(withMPIN(transactionContext, "1234"), "1234567890", "DEALER001", "0.00", "USD", "ACTIVE", "Recreate")
+	assert.EqualError(t, err, "the asset 1234567890 already exists")
+	err = assetTransfer.DeleteAsset(transactionContext, "1234567890", "", "Again")
+	assert.EqualError(t, err, "INVALID_TRANSITION: account 1234567890 is already closed")
 
-	// Test insufficient balance
-	err = assetTransfer.UpdateAssetBalance(transactionContext, "1234567890", "1234", 2000.0, "DEBIT", "Insufficient balance test")
-	assert.EqualError(t, err, "insufficient balance. Current balance: 1000.00, Requested: 2000.00")
+	// Closed assets are refused and hidden from listings by default
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "1.00", "DEBIT", "Closed debit")
+	assert.EqualError(t, err, "INVALID_STATUS: account 1234567890 is not active")
+	err = assetTransfer.UpdateAssetStatus(transactionContext, "1234567890", "ACTIVE", "Reopen")
+	assert.EqualError(t, err, "INVALID_TRANSITION: account 1234567890 is closed; use RestoreAsset to reopen it")
+	assets, err := assetTransfer.GetAllAssets(transactionContext, false)
+	assert.Nil(t, err)
+	assert.Len(t, assets, 1)
+	assets, err = assetTransfer.GetAllAssets(transactionContext, true)
+	assert.Nil(t, err)
+	assert.Len(t, assets, 2)
 
-	// Test invalid MPIN
-	err = assetTransfer.UpdateAssetBalance(transactionContext, "1234567890", "wrong", 100.0, "CREDIT", "Wrong MPIN test")
-	assert.EqualError(t, err, "invalid MPIN for asset 1234567890")
+	// A zero balance asset closes without settlement and can be restored
+	assert.Nil(t, assetTransfer.RestoreAsset(transactionContext, "1234567890", "Customer returned"))
+	restored, err := assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, "ACTIVE", restored.Status)
+	assert.EqualError(t, assetTransfer.RestoreAsset(transactionContext, "1234567890", "Again"), "INVALID_TRANSITION: account 1234567890 is not closed")
+	assert.Nil(t, assetTransfer.DeleteAsset(transactionContext, "1234567890", "", "Closed again"))
+	assert.NotNil(t, state["1234567890"])
 }
 
 func TestAssetExists(t *testing.T) {
 	chaincodeStub := &mocks.ChaincodeStub{}
 	transactionContext := &mocks.TransactionContext{}
 	transactionContext.GetStubReturns(chaincodeStub)
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleAdmin, ""))
 
 	// Test asset exists
 	chaincodeStub.GetStateReturns([]byte("asset"), nil)
@@ -151,3 +259,416 @@ func TestAssetExists(t *testing.T) {
 	assert.Nil(t, err)
 	assert.False(t, exists)
 }
+
+// newWorldState wires a ChaincodeStub fake to an in-memory key/value store so
+// that functions touching several keys in one transaction can be exercised.
+func newWorldState() (*mocks.TransactionContext, *mocks.ChaincodeStub, map[string][]byte) {
+	state := map[string][]byte{}
+	chaincodeStub := &mocks.ChaincodeStub{}
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		return state[key], nil
+	}
+	chaincodeStub.PutStateStub = func(key string, value []byte) error {
+		state[key] = value
+		return nil
+	}
+	chaincodeStub.DelStateStub = func(key string) error {
+		delete(state, key)
+		return nil
+	}
+	// Private data lives apart from world state, as on the peer
+	privateState := map[string][]byte{}
+	chaincodeStub.GetPrivateDataStub = func(collection, key string) ([]byte, error) {
+		return privateState[collection+"/"+key], nil
+	}
+	chaincodeStub.PutPrivateDataStub = func(collection, key string, value []byte) error {
+		privateState[collection+"/"+key] = value
+		return nil
+	}
+	chaincodeStub.DelPrivateDataStub = func(collection, key string) error {
+		delete(privateState, collection+"/"+key)
+		return nil
+	}
+	chaincodeStub.GetPrivateDataByRangeStub = func(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+		collectionState := map[string][]byte{}
+		var keys []string
+		for key, value := range privateState {
+			if strings.HasPrefix(key, collection+"/") {
+				key = strings.TrimPrefix(key, collection+"/")
+				if key < startKey || (endKey != "" && key >= endKey) {
+					continue
+				}
+				collectionState[key] = value
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		return newStateIterator(collectionState, keys), nil
+	}
+	chaincodeStub.CreateCompositeKeyStub = shim.CreateCompositeKey
+	chaincodeStub.SplitCompositeKeyStub = (&shim.ChaincodeStub{}).SplitCompositeKey
+	rangeKeys := func(startKey, endKey string) []string {
+		// Like the peer, open-ended range queries never return composite keys
+		var keys []string
+		for key := range state {
+			if strings.HasPrefix(key, "\x00") || key < startKey || (endKey != "" && key >= endKey) {
+				continue
+			}
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	prefixKeys := func(objectType string, attributes []string) ([]string, error) {
+		prefix, err := shim.CreateCompositeKey(objectType, attributes)
+		if err != nil {
+			return nil, err
+		}
+		var keys []string
+		for key := range state {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		return keys, nil
+	}
+	chaincodeStub.GetStateByRangeStub = func(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+		return newStateIterator(state, rangeKeys(startKey, endKey)), nil
+	}
+	chaincodeStub.GetStateByRangeWithPaginationStub = func(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+		iterator, metadata := newStatePage(state, rangeKeys(startKey, endKey), pageSize, bookmark)
+		return iterator, metadata, nil
+	}
+	chaincodeStub.GetStateByPartialCompositeKeyStub = func(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+		keys, err := prefixKeys(objectType, attributes)
+		if err != nil {
+			return nil, err
+		}
+		return newStateIterator(state, keys), nil
+	}
+	chaincodeStub.GetStateByPartialCompositeKeyWithPaginationStub = func(objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+		keys, err := prefixKeys(objectType, attributes)
+		if err != nil {
+			return nil, nil, err
+		}
+		iterator, metadata := newStatePage(state, keys, pageSize, bookmark)
+		return iterator, metadata, nil
+	}
+	chaincodeStub.GetTxIDReturns("txid123")
+	chaincodeStub.GetTxTimestampReturns(timestamppb.New(testTxTimestamp), nil)
+
+	transactionContext := &mocks.TransactionContext{}
+	transactionContext.GetStubReturns(chaincodeStub)
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleAdmin, ""))
+	return transactionContext, chaincodeStub, state
+}
+
+// newClientIdentity returns a ClientIdentity fake for testClientID carrying the
+// given role and dealerId attributes. Empty attributes are left out.
+func newClientIdentity(mspID, role, dealerID string) *mocks.ClientIdentity {
+	attributes := map[string]string{roleAttribute: role, dealerIDAttribute: dealerID}
+	clientIdentity := &mocks.ClientIdentity{}
+	clientIdentity.GetMSPIDReturns(mspID, nil)
+	clientIdentity.GetIDReturns(base64.StdEncoding.EncodeToString([]byte(testClientID)), nil)
+	clientIdentity.GetAttributeValueCalls(func(name string) (string, bool, error) {
+		value := attributes[name]
+		return value, value != "", nil
+	})
+	return clientIdentity
+}
+
+// newStateIterator returns a StateQueryIterator fake that walks keys in order
+func newStateIterator(state map[string][]byte, keys []string) *mocks.StateQueryIterator {
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextCalls(func() bool {
+		return iterator.NextCallCount() < len(keys)
+	})
+	iterator.NextCalls(func() (*queryresult.KV, error) {
+		key := keys[iterator.NextCallCount()-1]
+		return &queryresult.KV{Key: key, Value: state[key]}, nil
+	})
+	return iterator
+}
+
+// newStatePage returns the pageSize keys starting at bookmark, which is the
+// first key of the page, together with the bookmark of the next page
+func newStatePage(state map[string][]byte, keys []string, pageSize int32, bookmark string) (*mocks.StateQueryIterator, *peer.QueryResponseMetadata) {
+	start := sort.SearchStrings(keys, bookmark)
+	end := start + int(pageSize)
+	next := ""
+	if end < len(keys) {
+		next = keys[end]
+	} else {
+		end = len(keys)
+	}
+
+	page := keys[start:end]
+	return newStateIterator(state, page), &peer.QueryResponseMetadata{FetchedRecordsCount: int32(len(page)), Bookmark: next}
+}
+
+// usd returns an amount of US dollars given in cents
+func usd(cents int64) Money {
+	return Money{Amount: cents, Currency: "USD"}
+}
+
+// withMPIN passes mpin in the transient map of the next call, as the gateway does
+func withMPIN(transactionContext *mocks.TransactionContext, mpin string) *mocks.TransactionContext {
+	transactionContext.GetStub().(*mocks.ChaincodeStub).GetTransientReturns(map[string][]byte{"mpin": []byte(mpin)}, nil)
+	return transactionContext
+}
+
+// putTestAsset stores asset together with the hash of its MPIN
+func putTestAsset(t *testing.T, transactionContext *mocks.TransactionContext, asset Asset, mpin string) {
+	bytes, err := json.Marshal(asset)
+	assert.Nil(t, err)
+	assert.Nil(t, transactionContext.GetStub().PutState(asset.MSISDN, bytes))
+	assert.Nil(t, (&SmartContract{}).setMPIN(transactionContext, asset.MSISDN, mpin))
+}
+
+// putTestDealer writes a dealer record straight to the world state
+func putTestDealer(t *testing.T, transactionContext *mocks.TransactionContext, dealer Dealer) {
+	if dealer.CreditLimit.Currency == "" {
+		dealer.CreditLimit = usd(0)
+	}
+	if dealer.FloatBalance.Currency == "" {
+		dealer.FloatBalance = usd(0)
+	}
+	assert.Nil(t, (&SmartContract{}).putDealer(transactionContext, &dealer))
+}
+
+func TestTransferBalance(t *testing.T) {
+	transactionContext, _, state := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(100000), Status: "ACTIVE"}, "1234")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER002", MSISDN: "1234567891", Balance: usd(20000), Status: "ACTIVE"}, "5678")
+
+	assetTransfer := SmartContract{}
+
+	// Test successful transfer
+	transaction, err := assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567891", "300.00", "Transfer test")
+	assert.Nil(t, err)
+	assert.Equal(t, "TRANSFER_OUT", transaction.TransType)
+
+	from, err := assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(70000), from.Balance)
+	assert.Equal(t, "TRANSFER_OUT", from.TransType)
+	to, err := assetTransfer.ReadAsset(transactionContext, "1234567891")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(50000), to.Balance)
+	assert.Equal(t, "TRANSFER_IN", to.TransType)
+
+	// Both legs are recorded and point at each other
+	var debit, credit *Transaction
+	for key, value := range state {
+		if !strings.HasPrefix(key, "\x00txn\x00") {
+			continue
+		}
+		var transaction Transaction
+		assert.Nil(t, json.Unmarshal(value, &transaction))
+		switch transaction.TransType {
+		case "TRANSFER_OUT":
+			debit = &transaction
+		case "TRANSFER_IN":
+			credit = &transaction
+		}
+	}
+	assert.NotNil(t, debit)
+	assert.NotNil(t, credit)
+	assert.Equal(t, credit.ID, debit.LinkedTxnID)
+	assert.Equal(t, debit.ID, credit.LinkedTxnID)
+	assert.Equal(t, "1234567891", debit.CounterpartyID)
+
+	// Test insufficient balance leaves both assets untouched
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567891", "5000.00", "Insufficient balance test")
+	assert.EqualError(t, err, "insufficient balance. Current balance: 700.00, Requested: 5000.00")
+
+	// Test invalid MPIN
+	transaction, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "wrong"), "1234567890", "1234567891", "100.00", "Wrong MPIN test")
+	assert.Nil(t, err)
+	assert.Equal(t, "MPIN_FAILED", transaction.TransType)
+
+	// Test transfer to self
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567890", "100.00", "Self transfer test")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: cannot transfer from asset 1234567890 to itself")
+
+	// Test inactive receiver
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER003", MSISDN: "1234567892", Balance: usd(0), Status: "SUSPENDED"}, "9012")
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567892", "100.00", "Inactive receiver test")
+	assert.EqualError(t, err, "INVALID_STATUS: account 1234567892 is not active")
+}
+
+func TestMPINIsStoredHashed(t *testing.T) {
+	transactionContext, _, state := newWorldState()
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER001", MSPID: "Org1MSP", Status: "ACTIVE"})
+	assetTransfer := SmartContract{}
+
+	err := assetTransfer.CreateAsset(withMPIN(transactionContext, "1234"), "1234567890", "DEALER001", "0.00", "USD", "ACTIVE", "Test asset")
+	assert.Nil(t, err)
+
+	// Neither the asset nor any other record carries the plaintext PIN, and
+	// the hash is kept out of world state
+	assert.NotContains(t, string(state["1234567890"]), `"mpin"`)
+	for _, value := range state {
+		assert.NotContains(t, string(value), `"1234"`)
+		assert.NotContains(t, string(value), mpinAlgorithm)
+	}
+
+	matches, err := assetTransfer.mpinMatches(transactionContext, "1234567890", "1234")
+	assert.Nil(t, err)
+	assert.True(t, matches)
+	matches, err = assetTransfer.mpinMatches(transactionContext, "1234567890", "4321")
+	assert.Nil(t, err)
+	assert.False(t, matches)
+}
+
+func TestMigrateMPINs(t *testing.T) {
+	transactionContext, _, state := newWorldState()
+	state["1234567890"] = []byte(`{"balance":{"amount":100000,"currency":"USD"},"dealerId":"DEALER001","mpin":"1234","msisdn":"1234567890","status":"ACTIVE"}`)
+	state["TXN_1234567890-CREATE-1"] = []byte(`{"id":"1234567890-CREATE-1","assetId":"1234567890","transType":"CREATE"}`)
+
+	assetTransfer := SmartContract{}
+	migrated, err := assetTransfer.MigrateMPINs(transactionContext)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, migrated)
+	assert.NotContains(t, string(state["1234567890"]), `"mpin"`)
+	matches, err := assetTransfer.mpinMatches(transactionContext, "1234567890", "1234")
+	assert.Nil(t, err)
+	assert.True(t, matches)
+
+	// Running it again finds nothing left to migrate
+	migrated, err = assetTransfer.MigrateMPINs(transactionContext)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, migrated)
+}
+
+func TestMPINLockout(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(100000), Status: "ACTIVE"}, "1234")
+
+	assetTransfer := SmartContract{}
+	assert.Nil(t, assetTransfer.SetMPINMaxAttempts(transactionContext, 2))
+
+	// A correct MPIN resets the consecutive failure counter
+	_, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "0000"), "1234567890", "100.00", "DEBIT", "Wrong MPIN test")
+	assert.Nil(t, err)
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "100.00", "DEBIT", "Debit test")
+	assert.Nil(t, err)
+	asset, err := assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, asset.FailedMPINAttempts)
+
+	// Reaching the limit locks the asset
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "0000"), "1234567890", "100.00", "DEBIT", "Wrong MPIN test")
+	assert.Nil(t, err)
+	transaction, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "0000"), "1234567890", "100.00", "DEBIT", "Wrong MPIN test")
+	assert.Nil(t, err)
+	assert.Equal(t, "invalid MPIN for asset 1234567890, account locked", transaction.Remarks)
+	asset, err = assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, "LOCKED", asset.Status)
+	assert.Equal(t, 2, asset.FailedMPINAttempts)
+
+	// Even the correct MPIN is refused while locked
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "100.00", "DEBIT", "Locked test")
+	assert.EqualError(t, err, "INVALID_STATUS: account 1234567890 is locked after too many invalid MPIN attempts")
+
+	// Unlocking resets the counter and reactivates the asset
+	assert.Nil(t, assetTransfer.UnlockAsset(transactionContext, "1234567890", "Identity verified"))
+	asset, err = assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, "ACTIVE", asset.Status)
+	assert.Equal(t, 0, asset.FailedMPINAttempts)
+	assert.EqualError(t, assetTransfer.UnlockAsset(transactionContext, "1234567890", "Again"), "INVALID_TRANSITION: account 1234567890 is not locked")
+}
+
+func TestEndorsementsAreDeterministic(t *testing.T) {
+	// endorse simulates one peer executing the same proposal against its own
+	// copy of the world state and returns the write set it produced.
+	endorse := func() []string {
+		transactionContext, chaincodeStub, _ := newWorldState()
+		putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(100000), Status: "ACTIVE"}, "1234")
+		putTestAsset(t, transactionContext, Asset{DealerID: "DEALER002", MSISDN: "1234567891", Balance: usd(20000), Status: "ACTIVE"}, "5678")
+		seeded := chaincodeStub.PutStateCallCount()
+
+		assetTransfer := SmartContract{}
+		assert.Nil(t, assetTransfer.InitLedger(transactionContext))
+		assert.Nil(t, assetTransfer.CreateAsset(withMPIN(transactionContext, "4321"), "1234567899", "DEALER001", "0.00", "USD", "ACTIVE", "New asset"))
+		_, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "100.00", "DEBIT", "Debit")
+		assert.Nil(t, err)
+		_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567891", "100.00", "Transfer")
+		assert.Nil(t, err)
+		assert.Nil(t, assetTransfer.UpdateAssetStatus(transactionContext, "1234567891", "SUSPENDED", "Review"))
+
+		var writes []string
+		for i := seeded; i < chaincodeStub.PutStateCallCount(); i++ {
+			key, value := chaincodeStub.PutStateArgsForCall(i)
+			writes = append(writes, key+"="+string(value))
+		}
+		return writes
+	}
+
+	// Any use of the local clock would show up as differing nanoseconds
+	first := endorse()
+	second := endorse()
+
+	assert.NotEmpty(t, first)
+	assert.Equal(t, first, second)
+	assert.Contains(t, first[0], testTxTimestamp.Format(time.RFC3339))
+}
+
+func TestTransactionHistory(t *testing.T) {
+	transactionContext, chaincodeStub, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "123456789", Balance: usd(100000), Status: "ACTIVE"}, "1234")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER002", MSISDN: "1234567890", Balance: usd(100000), Status: "ACTIVE"}, "5678")
+
+	assetTransfer := SmartContract{}
+
+	// Two debits in the same second are kept apart by their transaction IDs
+	chaincodeStub.GetTxIDReturns("txid1")
+	_, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "123456789", "10.00", "DEBIT", "First debit")
+	assert.Nil(t, err)
+	chaincodeStub.GetTxIDReturns("txid2")
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "123456789", "20.00", "DEBIT", "Second debit")
+	assert.Nil(t, err)
+
+	// A record for an MSISDN that merely shares the prefix is not included
+	chaincodeStub.GetTxTimestampReturns(timestamppb.New(testTxTimestamp.Add(-time.Hour)), nil)
+	chaincodeStub.GetTxIDReturns("txid3")
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "5678"), "1234567890", "30.00", "DEBIT", "Other asset")
+	assert.Nil(t, err)
+
+	transactions, err := assetTransfer.GetTransactionHistory(transactionContext, "123456789")
+	assert.Nil(t, err)
+	assert.Len(t, transactions, 2)
+	assert.Equal(t, "123456789-DEBIT-txid1", transactions[0].ID)
+	assert.Equal(t, "123456789-DEBIT-txid2", transactions[1].ID)
+	assert.Equal(t, usd(97000), transactions[1].NewBalance)
+}
+
+func TestMigrateTransactions(t *testing.T) {
+	transactionContext, _, state := newWorldState()
+	state["TXN_1234567890-CREATE-1705314600"] = []byte(`{"id":"1234567890-CREATE-1705314600","assetId":"1234567890","transType":"CREATE","timestamp":"2024-01-15T10:30:00Z","txId":"txid1"}`)
+	state["TXN_1234567890-CREDIT-1705314660"] = []byte(`{"id":"1234567890-CREDIT-1705314660","assetId":"1234567890","transType":"CREDIT","timestamp":"2024-01-15T10:31:00Z","txId":"txid2"}`)
+	state["1234567890"] = []byte(`{"msisdn":"1234567890","status":"ACTIVE"}`)
+
+	assetTransfer := SmartContract{}
+	migrated, err := assetTransfer.MigrateTransactions(transactionContext)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, migrated)
+	for key := range state {
+		assert.False(t, strings.HasPrefix(key, "TXN_"), key)
+	}
+	assert.Contains(t, state, "1234567890")
+
+	transactions, err := assetTransfer.GetTransactionHistory(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Len(t, transactions, 2)
+	assert.Equal(t, "CREATE", transactions[0].TransType)
+	assert.Equal(t, "CREDIT", transactions[1].TransType)
+
+	// Running it again finds nothing left to migrate
+	migrated, err = assetTransfer.MigrateTransactions(transactionContext)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, migrated)
+}
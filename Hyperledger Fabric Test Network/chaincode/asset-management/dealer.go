@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Dealer is a distributor that opens and serves assets. FloatBalance is the
+// dealer's own wallet, from which it funds its subscribers, and CreditLimit is
+// how far it may go below zero. Both are in the dealer's currency.
+type Dealer struct {
+	CreatedAt    time.Time `json:"createdAt"`
+	CreditLimit  Money     `json:"creditLimit"`
+	DocType      string    `json:"docType"`
+	FloatBalance Money     `json:"floatBalance"`
+	ID           string    `json:"id"`
+	MSPID        string    `json:"mspId"`
+	Name         string    `json:"name"`
+	Status       string    `json:"status"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+const (
+	dealerDocType = "dealer"
+	// dealerObjectType keys dealer records, which keeps them out of the open
+	// range scans over assets
+	dealerObjectType = "dealer"
+	// dealerAssetIndex maps a dealer ID to the MSISDNs of its assets
+	dealerAssetIndex = "dealer~msisdn"
+)
+
+// Dealer statuses. Only ACTIVE dealers can open assets.
+const (
+	dealerStatusActive    = "ACTIVE"
+	dealerStatusSuspended = "SUSPENDED"
+	dealerStatusClosed    = "CLOSED"
+)
+
+// CreateDealer registers a dealer owned by the organisation mspId. The credit
+// limit is a decimal string in currency, which also becomes the currency of
+// the dealer's float.
+func (s *SmartContract) CreateDealer(ctx contractapi.TransactionContextInterface, id, name, mspId, creditLimit, currency string) error {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return err
+	}
+	if err := validateDealerID(id); err != nil {
+		return err
+	}
+	if name == "" || mspId == "" {
+		return newContractError(errInvalidArgument, "dealer name and MSP ID are required")
+	}
+
+	existing, err := s.getDealer(ctx, id)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("the dealer %s already exists", id)
+	}
+
+	limit, err := ParseMoney(creditLimit, currency)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.putDealer(ctx, &Dealer{
+		CreatedAt:    now,
+		CreditLimit:  limit,
+		FloatBalance: Money{Currency: currency},
+		ID:           id,
+		MSPID:        mspId,
+		Name:         name,
+		Status:       dealerStatusActive,
+		UpdatedAt:    now,
+	})
+}
+
+// ReadDealer returns the dealer with the given ID. Dealers can only read their
+// own record.
+func (s *SmartContract) ReadDealer(ctx contractapi.TransactionContextInterface, id string) (*Dealer, error) {
+	c, err := authorize(ctx, roleAdmin, roleDealer, roleAgent)
+	if err != nil {
+		return nil, err
+	}
+	if !c.canAccessDealer(id) {
+		return nil, newContractError(errForbidden, "dealer %s cannot read dealer %s", c.DealerID, id)
+	}
+
+	return s.readDealer(ctx, id)
+}
+
+// UpdateDealer replaces the name, owning MSP, status and credit limit of a
+// dealer. The credit limit is a decimal string in the dealer's currency; the
+// float balance only changes through float operations.
+func (s *SmartContract) UpdateDealer(ctx contractapi.TransactionContextInterface, id, name, mspId, status, creditLimit string) error {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return err
+	}
+
+	dealer, err := s.readDealer(ctx, id)
+	if err != nil {
+		return err
+	}
+	if name == "" || mspId == "" {
+		return newContractError(errInvalidArgument, "dealer name and MSP ID are required")
+	}
+	if status != dealerStatusActive && status != dealerStatusSuspended && status != dealerStatusClosed {
+		return newContractError(errInvalidStatus, "unknown dealer status %q", status)
+	}
+
+	limit, err := ParseMoney(creditLimit, dealer.CreditLimit.Currency)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	dealer.Name = name
+	dealer.MSPID = mspId
+	dealer.Status = status
+	dealer.CreditLimit = limit
+	dealer.UpdatedAt = now
+	return s.putDealer(ctx, dealer)
+}
+
+// ListDealerAssets returns the assets opened by a dealer, found through the
+// dealer~msisdn index. CLOSED assets are left out unless includeClosed is set.
+func (s *SmartContract) ListDealerAssets(ctx contractapi.TransactionContextInterface, dealerId string, includeClosed bool) ([]*Asset, error) {
+	c, err := authorize(ctx, roleAdmin, roleDealer, roleAgent)
+	if err != nil {
+		return nil, err
+	}
+	if !c.canAccessDealer(dealerId) {
+		return nil, newContractError(errForbidden, "dealer %s cannot list assets of dealer %s", c.DealerID, dealerId)
+	}
+	if _, err := s.readDealer(ctx, dealerId); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(dealerAssetIndex, []string{dealerId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	assets := []*Asset{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		asset, err := s.readAsset(ctx, attributes[1])
+		if err != nil {
+			return nil, err
+		}
+		if asset.Status == statusClosed && !includeClosed {
+			continue
+		}
+		assets = append(assets, asset)
+	}
+
+	return assets, nil
+}
+
+// MigrateDealerIndex adds every asset to the dealer~msisdn index. Assets are
+// indexed whenever they are written, so it is only needed for assets last
+// written before the index was introduced. It returns the number of assets
+// indexed and is safe to run more than once.
+func (s *SmartContract) MigrateDealerIndex(ctx contractapi.TransactionContextInterface) (int, error) {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return 0, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	indexed := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		asset, ok, err := assetFromRecord(queryResponse.Key, queryResponse.Value)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+		if err := indexDealerAsset(ctx, asset); err != nil {
+			return 0, err
+		}
+		indexed++
+	}
+
+	return indexed, nil
+}
+
+// requireActiveDealer returns the dealer with the given ID if it is ACTIVE
+func (s *SmartContract) requireActiveDealer(ctx contractapi.TransactionContextInterface, id string) (*Dealer, error) {
+	dealer, err := s.readDealer(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if dealer.Status != dealerStatusActive {
+		return nil, newContractError(errInvalidStatus, "dealer %s is not active", id)
+	}
+	return dealer, nil
+}
+
+// readDealer loads a dealer, failing if it does not exist
+func (s *SmartContract) readDealer(ctx contractapi.TransactionContextInterface, id string) (*Dealer, error) {
+	dealer, err := s.getDealer(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if dealer == nil {
+		return nil, fmt.Errorf("the dealer %s does not exist", id)
+	}
+	return dealer, nil
+}
+
+// getDealer loads a dealer, returning nil if it does not exist
+func (s *SmartContract) getDealer(ctx contractapi.TransactionContextInterface, id string) (*Dealer, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(dealerObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	dealerJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if dealerJSON == nil {
+		return nil, nil
+	}
+
+	var dealer Dealer
+	if err := json.Unmarshal(dealerJSON, &dealer); err != nil {
+		return nil, fmt.Errorf("failed to read dealer %s: %v", id, err)
+	}
+	return &dealer, nil
+}
+
+// putDealer writes a dealer to the world state
+func (s *SmartContract) putDealer(ctx contractapi.TransactionContextInterface, dealer *Dealer) error {
+	dealer.DocType = dealerDocType
+	dealerJSON, err := json.Marshal(dealer)
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(dealerObjectType, []string{dealer.ID})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, dealerJSON)
+}
+
+// indexDealerAsset records asset under its dealer in the dealer~msisdn index.
+// The entry is the key alone; its value is a single null byte.
+func indexDealerAsset(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	if asset.DealerID == "" {
+		return nil
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(dealerAssetIndex, []string{asset.DealerID, asset.MSISDN})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, []byte{0x00})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDealerLifecycle(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	assetTransfer := SmartContract{}
+
+	err := assetTransfer.CreateDealer(transactionContext, "DEALER001", "Dealer One", "Org2MSP", "500.00", "USD")
+	assert.Nil(t, err)
+	err = assetTransfer.CreateDealer(transactionContext, "DEALER001", "Dealer One", "Org2MSP", "500.00", "USD")
+	assert.EqualError(t, err, "the dealer DEALER001 already exists")
+	err = assetTransfer.CreateDealer(transactionContext, "DEALER002", "Dealer Two", "Org2MSP", "-1.00", "USD")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: invalid amount "-1.00"`)
+
+	dealer, err := assetTransfer.ReadDealer(transactionContext, "DEALER001")
+	assert.Nil(t, err)
+	assert.Equal(t, "dealer", dealer.DocType)
+	assert.Equal(t, "Org2MSP", dealer.MSPID)
+	assert.Equal(t, "ACTIVE", dealer.Status)
+	assert.Equal(t, usd(50000), dealer.CreditLimit)
+	assert.Equal(t, usd(0), dealer.FloatBalance)
+	assert.Equal(t, testTxTimestamp, dealer.CreatedAt)
+
+	err = assetTransfer.UpdateDealer(transactionContext, "DEALER001", "Dealer One Ltd", "Org2MSP", "DORMANT", "750.00")
+	assert.EqualError(t, err, `INVALID_STATUS: unknown dealer status "DORMANT"`)
+	err = assetTransfer.UpdateDealer(transactionContext, "DEALER001", "Dealer One Ltd", "Org2MSP", "SUSPENDED", "750.00")
+	assert.Nil(t, err)
+	dealer, err = assetTransfer.ReadDealer(transactionContext, "DEALER001")
+	assert.Nil(t, err)
+	assert.Equal(t, "Dealer One Ltd", dealer.Name)
+	assert.Equal(t, "SUSPENDED", dealer.Status)
+	assert.Equal(t, usd(75000), dealer.CreditLimit)
+
+	_, err = assetTransfer.ReadDealer(transactionContext, "DEALER404")
+	assert.EqualError(t, err, "the dealer DEALER404 does not exist")
+
+	// Dealers read their own record and cannot manage dealers
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org2MSP", roleDealer, "DEALER002"))
+	_, err = assetTransfer.ReadDealer(transactionContext, "DEALER001")
+	assertForbidden(t, err)
+	err = assetTransfer.CreateDealer(transactionContext, "DEALER002", "Dealer Two", "Org2MSP", "0.00", "USD")
+	assertForbidden(t, err)
+}
+
+func TestListDealerAssets(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER001", MSPID: "Org2MSP", Status: "ACTIVE"})
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER002", MSPID: "Org2MSP", Status: "ACTIVE"})
+	// Written without the index, as before it existed
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(0), Status: "CLOSED"}, "1234")
+	assetTransfer := SmartContract{}
+
+	assert.Nil(t, assetTransfer.CreateAsset(withMPIN(transactionContext, "5678"), "1234567891", "DEALER001", "0.00", "USD", "ACTIVE", "New asset"))
+	assert.Nil(t, assetTransfer.CreateAsset(withMPIN(transactionContext, "9012"), "1234567892", "DEALER002", "0.00", "USD", "ACTIVE", "New asset"))
+
+	assets, err := assetTransfer.ListDealerAssets(transactionContext, "DEALER001", true)
+	assert.Nil(t, err)
+	assert.Len(t, assets, 1)
+	assert.Equal(t, "1234567891", assets[0].MSISDN)
+
+	indexed, err := assetTransfer.MigrateDealerIndex(transactionContext)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, indexed)
+
+	assets, err = assetTransfer.ListDealerAssets(transactionContext, "DEALER001", true)
+	assert.Nil(t, err)
+	assert.Len(t, assets, 2)
+	assets, err = assetTransfer.ListDealerAssets(transactionContext, "DEALER001", false)
+	assert.Nil(t, err)
+	assert.Len(t, assets, 1)
+
+	_, err = assetTransfer.ListDealerAssets(transactionContext, "DEALER404", false)
+	assert.EqualError(t, err, "the dealer DEALER404 does not exist")
+
+	// A dealer only lists its own assets, and only from its own organisation
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org2MSP", roleDealer, "DEALER002"))
+	assets, err = assetTransfer.ListDealerAssets(transactionContext, "DEALER002", false)
+	assert.Nil(t, err)
+	assert.Len(t, assets, 1)
+	_, err = assetTransfer.ListDealerAssets(transactionContext, "DEALER001", false)
+	assertForbidden(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleDealer, "DEALER002"))
+	err = assetTransfer.CreateAsset(withMPIN(transactionContext, "3456"), "1234567893", "DEALER002", "0.00", "USD", "ACTIVE", "New asset")
+	assert.EqualError(t, err, "FORBIDDEN: dealer DEALER002 belongs to Org2MSP, not Org1MSP")
+}
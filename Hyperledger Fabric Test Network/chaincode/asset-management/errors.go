@@ -0,0 +1,30 @@
+package main
+
+import "fmt"
+
+// Error codes returned to clients in a ContractError. The API gateway maps
+// them to HTTP status codes.
+const (
+	errInvalidArgument   = "INVALID_ARGUMENT"
+	errInvalidStatus     = "INVALID_STATUS"
+	errInvalidTransition = "INVALID_TRANSITION"
+	errForbidden         = "FORBIDDEN"
+	errLimitExceeded     = "LIMIT_EXCEEDED"
+)
+
+// ContractError is an error with a stable, machine-readable code. Its message
+// starts with the code, e.g. "INVALID_TRANSITION: ...", which is how clients
+// see it after Fabric has turned it into a plain string.
+type ContractError struct {
+	Code    string
+	Message string
+}
+
+func (e *ContractError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// newContractError returns a ContractError with a formatted message
+func newContractError(code, format string, args ...interface{}) *ContractError {
+	return &ContractError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Chaincode event names. Every event carries the Transaction recorded for the
+// change as its JSON payload.
+const (
+	eventAssetCreated   = "AssetCreated"
+	eventBalanceChanged = "BalanceChanged"
+	eventStatusChanged  = "StatusChanged"
+	eventAssetDeleted   = "AssetDeleted"
+	eventKYCChanged     = "KYCChanged"
+)
+
+// emitEvent sets the chaincode event for the current transaction. Fabric keeps
+// only one event per transaction, so each function emits once, after its
+// last state change.
+func emitEvent(ctx contractapi.TransactionContextInterface, name string, transaction Transaction) error {
+	transaction.DocType = transactionDocType
+	payload, err := json.Marshal(transaction)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().SetEvent(name, payload)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/asset-management/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// lastEvent returns the name and Transaction payload of the most recent SetEvent call
+func lastEvent(t *testing.T, chaincodeStub *mocks.ChaincodeStub) (string, Transaction) {
+	assert.NotZero(t, chaincodeStub.SetEventCallCount())
+	name, payload := chaincodeStub.SetEventArgsForCall(chaincodeStub.SetEventCallCount() - 1)
+	var transaction Transaction
+	assert.Nil(t, json.Unmarshal(payload, &transaction))
+	return name, transaction
+}
+
+func TestEvents(t *testing.T) {
+	transactionContext, chaincodeStub, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER002", MSISDN: "1234567891", Balance: usd(0), Status: "ACTIVE"}, "5678")
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER001", MSPID: "Org1MSP", Status: "ACTIVE", FloatBalance: usd(10000)})
+
+	assetTransfer := SmartContract{}
+
+	assert.Nil(t, assetTransfer.CreateAsset(withMPIN(transactionContext, "1234"), "1234567890", "DEALER001", "0.00", "USD", "ACTIVE", "New asset"))
+	name, transaction := lastEvent(t, chaincodeStub)
+	assert.Equal(t, "AssetCreated", name)
+	assert.Equal(t, "CREATE", transaction.TransType)
+	assert.Equal(t, usd(0), transaction.NewBalance)
+
+	_, err := assetTransfer.DistributeFloat(transactionContext, "DEALER001", "1234567890", "100.00", "Float sale")
+	assert.Nil(t, err)
+	name, transaction = lastEvent(t, chaincodeStub)
+	assert.Equal(t, "BalanceChanged", name)
+	assert.Equal(t, "DISTRIBUTION_IN", transaction.TransType)
+	assert.Equal(t, usd(10000), transaction.NewBalance)
+
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "25.00", "DEBIT", "Debit")
+	assert.Nil(t, err)
+	name, transaction = lastEvent(t, chaincodeStub)
+	assert.Equal(t, "BalanceChanged", name)
+	assert.Equal(t, usd(7500), transaction.NewBalance)
+
+	// New subscribers must be verified before they can send transfers
+	assert.Nil(t, assetTransfer.UpgradeKYC(transactionContext, "1234567890", "TIER1", "9f86d081", "Basic KYC"))
+	name, transaction = lastEvent(t, chaincodeStub)
+	assert.Equal(t, "KYCChanged", name)
+	assert.Equal(t, "KYC_CHANGE", transaction.TransType)
+	assert.Equal(t, "TIER1", transaction.NewKYCTier)
+
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567891", "5.00", "Transfer")
+	assert.Nil(t, err)
+	name, transaction = lastEvent(t, chaincodeStub)
+	assert.Equal(t, "BalanceChanged", name)
+	assert.Equal(t, "TRANSFER_OUT", transaction.TransType)
+	assert.Equal(t, "1234567891", transaction.CounterpartyID)
+
+	assert.Nil(t, assetTransfer.UpdateAssetStatus(transactionContext, "1234567890", "SUSPENDED", "Review"))
+	name, transaction = lastEvent(t, chaincodeStub)
+	assert.Equal(t, "StatusChanged", name)
+	assert.Equal(t, "STATUS_CHANGE", transaction.TransType)
+	assert.Equal(t, "Review", transaction.Remarks)
+
+	assert.Nil(t, assetTransfer.DeleteAsset(transactionContext, "1234567890", "1234567891", "Closing"))
+	name, transaction = lastEvent(t, chaincodeStub)
+	assert.Equal(t, "AssetDeleted", name)
+	assert.Equal(t, "STATUS_CHANGE", transaction.TransType)
+	assert.Equal(t, "CLOSED", transaction.NewStatus)
+	assert.Equal(t, usd(0), transaction.NewBalance)
+
+	// A wrong MPIN only emits an event once it locks the asset
+	assert.Nil(t, assetTransfer.SetMPINMaxAttempts(transactionContext, 2))
+	events := chaincodeStub.SetEventCallCount()
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "0000"), "1234567891", "1.00", "DEBIT", "Wrong MPIN")
+	assert.Nil(t, err)
+	assert.Equal(t, events, chaincodeStub.SetEventCallCount())
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "0000"), "1234567891", "1.00", "DEBIT", "Wrong MPIN")
+	assert.Nil(t, err)
+	name, transaction = lastEvent(t, chaincodeStub)
+	assert.Equal(t, "StatusChanged", name)
+	assert.Equal(t, "LOCKED", transaction.NewStatus)
+}
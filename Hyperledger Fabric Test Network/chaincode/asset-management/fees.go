@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Fee types. A FLAT fee is the same for every amount, a PERCENTAGE fee is
+// RateBasisPoints hundredths of a percent of the amount, rounded half up to
+// the minor unit, and a TIERED fee is that of the first band whose UpTo covers
+// the amount.
+const (
+	feeTypeFlat       = "FLAT"
+	feeTypePercentage = "PERCENTAGE"
+	feeTypeTiered     = "TIERED"
+)
+
+const (
+	feeScheduleDocType = "feeSchedule"
+	// feeScheduleObjectType keys schedules by transaction type and currency
+	feeScheduleObjectType = "fee"
+)
+
+// FeeSchedule is the fee charged on one type of transaction in one currency.
+// The payer is charged on top of the amount moved and the fee is credited to
+// the CollectorID asset.
+type FeeSchedule struct {
+	Bands           []FeeBand `json:"bands,omitempty" metadata:",optional"`
+	CollectorID     string    `json:"collectorId"`
+	Currency        string    `json:"currency"`
+	DocType         string    `json:"docType"`
+	Flat            Money     `json:"flat"`
+	RateBasisPoints int64     `json:"rateBasisPoints"`
+	TransType       string    `json:"transType"`
+	Type            string    `json:"type"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// FeeBand is one tier of a TIERED schedule. A zero UpTo leaves the band open
+// ended; amounts above every band pay the fee of the last one.
+type FeeBand struct {
+	Fee  Money `json:"fee"`
+	UpTo Money `json:"upTo"`
+}
+
+// feeScheduleSpec is the JSON accepted by SetFeeSchedule. Amounts are decimal
+// numbers or strings in the schedule's currency.
+type feeScheduleSpec struct {
+	Type            string      `json:"type"`
+	CollectorID     string      `json:"collectorId"`
+	Flat            json.Number `json:"flat"`
+	RateBasisPoints int64       `json:"rateBasisPoints"`
+	Bands           []struct {
+		UpTo json.Number `json:"upTo"`
+		Fee  json.Number `json:"fee"`
+	} `json:"bands"`
+}
+
+// feeTransTypes are the transaction types fees are charged on
+var feeTransTypes = map[string]bool{"DEBIT": true, "TRANSFER": true}
+
+// SetFeeSchedule creates or replaces the fee charged on transType in currency.
+// scheduleJSON holds the fee type, the collecting asset and, depending on the
+// type, a flat amount, a rate in basis points or the tier bands.
+func (s *SmartContract) SetFeeSchedule(ctx contractapi.TransactionContextInterface, transType, currency, scheduleJSON string) error {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return err
+	}
+	if !feeTransTypes[transType] {
+		return newContractError(errInvalidArgument, "fees cannot be charged on %s transactions", transType)
+	}
+
+	var spec feeScheduleSpec
+	if err := json.Unmarshal([]byte(scheduleJSON), &spec); err != nil {
+		return fmt.Errorf("invalid fee schedule: %v", err)
+	}
+
+	schedule := FeeSchedule{
+		CollectorID: spec.CollectorID,
+		Currency:    currency,
+		Flat:        Money{Currency: currency},
+		TransType:   transType,
+		Type:        spec.Type,
+	}
+	var err error
+	switch spec.Type {
+	case feeTypeFlat:
+		if schedule.Flat, err = ParseMoney(spec.Flat.String(), currency); err != nil {
+			return err
+		}
+	case feeTypePercentage:
+		if spec.RateBasisPoints < 0 || spec.RateBasisPoints > 10000 {
+			return newContractError(errInvalidArgument, "fee rate must be between 0 and 10000 basis points")
+		}
+		schedule.RateBasisPoints = spec.RateBasisPoints
+	case feeTypeTiered:
+		if len(spec.Bands) == 0 {
+			return newContractError(errInvalidArgument, "a tiered fee schedule needs at least one band")
+		}
+		for i, band := range spec.Bands {
+			upTo, err := ParseMoney(band.UpTo.String(), currency)
+			if err != nil {
+				return err
+			}
+			fee, err := ParseMoney(band.Fee.String(), currency)
+			if err != nil {
+				return err
+			}
+			if i > 0 && (schedule.Bands[i-1].UpTo.Amount == 0 || (upTo.Amount != 0 && upTo.Amount <= schedule.Bands[i-1].UpTo.Amount)) {
+				return newContractError(errInvalidArgument, "fee bands must have increasing upper bounds, with only the last open ended")
+			}
+			schedule.Bands = append(schedule.Bands, FeeBand{Fee: fee, UpTo: upTo})
+		}
+	default:
+		return newContractError(errInvalidArgument, "unknown fee type %q", spec.Type)
+	}
+
+	collector, err := s.readAsset(ctx, spec.CollectorID)
+	if err != nil {
+		return err
+	}
+	if collector.Balance.Currency != currency {
+		return newContractError(errInvalidArgument, "fee collector %s holds %s, not %s", collector.MSISDN, collector.Balance.Currency, currency)
+	}
+
+	if schedule.UpdatedAt, err = txTimestamp(ctx); err != nil {
+		return err
+	}
+	schedule.DocType = feeScheduleDocType
+
+	stored, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(feeScheduleObjectType, []string{transType, currency})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, stored)
+}
+
+// GetFeeSchedule returns the fee charged on transType in currency
+func (s *SmartContract) GetFeeSchedule(ctx contractapi.TransactionContextInterface, transType, currency string) (*FeeSchedule, error) {
+	if _, err := authorize(ctx, roleAdmin, roleDealer, roleAgent); err != nil {
+		return nil, err
+	}
+
+	schedule, err := s.getFeeSchedule(ctx, transType, currency)
+	if err != nil {
+		return nil, err
+	}
+	if schedule == nil {
+		return nil, fmt.Errorf("no %s fee schedule for %s", currency, transType)
+	}
+	return schedule, nil
+}
+
+// assessFee returns the fee payer owes on value moved by a transType
+// transaction and the asset that collects it. The collector is taken from
+// loaded when the transaction has already read it, so that both writes land
+// on the same copy. It returns a nil collector when no fee applies.
+func (s *SmartContract) assessFee(ctx contractapi.TransactionContextInterface, transType string, payer *Asset, value Money, loaded ...*Asset) (Money, *Asset, error) {
+	none := Money{Currency: value.Currency}
+	schedule, err := s.getFeeSchedule(ctx, transType, value.Currency)
+	if err != nil || schedule == nil || schedule.CollectorID == payer.MSISDN {
+		return none, nil, err
+	}
+
+	fee := schedule.fee(value)
+	if !fee.IsPositive() {
+		return none, nil, nil
+	}
+
+	var collector *Asset
+	for _, asset := range loaded {
+		if asset.MSISDN == schedule.CollectorID {
+			collector = asset
+		}
+	}
+	if collector == nil {
+		if collector, err = s.readAsset(ctx, schedule.CollectorID); err != nil {
+			return none, nil, err
+		}
+	}
+	if collector.Status != statusActive {
+		return none, nil, newContractError(errInvalidStatus, "fee collector %s is not active", collector.MSISDN)
+	}
+
+	return fee, collector, nil
+}
+
+// fee returns the fee on value under the schedule
+func (f *FeeSchedule) fee(value Money) Money {
+	fee := Money{Currency: value.Currency}
+	switch f.Type {
+	case feeTypeFlat:
+		fee.Amount = f.Flat.Amount
+	case feeTypePercentage:
+		fee.Amount = (value.Amount*f.RateBasisPoints + 5000) / 10000
+	case feeTypeTiered:
+		for _, band := range f.Bands {
+			fee.Amount = band.Fee.Amount
+			if band.UpTo.Amount == 0 || value.Amount <= band.UpTo.Amount {
+				break
+			}
+		}
+	}
+	return fee
+}
+
+// chargeFee moves fee from payer to collector, writes both and records a FEE
+// transaction for the payer linked to the transaction it was charged on,
+// together with a FEE_IN transaction for the collector. The payer's last
+// transaction fields keep describing the charged transaction.
+func (s *SmartContract) chargeFee(ctx contractapi.TransactionContextInterface, payer, collector *Asset, fee Money, charged *Transaction, now time.Time) error {
+	var err error
+	payerPrev := payer.Balance
+	collectorPrev := collector.Balance
+	if payer.Balance, err = payer.Balance.Sub(fee); err != nil {
+		return err
+	}
+	if collector.Balance, err = collector.Balance.Add(fee); err != nil {
+		return err
+	}
+
+	collector.TransAmount = fee
+	collector.TransType = "FEE_IN"
+	collector.Remarks = "Fee on " + charged.ID
+	collector.UpdatedAt = now
+
+	if err := s.putAsset(ctx, payer); err != nil {
+		return err
+	}
+	if err := s.putAsset(ctx, collector); err != nil {
+		return err
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	feeLeg := Transaction{
+		ID:             transactionID(ctx, payer.MSISDN, "FEE"),
+		AssetID:        payer.MSISDN,
+		TransType:      "FEE",
+		Amount:         fee,
+		PrevBalance:    payerPrev,
+		NewBalance:     payer.Balance,
+		Remarks:        collector.Remarks,
+		Timestamp:      now,
+		TxID:           txID,
+		CounterpartyID: collector.MSISDN,
+		LinkedTxnID:    charged.ID,
+	}
+	collectorLeg := Transaction{
+		ID:             transactionID(ctx, collector.MSISDN, "FEE_IN"),
+		AssetID:        collector.MSISDN,
+		TransType:      "FEE_IN",
+		Amount:         fee,
+		PrevBalance:    collectorPrev,
+		NewBalance:     collector.Balance,
+		Remarks:        collector.Remarks,
+		Timestamp:      now,
+		TxID:           txID,
+		CounterpartyID: payer.MSISDN,
+		LinkedTxnID:    feeLeg.ID,
+	}
+
+	if err := s.recordTransaction(ctx, feeLeg); err != nil {
+		return err
+	}
+	return s.recordTransaction(ctx, collectorLeg)
+}
+
+// getFeeSchedule loads a fee schedule, returning nil if there is none
+func (s *SmartContract) getFeeSchedule(ctx contractapi.TransactionContextInterface, transType, currency string) (*FeeSchedule, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(feeScheduleObjectType, []string{transType, currency})
+	if err != nil {
+		return nil, err
+	}
+	scheduleJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if scheduleJSON == nil {
+		return nil, nil
+	}
+
+	var schedule FeeSchedule
+	if err := json.Unmarshal(scheduleJSON, &schedule); err != nil {
+		return nil, fmt.Errorf("failed to read %s fee schedule for %s: %v", currency, transType, err)
+	}
+	return &schedule, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeeSchedule(t *testing.T) {
+	flat := FeeSchedule{Type: feeTypeFlat, Flat: usd(25)}
+	assert.Equal(t, usd(25), flat.fee(usd(100000)))
+
+	percentage := FeeSchedule{Type: feeTypePercentage, RateBasisPoints: 150}
+	assert.Equal(t, usd(150), percentage.fee(usd(10000)))
+	// 1.5% of 0.33 is 0.495, which rounds half up
+	assert.Equal(t, usd(0), percentage.fee(usd(33)))
+	assert.Equal(t, usd(1), percentage.fee(usd(34)))
+
+	tiered := FeeSchedule{Type: feeTypeTiered, Bands: []FeeBand{
+		{UpTo: usd(10000), Fee: usd(50)},
+		{UpTo: usd(100000), Fee: usd(200)},
+	}}
+	assert.Equal(t, usd(50), tiered.fee(usd(10000)))
+	assert.Equal(t, usd(200), tiered.fee(usd(10001)))
+	assert.Equal(t, usd(200), tiered.fee(usd(500000)))
+	tiered.Bands = append(tiered.Bands, FeeBand{UpTo: usd(0), Fee: usd(500)})
+	assert.Equal(t, usd(500), tiered.fee(usd(500000)))
+}
+
+func TestSetFeeSchedule(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1000000000", Balance: usd(0), Status: "ACTIVE"}, "0000")
+	assetTransfer := SmartContract{}
+
+	err := assetTransfer.SetFeeSchedule(transactionContext, "TRANSFER", "USD", `{"type":"TIERED","collectorId":"1000000000","bands":[{"upTo":"100.00","fee":"0.50"},{"upTo":"0","fee":"2.00"}]}`)
+	assert.Nil(t, err)
+	schedule, err := assetTransfer.GetFeeSchedule(transactionContext, "TRANSFER", "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, "feeSchedule", schedule.DocType)
+	assert.Equal(t, []FeeBand{{Fee: usd(50), UpTo: usd(10000)}, {Fee: usd(200), UpTo: usd(0)}}, schedule.Bands)
+
+	err = assetTransfer.SetFeeSchedule(transactionContext, "TRANSFER", "USD", `{"type":"TIERED","collectorId":"1000000000","bands":[{"upTo":"0","fee":"0.50"},{"upTo":"100.00","fee":"2.00"}]}`)
+	assert.EqualError(t, err, "INVALID_ARGUMENT: fee bands must have increasing upper bounds, with only the last open ended")
+	err = assetTransfer.SetFeeSchedule(transactionContext, "DEBIT", "USD", `{"type":"PERCENTAGE","collectorId":"1000000000","rateBasisPoints":10001}`)
+	assert.EqualError(t, err, "INVALID_ARGUMENT: fee rate must be between 0 and 10000 basis points")
+	err = assetTransfer.SetFeeSchedule(transactionContext, "DEBIT", "USD", `{"type":"CAPPED","collectorId":"1000000000"}`)
+	assert.EqualError(t, err, `INVALID_ARGUMENT: unknown fee type "CAPPED"`)
+	err = assetTransfer.SetFeeSchedule(transactionContext, "DEBIT", "KES", `{"type":"FLAT","collectorId":"1000000000","flat":"10"}`)
+	assert.EqualError(t, err, "INVALID_ARGUMENT: fee collector 1000000000 holds USD, not KES")
+	err = assetTransfer.SetFeeSchedule(transactionContext, "ISSUE", "USD", `{"type":"FLAT","collectorId":"1000000000","flat":"0.10"}`)
+	assert.EqualError(t, err, "INVALID_ARGUMENT: fees cannot be charged on ISSUE transactions")
+	_, err = assetTransfer.GetFeeSchedule(transactionContext, "DEBIT", "USD")
+	assert.EqualError(t, err, "no USD fee schedule for DEBIT")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleAgent, ""))
+	err = assetTransfer.SetFeeSchedule(transactionContext, "DEBIT", "USD", `{"type":"FLAT","collectorId":"1000000000","flat":"0.10"}`)
+	assertForbidden(t, err)
+}
+
+func TestFeesAreCharged(t *testing.T) {
+	transactionContext, chaincodeStub, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1000000000", Balance: usd(0), Status: "ACTIVE"}, "0000")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(10000), Status: "ACTIVE"}, "1234")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567891", Balance: usd(0), Status: "ACTIVE"}, "5678")
+	assetTransfer := SmartContract{}
+	assert.Nil(t, assetTransfer.SetFeeSchedule(transactionContext, "DEBIT", "USD", `{"type":"FLAT","collectorId":"1000000000","flat":"0.25"}`))
+	assert.Nil(t, assetTransfer.SetFeeSchedule(transactionContext, "TRANSFER", "USD", `{"type":"PERCENTAGE","collectorId":"1000000000","rateBasisPoints":100}`))
+
+	// The fee must be covered on top of the amount
+	_, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "100.00", "DEBIT", "Too much")
+	assert.EqualError(t, err, "insufficient balance. Current balance: 100.00, Requested: 100.25")
+
+	chaincodeStub.GetTxIDReturns("txid1")
+	debit, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "10.00", "DEBIT", "Cash out")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(9000), debit.NewBalance)
+
+	chaincodeStub.GetTxIDReturns("txid2")
+	transfer, err := assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567891", "50.00", "Transfer")
+	assert.Nil(t, err)
+
+	history, err := assetTransfer.GetTransactionHistory(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	var fees []*Transaction
+	for _, transaction := range history {
+		if transaction.TransType == "FEE" {
+			fees = append(fees, transaction)
+		}
+	}
+	if assert.Len(t, fees, 2) {
+		assert.ElementsMatch(t, []string{debit.ID, transfer.ID}, []string{fees[0].LinkedTxnID, fees[1].LinkedTxnID})
+		assert.ElementsMatch(t, []Money{usd(25), usd(50)}, []Money{fees[0].Amount, fees[1].Amount})
+		assert.Equal(t, "1000000000", fees[0].CounterpartyID)
+	}
+
+	payer, err := assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(3925), payer.Balance)
+	assert.Equal(t, "TRANSFER_OUT", payer.TransType)
+	collector, err := assetTransfer.ReadAsset(transactionContext, "1000000000")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(75), collector.Balance)
+
+	// A transfer into the collector credits amount and fee to the same copy
+	chaincodeStub.GetTxIDReturns("txid3")
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1000000000", "10.00", "Into the collector")
+	assert.Nil(t, err)
+	collector, err = assetTransfer.ReadAsset(transactionContext, "1000000000")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(1085), collector.Balance)
+}
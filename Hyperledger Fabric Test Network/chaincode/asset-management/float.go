@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// DistributeFloat moves amount from a dealer's float to one of its assets. The
+// dealer may draw its float down to minus its credit limit. Both sides and a
+// linked DISTRIBUTION_OUT/DISTRIBUTION_IN pair are written in the same Fabric
+// transaction. The asset's leg is returned.
+func (s *SmartContract) DistributeFloat(ctx contractapi.TransactionContextInterface, dealerId, msisdn, amount, remarks string) (*Transaction, error) {
+	c, err := authorize(ctx, roleAdmin, roleDealer)
+	if err != nil {
+		return nil, err
+	}
+	if !c.canAccessDealer(dealerId) {
+		return nil, newContractError(errForbidden, "dealer %s cannot distribute float of dealer %s", c.DealerID, dealerId)
+	}
+
+	dealer, asset, value, err := s.floatParties(ctx, dealerId, msisdn, amount)
+	if err != nil {
+		return nil, err
+	}
+	if c.Role == roleDealer && c.MSPID != dealer.MSPID {
+		return nil, newContractError(errForbidden, "dealer %s belongs to %s, not %s", dealerId, dealer.MSPID, c.MSPID)
+	}
+
+	floor := Money{Amount: -dealer.CreditLimit.Amount, Currency: dealer.CreditLimit.Currency}
+	remaining, err := dealer.FloatBalance.Sub(value)
+	if err != nil {
+		return nil, err
+	}
+	short, err := remaining.LessThan(floor)
+	if err != nil {
+		return nil, err
+	}
+	if short {
+		return nil, fmt.Errorf("insufficient float. Dealer %s float: %s, credit limit: %s, requested: %s", dealerId, dealer.FloatBalance, dealer.CreditLimit, value)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.useLimits(ctx, asset, value, now); err != nil {
+		return nil, err
+	}
+	if err := s.checkMaxBalance(ctx, asset, value); err != nil {
+		return nil, err
+	}
+
+	credit, err := s.moveFloat(ctx, dealer, asset, value, true, remarks, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := emitEvent(ctx, eventBalanceChanged, *credit); err != nil {
+		return nil, err
+	}
+
+	return credit, nil
+}
+
+// ReclaimFloat moves amount from an asset back to its dealer's float, for
+// example to undo a distribution made in error. The asset's leg of the linked
+// RECLAIM_OUT/RECLAIM_IN pair is returned. As an admin correction it does not
+// count against the asset's limits, so a distribution can always be undone.
+func (s *SmartContract) ReclaimFloat(ctx contractapi.TransactionContextInterface, dealerId, msisdn, amount, remarks string) (*Transaction, error) {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return nil, err
+	}
+
+	dealer, asset, value, err := s.floatParties(ctx, dealerId, msisdn, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkAvailable(asset, value); err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	debit, err := s.moveFloat(ctx, dealer, asset, value, false, remarks, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := emitEvent(ctx, eventBalanceChanged, *debit); err != nil {
+		return nil, err
+	}
+
+	return debit, nil
+}
+
+// GetDealerTransactionHistory returns the float transactions of a dealer,
+// oldest first
+func (s *SmartContract) GetDealerTransactionHistory(ctx contractapi.TransactionContextInterface, dealerId string) ([]*Transaction, error) {
+	c, err := authorize(ctx, roleAdmin, roleDealer, roleAgent)
+	if err != nil {
+		return nil, err
+	}
+	if !c.canAccessDealer(dealerId) {
+		return nil, newContractError(errForbidden, "dealer %s cannot read transactions of dealer %s", c.DealerID, dealerId)
+	}
+
+	return s.transactionHistory(ctx, dealerId)
+}
+
+// floatParties loads and checks the dealer and asset of a float movement and
+// parses the amount in their common currency
+func (s *SmartContract) floatParties(ctx contractapi.TransactionContextInterface, dealerId, msisdn, amount string) (*Dealer, *Asset, Money, error) {
+	dealer, err := s.requireActiveDealer(ctx, dealerId)
+	if err != nil {
+		return nil, nil, Money{}, err
+	}
+	asset, err := s.readAsset(ctx, msisdn)
+	if err != nil {
+		return nil, nil, Money{}, err
+	}
+	if asset.DealerID != dealerId {
+		return nil, nil, Money{}, newContractError(errInvalidArgument, "asset %s does not belong to dealer %s", msisdn, dealerId)
+	}
+	if asset.Status != statusActive {
+		return nil, nil, Money{}, newContractError(errInvalidStatus, "account %s is not active", msisdn)
+	}
+	if asset.Balance.Currency != dealer.FloatBalance.Currency {
+		return nil, nil, Money{}, newContractError(errInvalidArgument, "currency mismatch: %s and %s", dealer.FloatBalance.Currency, asset.Balance.Currency)
+	}
+
+	value, err := ParseMoney(amount, asset.Balance.Currency)
+	if err != nil {
+		return nil, nil, Money{}, err
+	}
+	if !value.IsPositive() {
+		return nil, nil, Money{}, newContractError(errInvalidArgument, "amount must be positive")
+	}
+	return dealer, asset, value, nil
+}
+
+// moveFloat moves value between a dealer's float and an asset, towards the
+// asset when distribute is set and back to the dealer otherwise. It writes
+// both and records a linked pair of DISTRIBUTION or RECLAIM legs, the dealer's
+// under the dealer ID. It returns the asset's leg.
+func (s *SmartContract) moveFloat(ctx contractapi.TransactionContextInterface, dealer *Dealer, asset *Asset, value Money, distribute bool, remarks string, now time.Time) (*Transaction, error) {
+	var err error
+	dealerPrev := dealer.FloatBalance
+	assetPrev := asset.Balance
+	dealerType, assetType := "RECLAIM_IN", "RECLAIM_OUT"
+	if distribute {
+		dealerType, assetType = "DISTRIBUTION_OUT", "DISTRIBUTION_IN"
+		if dealer.FloatBalance, err = dealer.FloatBalance.Sub(value); err != nil {
+			return nil, err
+		}
+		if asset.Balance, err = asset.Balance.Add(value); err != nil {
+			return nil, err
+		}
+	} else {
+		if dealer.FloatBalance, err = dealer.FloatBalance.Add(value); err != nil {
+			return nil, err
+		}
+		if asset.Balance, err = asset.Balance.Sub(value); err != nil {
+			return nil, err
+		}
+	}
+
+	dealer.UpdatedAt = now
+	asset.TransAmount = value
+	asset.TransType = assetType
+	asset.Remarks = remarks
+	asset.UpdatedAt = now
+
+	if err := s.putDealer(ctx, dealer); err != nil {
+		return nil, err
+	}
+	if err := s.putAsset(ctx, asset); err != nil {
+		return nil, err
+	}
+
+	// Record both legs, each pointing at the other
+	txID := ctx.GetStub().GetTxID()
+	dealerLeg := Transaction{
+		ID:             transactionID(ctx, dealer.ID, dealerType),
+		AssetID:        dealer.ID,
+		TransType:      dealerType,
+		Amount:         value,
+		PrevBalance:    dealerPrev,
+		NewBalance:     dealer.FloatBalance,
+		Remarks:        remarks,
+		Timestamp:      now,
+		TxID:           txID,
+		CounterpartyID: asset.MSISDN,
+	}
+	assetLeg := Transaction{
+		ID:             transactionID(ctx, asset.MSISDN, assetType),
+		AssetID:        asset.MSISDN,
+		TransType:      assetType,
+		Amount:         value,
+		PrevBalance:    assetPrev,
+		NewBalance:     asset.Balance,
+		Remarks:        remarks,
+		Timestamp:      now,
+		TxID:           txID,
+		CounterpartyID: dealer.ID,
+	}
+	dealerLeg.LinkedTxnID = assetLeg.ID
+	assetLeg.LinkedTxnID = dealerLeg.ID
+
+	if err := s.recordTransaction(ctx, dealerLeg); err != nil {
+		return nil, err
+	}
+	if err := s.recordTransaction(ctx, assetLeg); err != nil {
+		return nil, err
+	}
+
+	return &assetLeg, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistributeFloat(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER001", MSPID: "Org1MSP", Status: "ACTIVE", CreditLimit: usd(5000), FloatBalance: usd(0)})
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER002", MSPID: "Org1MSP", Status: "ACTIVE"})
+	assetTransfer := SmartContract{}
+	assert.Nil(t, assetTransfer.CreateAsset(withMPIN(transactionContext, "1234"), "1234567890", "DEALER001", "0.00", "USD", "ACTIVE", "New asset"))
+	assert.Nil(t, assetTransfer.CreateAsset(withMPIN(transactionContext, "5678"), "1234567891", "DEALER002", "0.00", "USD", "ACTIVE", "New asset"))
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleTreasury, ""))
+	issue, err := assetTransfer.Issue(transactionContext, "DEALER001", "1000.00", "Bank ref 42")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(100000), issue.NewBalance)
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleAdmin, ""))
+
+	credit, err := assetTransfer.DistributeFloat(transactionContext, "DEALER001", "1234567890", "1030.00", "Float sale")
+	assert.Nil(t, err)
+	assert.Equal(t, "DISTRIBUTION_IN", credit.TransType)
+	assert.Equal(t, "DEALER001", credit.CounterpartyID)
+	assert.Equal(t, usd(103000), credit.NewBalance)
+
+	// The credit limit lets the float go negative, but no further
+	dealer, err := assetTransfer.ReadDealer(transactionContext, "DEALER001")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(-3000), dealer.FloatBalance)
+	_, err = assetTransfer.DistributeFloat(transactionContext, "DEALER001", "1234567890", "20.01", "Over limit")
+	assert.EqualError(t, err, "insufficient float. Dealer DEALER001 float: -30.00, credit limit: 50.00, requested: 20.01")
+
+	_, err = assetTransfer.DistributeFloat(transactionContext, "DEALER001", "1234567891", "1.00", "Other dealer's asset")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: asset 1234567891 does not belong to dealer DEALER001")
+	_, err = assetTransfer.DistributeFloat(transactionContext, "DEALER001", "1234567890", "0.00", "Nothing")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: amount must be positive")
+
+	debit, err := assetTransfer.ReclaimFloat(transactionContext, "DEALER001", "1234567890", "30.00", "Sold in error")
+	assert.Nil(t, err)
+	assert.Equal(t, "RECLAIM_OUT", debit.TransType)
+	assert.Equal(t, usd(100000), debit.NewBalance)
+	_, err = assetTransfer.ReclaimFloat(transactionContext, "DEALER001", "1234567890", "1000.01", "Too much")
+	assert.EqualError(t, err, "insufficient balance. Current balance: 1000.00, Requested: 1000.01")
+
+	// The dealer's legs are recorded under its ID, linked to the asset's
+	history, err := assetTransfer.GetDealerTransactionHistory(transactionContext, "DEALER001")
+	assert.Nil(t, err)
+	var types []string
+	for _, transaction := range history {
+		types = append(types, transaction.TransType)
+	}
+	assert.ElementsMatch(t, []string{"ISSUE", "DISTRIBUTION_OUT", "RECLAIM_IN"}, types)
+	for _, transaction := range history {
+		if transaction.TransType == "DISTRIBUTION_OUT" {
+			assert.Equal(t, credit.ID, transaction.LinkedTxnID)
+			assert.Equal(t, transaction.ID, credit.LinkedTxnID)
+		}
+	}
+
+	checks, err := assetTransfer.CheckSupply(transactionContext)
+	assert.Nil(t, err)
+	assert.Len(t, checks, 1)
+	assert.Equal(t, usd(100000), checks[0].Issued)
+	assert.Equal(t, usd(100000), checks[0].AssetBalances)
+	assert.Equal(t, usd(0), checks[0].DealerFloat)
+	assert.True(t, checks[0].Balanced)
+}
+
+func TestFloatAccess(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER001", MSPID: "Org1MSP", Status: "ACTIVE", CreditLimit: usd(0), FloatBalance: usd(10000)})
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(0), Status: "ACTIVE"}, "1234")
+	assetTransfer := SmartContract{}
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleDealer, "DEALER001"))
+	_, err := assetTransfer.DistributeFloat(transactionContext, "DEALER001", "1234567890", "50.00", "Float sale")
+	assert.Nil(t, err)
+	_, err = assetTransfer.ReclaimFloat(transactionContext, "DEALER001", "1234567890", "50.00", "Reclaim")
+	assertForbidden(t, err)
+	_, err = assetTransfer.Issue(transactionContext, "DEALER001", "50.00", "Self issued")
+	assertForbidden(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleDealer, "DEALER002"))
+	_, err = assetTransfer.DistributeFloat(transactionContext, "DEALER001", "1234567890", "50.00", "Float sale")
+	assertForbidden(t, err)
+
+	// The dealerId attribute only counts when issued by the dealer's own organisation
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org2MSP", roleDealer, "DEALER001"))
+	_, err = assetTransfer.DistributeFloat(transactionContext, "DEALER001", "1234567890", "50.00", "Float sale")
+	assertForbidden(t, err)
+}
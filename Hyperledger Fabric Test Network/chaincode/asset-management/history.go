@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// AssetHistoryRecord is one version of an asset key as kept by the ledger.
+// Asset is the value written by that transaction and is nil when IsDelete is set.
+type AssetHistoryRecord struct {
+	TxID      string    `json:"txId"`
+	Timestamp time.Time `json:"timestamp"`
+	IsDelete  bool      `json:"isDelete"`
+	Asset     *Asset    `json:"asset,omitempty" metadata:",optional"`
+}
+
+// GetAssetHistory returns every version of the given asset key, including
+// deletes, straight from the peer's history database. Unlike
+// GetTransactionHistory it does not depend on records written by the contract.
+func (s *SmartContract) GetAssetHistory(ctx contractapi.TransactionContextInterface, msisdn string) ([]*AssetHistoryRecord, error) {
+	if err := s.authorizeAssetRecords(ctx, msisdn); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(msisdn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of asset %s: %v", msisdn, err)
+	}
+	defer resultsIterator.Close()
+
+	modifications := []*queryresult.KeyModification{}
+	for resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		modifications = append(modifications, modification)
+	}
+
+	// Versions written before balances became Money hold float64 amounts. They
+	// are converted the way MigrateBalances would, in the currency of the newest
+	// Money version.
+	currency := historyCurrency(modifications)
+
+	records := []*AssetHistoryRecord{}
+	for _, modification := range modifications {
+		record := AssetHistoryRecord{
+			TxID:     modification.TxId,
+			IsDelete: modification.IsDelete,
+		}
+		if modification.Timestamp != nil {
+			record.Timestamp = modification.Timestamp.AsTime()
+		}
+		if !modification.IsDelete {
+			asset, err := historyAsset(modification.Value, currency)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read version %s of asset %s: %v", modification.TxId, msisdn, err)
+			}
+			record.Asset = asset
+		}
+		records = append(records, &record)
+	}
+
+	return records, nil
+}
+
+// historyCurrency returns the balance currency of the newest version holding
+// Money, or defaultCurrency if every version predates it
+func historyCurrency(modifications []*queryresult.KeyModification) string {
+	for _, modification := range modifications {
+		if modification.IsDelete {
+			continue
+		}
+		var version struct {
+			Balance json.RawMessage `json:"balance"`
+		}
+		if err := json.Unmarshal(modification.Value, &version); err != nil || len(version.Balance) == 0 || version.Balance[0] != '{' {
+			continue
+		}
+		var balance Money
+		if err := json.Unmarshal(version.Balance, &balance); err == nil && balance.Currency != "" {
+			return balance.Currency
+		}
+	}
+	return defaultCurrency
+}
+
+// historyAsset decodes one version of an asset, converting float64 amounts to
+// Money in the given currency
+func historyAsset(value []byte, currency string) (*Asset, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return nil, err
+	}
+	converted, err := convertLegacyAmounts("the asset", fields, currency)
+	if err != nil {
+		return nil, err
+	}
+	if converted {
+		if value, err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+	}
+
+	var asset Asset
+	if err := json.Unmarshal(value, &asset); err != nil {
+		return nil, err
+	}
+	return &asset, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-samples/asset-management/mocks"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestGetAssetHistory(t *testing.T) {
+	chaincodeStub := &mocks.ChaincodeStub{}
+	transactionContext := &mocks.TransactionContext{}
+	transactionContext.GetStubReturns(chaincodeStub)
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleAdmin, ""))
+
+	created, _ := json.Marshal(Asset{DocType: assetDocType, MSISDN: "1234567890", Balance: usd(100000), Status: "ACTIVE"})
+	suspended, _ := json.Marshal(Asset{DocType: assetDocType, MSISDN: "1234567890", Balance: usd(100000), Status: "SUSPENDED"})
+	modifications := []*queryresult.KeyModification{
+		{TxId: "txid3", Timestamp: timestamppb.New(testTxTimestamp.Add(2 * time.Hour)), IsDelete: true},
+		{TxId: "txid2", Timestamp: timestamppb.New(testTxTimestamp.Add(time.Hour)), Value: suspended},
+		{TxId: "txid1", Timestamp: timestamppb.New(testTxTimestamp), Value: created},
+	}
+	iterator := &mocks.HistoryQueryIterator{}
+	iterator.HasNextCalls(func() bool {
+		return iterator.NextCallCount() < len(modifications)
+	})
+	iterator.NextCalls(func() (*queryresult.KeyModification, error) {
+		return modifications[iterator.NextCallCount()-1], nil
+	})
+	chaincodeStub.GetHistoryForKeyReturns(iterator, nil)
+
+	assetTransfer := SmartContract{}
+	history, err := assetTransfer.GetAssetHistory(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Len(t, history, 3)
+	assert.Equal(t, "1234567890", chaincodeStub.GetHistoryForKeyArgsForCall(0))
+
+	assert.True(t, history[0].IsDelete)
+	assert.Nil(t, history[0].Asset)
+	assert.Equal(t, "txid2", history[1].TxID)
+	assert.Equal(t, testTxTimestamp.Add(time.Hour), history[1].Timestamp)
+	assert.Equal(t, "SUSPENDED", history[1].Asset.Status)
+	assert.Equal(t, "ACTIVE", history[2].Asset.Status)
+	assert.Equal(t, 1, iterator.CloseCallCount())
+
+	// A version written before balances became Money is read in the currency
+	// of the newer versions
+	legacy := []byte(`{"balance":1000.1,"dealerId":"DEALER001","msisdn":"1234567890","status":"ACTIVE","transAmount":0.3,"transType":"CREDIT"}`)
+	converted, _ := json.Marshal(Asset{DocType: assetDocType, MSISDN: "1234567890", Balance: Money{Amount: 100010, Currency: "KES"}, Status: "ACTIVE"})
+	modifications = []*queryresult.KeyModification{
+		{TxId: "txid2", Timestamp: timestamppb.New(testTxTimestamp.Add(time.Hour)), Value: converted},
+		{TxId: "txid1", Timestamp: timestamppb.New(testTxTimestamp), Value: legacy},
+	}
+	iterator = &mocks.HistoryQueryIterator{}
+	iterator.HasNextCalls(func() bool {
+		return iterator.NextCallCount() < len(modifications)
+	})
+	iterator.NextCalls(func() (*queryresult.KeyModification, error) {
+		return modifications[iterator.NextCallCount()-1], nil
+	})
+	chaincodeStub.GetHistoryForKeyReturns(iterator, nil)
+
+	history, err = assetTransfer.GetAssetHistory(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, Money{Amount: 100010, Currency: "KES"}, history[1].Asset.Balance)
+	assert.Equal(t, Money{Amount: 30, Currency: "KES"}, history[1].Asset.TransAmount)
+	assert.Equal(t, "CREDIT", history[1].Asset.TransType)
+
+	// With no Money version to go by the default currency is used
+	modifications = modifications[1:]
+	iterator = &mocks.HistoryQueryIterator{}
+	iterator.HasNextCalls(func() bool {
+		return iterator.NextCallCount() < len(modifications)
+	})
+	iterator.NextCalls(func() (*queryresult.KeyModification, error) {
+		return modifications[iterator.NextCallCount()-1], nil
+	})
+	chaincodeStub.GetHistoryForKeyReturns(iterator, nil)
+
+	history, err = assetTransfer.GetAssetHistory(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(100010), history[0].Asset.Balance)
+
+	chaincodeStub.GetHistoryForKeyReturns(nil, fmt.Errorf("history database disabled"))
+	_, err = assetTransfer.GetAssetHistory(transactionContext, "1234567890")
+	assert.EqualError(t, err, "failed to read history of asset 1234567890: history database disabled")
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Hold reserves part of an asset's balance, for example while a merchant
+// confirms a purchase. Held funds stay in the asset's ledger balance but are
+// not available to spend until the hold is captured or released. The hold ID
+// is the ID of the HOLD transaction that placed it.
+type Hold struct {
+	Amount    Money     `json:"amount"`
+	Captured  Money     `json:"captured"`
+	CreatedAt time.Time `json:"createdAt"`
+	DocType   string    `json:"docType"`
+	Expiry    time.Time `json:"expiry"`
+	ID        string    `json:"id"`
+	MSISDN    string    `json:"msisdn"`
+	Reference string    `json:"reference"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+const (
+	holdDocType = "hold"
+	// holdObjectType keys holds by ID
+	holdObjectType = "hold"
+	// assetHoldIndex maps an MSISDN to the IDs of its ACTIVE holds
+	assetHoldIndex = "msisdn~hold"
+)
+
+// Hold statuses. Only ACTIVE holds reserve funds.
+const (
+	holdStatusActive   = "ACTIVE"
+	holdStatusCaptured = "CAPTURED"
+	holdStatusReleased = "RELEASED"
+)
+
+// PlaceHold reserves amount of an asset's available balance until expiry, an
+// RFC 3339 timestamp, under the caller's reference. Like UpdateAssetBalance it
+// needs the subscriber's MPIN in the transient map and returns the recorded
+// transaction, whose ID identifies the hold; a wrong MPIN yields an
+// MPIN_FAILED record instead. The amount counts against the asset's limits
+// when the hold is placed.
+func (s *SmartContract) PlaceHold(ctx contractapi.TransactionContextInterface, msisdn, amount, expiry, reference string) (*Transaction, error) {
+	c, err := authorize(ctx, roleAdmin, roleDealer, roleAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	asset, err := s.readAsset(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkAsset(asset); err != nil {
+		return nil, err
+	}
+
+	// Malformed requests are refused before they can count as an MPIN attempt
+	value, err := ParseMoney(amount, asset.Balance.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if !value.IsPositive() {
+		return nil, newContractError(errInvalidArgument, "hold amount must be positive")
+	}
+	expiresAt, err := time.Parse(time.RFC3339, expiry)
+	if err != nil {
+		return nil, newContractError(errInvalidArgument, "invalid expiry %q: %v", expiry, err)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !expiresAt.After(now) {
+		return nil, newContractError(errInvalidArgument, "hold expiry %s is not in the future", expiry)
+	}
+
+	rejected, err := s.checkMPIN(ctx, asset)
+	if err != nil || rejected != nil {
+		return rejected, err
+	}
+
+	if asset.Status != statusActive {
+		return nil, newContractError(errInvalidStatus, "account %s is not active", msisdn)
+	}
+
+	if err := checkAvailable(asset, value); err != nil {
+		return nil, err
+	}
+	if err := s.useLimits(ctx, asset, value, now); err != nil {
+		return nil, err
+	}
+
+	if asset.HeldBalance, err = asset.HeldBalance.Add(value); err != nil {
+		return nil, err
+	}
+	asset.TransAmount = value
+	asset.TransType = "HOLD"
+	asset.Remarks = reference
+	asset.UpdatedAt = now
+	if err := s.putAsset(ctx, asset); err != nil {
+		return nil, err
+	}
+
+	transaction := Transaction{
+		ID:          transactionID(ctx, msisdn, "HOLD"),
+		AssetID:     msisdn,
+		TransType:   "HOLD",
+		Amount:      value,
+		PrevBalance: asset.Balance,
+		NewBalance:  asset.Balance,
+		Remarks:     reference,
+		Timestamp:   now,
+		TxID:        ctx.GetStub().GetTxID(),
+	}
+	if err := s.recordTransaction(ctx, transaction); err != nil {
+		return nil, err
+	}
+
+	hold := Hold{
+		Amount:    value,
+		Captured:  Money{Currency: value.Currency},
+		CreatedAt: now,
+		Expiry:    expiresAt.UTC(),
+		ID:        transaction.ID,
+		MSISDN:    msisdn,
+		Reference: reference,
+		Status:    holdStatusActive,
+		UpdatedAt: now,
+	}
+	if err := s.putHold(ctx, &hold); err != nil {
+		return nil, err
+	}
+
+	if err := emitEvent(ctx, eventBalanceChanged, transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// CaptureHold takes amount, at most the held amount, out of the asset's
+// balance and releases the rest of the hold. Like a DEBIT, the captured value
+// leaves the system, so the asset must be ACTIVE. Expired holds cannot be
+// captured.
+func (s *SmartContract) CaptureHold(ctx contractapi.TransactionContextInterface, holdId, amount string) (*Transaction, error) {
+	c, err := authorize(ctx, roleAdmin, roleDealer, roleAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	hold, asset, err := s.activeHold(ctx, holdId)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkAsset(asset); err != nil {
+		return nil, err
+	}
+	if asset.Status != statusActive {
+		return nil, newContractError(errInvalidStatus, "account %s is not active", asset.MSISDN)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !now.Before(hold.Expiry) {
+		return nil, newContractError(errInvalidTransition, "hold %s expired at %s", holdId, hold.Expiry.Format(time.RFC3339))
+	}
+
+	value, err := ParseMoney(amount, hold.Amount.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if !value.IsPositive() {
+		return nil, newContractError(errInvalidArgument, "capture amount must be positive")
+	}
+	over, err := hold.Amount.LessThan(value)
+	if err != nil {
+		return nil, err
+	}
+	if over {
+		return nil, newContractError(errInvalidArgument, "cannot capture %s of hold %s, which holds %s", value, holdId, hold.Amount)
+	}
+
+	prevBalance := asset.Balance
+	if asset.Balance, err = asset.Balance.Sub(value); err != nil {
+		return nil, err
+	}
+	if asset.HeldBalance, err = asset.HeldBalance.Sub(hold.Amount); err != nil {
+		return nil, err
+	}
+	asset.TransAmount = value
+	asset.TransType = "CAPTURE"
+	asset.Remarks = hold.Reference
+	asset.UpdatedAt = now
+	if err := s.putAsset(ctx, asset); err != nil {
+		return nil, err
+	}
+	if err := s.adjustIssued(ctx, Money{Amount: -value.Amount, Currency: value.Currency}); err != nil {
+		return nil, err
+	}
+
+	transaction := Transaction{
+		ID:          transactionID(ctx, asset.MSISDN, "CAPTURE"),
+		AssetID:     asset.MSISDN,
+		TransType:   "CAPTURE",
+		Amount:      value,
+		PrevBalance: prevBalance,
+		NewBalance:  asset.Balance,
+		Remarks:     hold.Reference,
+		Timestamp:   now,
+		TxID:        ctx.GetStub().GetTxID(),
+		LinkedTxnID: hold.ID,
+	}
+	if err := s.recordTransaction(ctx, transaction); err != nil {
+		return nil, err
+	}
+
+	hold.Captured = value
+	hold.Status = holdStatusCaptured
+	hold.UpdatedAt = now
+	if err := s.putHold(ctx, hold); err != nil {
+		return nil, err
+	}
+
+	if err := emitEvent(ctx, eventBalanceChanged, transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// ReleaseHold makes the funds of a hold available again without taking them.
+// Until the hold expires only a caller with access to the asset may release
+// it; once the transaction timestamp has reached its expiry any admin, dealer
+// or agent may.
+func (s *SmartContract) ReleaseHold(ctx contractapi.TransactionContextInterface, holdId string) (*Transaction, error) {
+	c, err := authorize(ctx, roleAdmin, roleDealer, roleAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	hold, asset, err := s.activeHold(ctx, holdId)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if now.Before(hold.Expiry) {
+		if err := c.checkAsset(asset); err != nil {
+			return nil, err
+		}
+	}
+
+	if asset.HeldBalance, err = asset.HeldBalance.Sub(hold.Amount); err != nil {
+		return nil, err
+	}
+	asset.TransAmount = hold.Amount
+	asset.TransType = "RELEASE"
+	asset.Remarks = hold.Reference
+	asset.UpdatedAt = now
+	if err := s.putAsset(ctx, asset); err != nil {
+		return nil, err
+	}
+
+	transaction := Transaction{
+		ID:          transactionID(ctx, asset.MSISDN, "RELEASE"),
+		AssetID:     asset.MSISDN,
+		TransType:   "RELEASE",
+		Amount:      hold.Amount,
+		PrevBalance: asset.Balance,
+		NewBalance:  asset.Balance,
+		Remarks:     hold.Reference,
+		Timestamp:   now,
+		TxID:        ctx.GetStub().GetTxID(),
+		LinkedTxnID: hold.ID,
+	}
+	if err := s.recordTransaction(ctx, transaction); err != nil {
+		return nil, err
+	}
+
+	hold.Status = holdStatusReleased
+	hold.UpdatedAt = now
+	if err := s.putHold(ctx, hold); err != nil {
+		return nil, err
+	}
+
+	if err := emitEvent(ctx, eventBalanceChanged, transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// ReadHold returns the hold with the given ID
+func (s *SmartContract) ReadHold(ctx contractapi.TransactionContextInterface, holdId string) (*Hold, error) {
+	c, err := authorize(ctx, roleAdmin, roleDealer, roleAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	hold, err := s.readHold(ctx, holdId)
+	if err != nil {
+		return nil, err
+	}
+	asset, err := s.readAsset(ctx, hold.MSISDN)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkAsset(asset); err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+// activeHold loads a hold that still reserves funds, together with its asset
+func (s *SmartContract) activeHold(ctx contractapi.TransactionContextInterface, holdId string) (*Hold, *Asset, error) {
+	hold, err := s.readHold(ctx, holdId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if hold.Status != holdStatusActive {
+		return nil, nil, newContractError(errInvalidTransition, "hold %s is already %s", holdId, hold.Status)
+	}
+
+	asset, err := s.readAsset(ctx, hold.MSISDN)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hold, asset, nil
+}
+
+// readHold loads a hold, failing if it does not exist
+func (s *SmartContract) readHold(ctx contractapi.TransactionContextInterface, id string) (*Hold, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(holdObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	holdJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if holdJSON == nil {
+		return nil, fmt.Errorf("the hold %s does not exist", id)
+	}
+
+	var hold Hold
+	if err := json.Unmarshal(holdJSON, &hold); err != nil {
+		return nil, fmt.Errorf("failed to read hold %s: %v", id, err)
+	}
+	return &hold, nil
+}
+
+// putHold writes a hold to the world state
+func (s *SmartContract) putHold(ctx contractapi.TransactionContextInterface, hold *Hold) error {
+	hold.DocType = holdDocType
+	holdJSON, err := json.Marshal(hold)
+	if err != nil {
+		return err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(holdObjectType, []string{hold.ID})
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(key, holdJSON); err != nil {
+		return err
+	}
+
+	// Only ACTIVE holds stay in their asset's index
+	indexKey, err := ctx.GetStub().CreateCompositeKey(assetHoldIndex, []string{hold.MSISDN, hold.ID})
+	if err != nil {
+		return err
+	}
+	if hold.Status != holdStatusActive {
+		return ctx.GetStub().DelState(indexKey)
+	}
+	return ctx.GetStub().PutState(indexKey, []byte{0x00})
+}
+
+// excludeExpiredHolds makes the funds of asset's ACTIVE holds that expired by
+// the transaction timestamp available again. They stay in its held balance
+// until the holds are released, which any role may then do. Holds placed before
+// the hold index was introduced are not found and keep their funds held until
+// they are released.
+func (s *SmartContract) excludeExpiredHolds(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	if !asset.HeldBalance.IsPositive() {
+		return nil
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(assetHoldIndex, []string{asset.MSISDN})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	expired := Money{Currency: asset.HeldBalance.Currency}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+		if len(attributes) != 2 {
+			continue
+		}
+
+		hold, err := s.readHold(ctx, attributes[1])
+		if err != nil {
+			return err
+		}
+		if hold.Status != holdStatusActive || now.Before(hold.Expiry) {
+			continue
+		}
+		if expired, err = expired.Add(hold.Amount); err != nil {
+			return err
+		}
+	}
+
+	return setAvailableBalance(asset, expired)
+}
+
+// setAvailableBalance derives an asset's available balance, its balance less
+// outstanding holds other than the expired amount. Assets written before holds
+// were introduced hold nothing.
+func setAvailableBalance(asset *Asset, expired Money) error {
+	if asset.HeldBalance.Currency == "" {
+		asset.HeldBalance = Money{Currency: asset.Balance.Currency}
+	}
+	if expired.Currency == "" {
+		expired.Currency = asset.HeldBalance.Currency
+	}
+	held, err := asset.HeldBalance.Sub(expired)
+	if err != nil {
+		return err
+	}
+	available, err := asset.Balance.Sub(held)
+	if err != nil {
+		return err
+	}
+	asset.AvailableBalance = available
+	return nil
+}
+
+// checkAvailable fails when amount is more than asset's available balance.
+// The asset must have been loaded with readAsset, which leaves holds that have
+// expired out of its available balance.
+func checkAvailable(asset *Asset, amount Money) error {
+	insufficient, err := asset.AvailableBalance.LessThan(amount)
+	if err != nil {
+		return err
+	}
+	if !insufficient {
+		return nil
+	}
+	held, err := asset.Balance.Sub(asset.AvailableBalance)
+	if err != nil {
+		return err
+	}
+	if held.IsPositive() {
+		return fmt.Errorf("insufficient available balance. Available balance: %s, held: %s, Requested: %s", asset.AvailableBalance, held, amount)
+	}
+	return fmt.Errorf("insufficient balance. Current balance: %s, Requested: %s", asset.Balance, amount)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestPlaceAndCaptureHold(t *testing.T) {
+	transactionContext, chaincodeStub, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(10000), Status: "ACTIVE"}, "1234")
+	assetTransfer := SmartContract{}
+	assert.Nil(t, assetTransfer.putIssued(transactionContext, usd(10000)))
+
+	expiry := testTxTimestamp.Add(time.Hour).Format(time.RFC3339)
+	_, err := assetTransfer.PlaceHold(withMPIN(transactionContext, "1234"), "1234567890", "30.00", testTxTimestamp.Format(time.RFC3339), "ORDER-1")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: hold expiry 2024-01-15T10:30:00Z is not in the future")
+	_, err = assetTransfer.PlaceHold(withMPIN(transactionContext, "1234"), "1234567890", "300.00", expiry, "ORDER-1")
+	assert.EqualError(t, err, "insufficient balance. Current balance: 100.00, Requested: 300.00")
+
+	// Malformed requests do not count as MPIN attempts
+	_, err = assetTransfer.PlaceHold(withMPIN(transactionContext, "0000"), "1234567890", "0", expiry, "ORDER-1")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: hold amount must be positive")
+	_, err = assetTransfer.PlaceHold(withMPIN(transactionContext, "0000"), "1234567890", "30.00", "tomorrow", "ORDER-1")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: invalid expiry "tomorrow": parsing time "tomorrow" as "2006-01-02T15:04:05Z07:00": cannot parse "tomorrow" as "2006"`)
+	_, err = assetTransfer.PlaceHold(withMPIN(transactionContext, "0000"), "1234567890", "30.00", testTxTimestamp.Format(time.RFC3339), "ORDER-1")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: hold expiry 2024-01-15T10:30:00Z is not in the future")
+	asset, err := assetTransfer.readAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, asset.FailedMPINAttempts)
+
+	chaincodeStub.GetTxIDReturns("txid1")
+	placed, err := assetTransfer.PlaceHold(withMPIN(transactionContext, "1234"), "1234567890", "80.00", expiry, "ORDER-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "HOLD", placed.TransType)
+	assert.Equal(t, "1234567890-HOLD-txid1", placed.ID)
+
+	asset, err = assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(10000), asset.Balance)
+	assert.Equal(t, usd(8000), asset.HeldBalance)
+	assert.Equal(t, usd(2000), asset.AvailableBalance)
+
+	// Debits may only spend the available balance
+	chaincodeStub.GetTxIDReturns("txid2")
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "30.00", "DEBIT", "Over the available balance")
+	assert.EqualError(t, err, "insufficient available balance. Available balance: 20.00, held: 80.00, Requested: 30.00")
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "20.00", "DEBIT", "Debit")
+	assert.Nil(t, err)
+
+	_, err = assetTransfer.CaptureHold(transactionContext, placed.ID, "90.00")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: cannot capture 90.00 of hold 1234567890-HOLD-txid1, which holds 80.00")
+
+	// Capturing less than the hold releases the rest
+	chaincodeStub.GetTxIDReturns("txid3")
+	capture, err := assetTransfer.CaptureHold(transactionContext, placed.ID, "50.00")
+	assert.Nil(t, err)
+	assert.Equal(t, "CAPTURE", capture.TransType)
+	assert.Equal(t, placed.ID, capture.LinkedTxnID)
+	assert.Equal(t, usd(3000), capture.NewBalance)
+	asset, err = assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(0), asset.HeldBalance)
+	assert.Equal(t, usd(3000), asset.AvailableBalance)
+
+	hold, err := assetTransfer.ReadHold(transactionContext, placed.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "CAPTURED", hold.Status)
+	assert.Equal(t, usd(5000), hold.Captured)
+	_, err = assetTransfer.ReleaseHold(transactionContext, placed.ID)
+	assert.EqualError(t, err, "INVALID_TRANSITION: hold 1234567890-HOLD-txid1 is already CAPTURED")
+
+	// The captured value left the system
+	checks, err := assetTransfer.CheckSupply(transactionContext)
+	assert.Nil(t, err)
+	assert.True(t, checks[0].Balanced)
+}
+
+func TestReleaseHold(t *testing.T) {
+	transactionContext, chaincodeStub, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(10000), Status: "ACTIVE"}, "1234")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER002", MSISDN: "1234567891", Balance: usd(0), Status: "ACTIVE"}, "5678")
+	assetTransfer := SmartContract{}
+
+	expiry := testTxTimestamp.Add(time.Hour).Format(time.RFC3339)
+	chaincodeStub.GetTxIDReturns("txid1")
+	first, err := assetTransfer.PlaceHold(withMPIN(transactionContext, "1234"), "1234567890", "40.00", expiry, "ORDER-1")
+	assert.Nil(t, err)
+	chaincodeStub.GetTxIDReturns("txid2")
+	second, err := assetTransfer.PlaceHold(withMPIN(transactionContext, "1234"), "1234567890", "40.00", expiry, "ORDER-2")
+	assert.Nil(t, err)
+
+	// Held funds cannot be transferred and block closing the account
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567891", "30.00", "Transfer")
+	assert.EqualError(t, err, "insufficient available balance. Available balance: 20.00, held: 80.00, Requested: 30.00")
+	err = assetTransfer.DeleteAsset(transactionContext, "1234567890", "1234567891", "Closing")
+	assert.EqualError(t, err, "INVALID_STATUS: account 1234567890 has 80.00 held; capture or release its holds to close it")
+
+	chaincodeStub.GetTxIDReturns("txid3")
+	release, err := assetTransfer.ReleaseHold(transactionContext, first.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "RELEASE", release.TransType)
+	assert.Equal(t, usd(10000), release.NewBalance)
+
+	// Before expiry only callers with access to the asset may release a hold
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleDealer, "DEALER002"))
+	_, err = assetTransfer.ReleaseHold(transactionContext, second.ID)
+	assertForbidden(t, err)
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", "", ""))
+	_, err = assetTransfer.ReleaseHold(transactionContext, second.ID)
+	assertForbidden(t, err)
+
+	// Once expired it can no longer be captured, and any caller with a role may
+	// release it
+	chaincodeStub.GetTxTimestampReturns(timestamppb.New(testTxTimestamp.Add(time.Hour)), nil)
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleAdmin, ""))
+	_, err = assetTransfer.CaptureHold(transactionContext, second.ID, "40.00")
+	assert.EqualError(t, err, "INVALID_TRANSITION: hold 1234567890-HOLD-txid2 expired at 2024-01-15T11:30:00Z")
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", "", ""))
+	_, err = assetTransfer.ReleaseHold(transactionContext, second.ID)
+	assertForbidden(t, err)
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleDealer, "DEALER002"))
+	chaincodeStub.GetTxIDReturns("txid4")
+	_, err = assetTransfer.ReleaseHold(transactionContext, second.ID)
+	assert.Nil(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleAdmin, ""))
+	asset, err := assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(0), asset.HeldBalance)
+	assert.Equal(t, usd(10000), asset.AvailableBalance)
+}
+
+func TestCaptureHoldRequiresActiveAsset(t *testing.T) {
+	transactionContext, chaincodeStub, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(10000), Status: "ACTIVE"}, "1234")
+	assetTransfer := SmartContract{}
+
+	chaincodeStub.GetTxIDReturns("txid1")
+	placed, err := assetTransfer.PlaceHold(withMPIN(transactionContext, "1234"), "1234567890", "40.00", testTxTimestamp.Add(time.Hour).Format(time.RFC3339), "ORDER-1")
+	assert.Nil(t, err)
+
+	for _, status := range []string{"SUSPENDED", "BLOCKED"} {
+		assert.Nil(t, assetTransfer.UpdateAssetStatus(transactionContext, "1234567890", status, "Investigation"))
+		_, err = assetTransfer.CaptureHold(transactionContext, placed.ID, "40.00")
+		assert.EqualError(t, err, "INVALID_STATUS: account 1234567890 is not active")
+		assert.Nil(t, assetTransfer.UpdateAssetStatus(transactionContext, "1234567890", "ACTIVE", "Cleared"))
+	}
+
+	chaincodeStub.GetTxIDReturns("txid2")
+	_, err = assetTransfer.CaptureHold(transactionContext, placed.ID, "40.00")
+	assert.Nil(t, err)
+}
+
+func TestExpiredHoldsAreAvailable(t *testing.T) {
+	transactionContext, chaincodeStub, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(10000), Status: "ACTIVE"}, "1234")
+	assetTransfer := SmartContract{}
+
+	chaincodeStub.GetTxIDReturns("txid1")
+	expiring, err := assetTransfer.PlaceHold(withMPIN(transactionContext, "1234"), "1234567890", "50.00", testTxTimestamp.Add(time.Hour).Format(time.RFC3339), "ORDER-1")
+	assert.Nil(t, err)
+	chaincodeStub.GetTxIDReturns("txid2")
+	_, err = assetTransfer.PlaceHold(withMPIN(transactionContext, "1234"), "1234567890", "30.00", testTxTimestamp.Add(2*time.Hour).Format(time.RFC3339), "ORDER-2")
+	assert.Nil(t, err)
+
+	// Once the first hold expires its funds are available again, although
+	// it has not been released
+	chaincodeStub.GetTxTimestampReturns(timestamppb.New(testTxTimestamp.Add(time.Hour)), nil)
+	asset, err := assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(8000), asset.HeldBalance)
+	assert.Equal(t, usd(7000), asset.AvailableBalance)
+	assets, err := assetTransfer.GetAllAssets(transactionContext, false)
+	assert.Nil(t, err)
+	assert.Equal(t, usd(7000), assets[0].AvailableBalance)
+
+	chaincodeStub.GetTxIDReturns("txid3")
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "70.01", "DEBIT", "Over the available balance")
+	assert.EqualError(t, err, "insufficient available balance. Available balance: 70.00, held: 30.00, Requested: 70.01")
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "60.00", "DEBIT", "Debit")
+	assert.Nil(t, err)
+
+	// Releasing the expired hold does not make its funds available twice
+	chaincodeStub.GetTxIDReturns("txid4")
+	_, err = assetTransfer.ReleaseHold(transactionContext, expiring.ID)
+	assert.Nil(t, err)
+	asset, err = assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(4000), asset.Balance)
+	assert.Equal(t, usd(3000), asset.HeldBalance)
+	assert.Equal(t, usd(1000), asset.AvailableBalance)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Roles are read from the "role" attribute of the client's X.509 certificate,
+// issued by the Fabric CA with e.g. --id.attrs 'role=dealer:ecert,dealerId=DEALER001:ecert'.
+const (
+	// roleAdmin may call every function
+	roleAdmin = "admin"
+	// roleDealer may only create and operate assets carrying its dealerId
+	roleDealer = "dealer"
+	// roleAgent may read and move funds on any asset on behalf of subscribers
+	roleAgent = "agent"
+	// roleTreasury issues and burns float against the operator's bank account
+	roleTreasury = "treasury"
+)
+
+const (
+	roleAttribute     = "role"
+	dealerIDAttribute = "dealerId"
+	// adminMSPID is the operator's organisation; the admin and treasury
+	// roles are only honoured for its identities
+	adminMSPID = "Org1MSP"
+)
+
+// caller is the client that submitted the transaction
+type caller struct {
+	MSPID    string
+	Role     string
+	DealerID string
+}
+
+// getCaller reads the MSP ID and role attributes of the submitting client.
+// Clients without a known role are refused.
+func getCaller(ctx contractapi.TransactionContextInterface) (*caller, error) {
+	clientIdentity := ctx.GetClientIdentity()
+	mspID, err := clientIdentity.GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client MSP ID: %v", err)
+	}
+	role, _, err := clientIdentity.GetAttributeValue(roleAttribute)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client role: %v", err)
+	}
+	dealerID, _, err := clientIdentity.GetAttributeValue(dealerIDAttribute)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client dealer ID: %v", err)
+	}
+
+	switch {
+	case (role == roleAdmin || role == roleTreasury) && mspID != adminMSPID:
+		return nil, newContractError(errForbidden, "the %s role is not accepted from %s", role, mspID)
+	case role == roleDealer && dealerID == "":
+		return nil, newContractError(errForbidden, "dealer identities must carry a %s attribute", dealerIDAttribute)
+	case role != roleAdmin && role != roleDealer && role != roleAgent && role != roleTreasury:
+		return nil, newContractError(errForbidden, "client has no recognised role")
+	}
+
+	return &caller{MSPID: mspID, Role: role, DealerID: dealerID}, nil
+}
+
+// authorize returns the caller if it has one of the given roles
+func authorize(ctx contractapi.TransactionContextInterface, roles ...string) (*caller, error) {
+	c, err := getCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		if c.Role == role {
+			return c, nil
+		}
+	}
+	return nil, newContractError(errForbidden, "the %s role may not perform this operation", c.Role)
+}
+
+// canAccessDealer reports whether the caller may operate assets of dealerID.
+// Dealers are confined to their own assets.
+func (c *caller) canAccessDealer(dealerID string) bool {
+	return c.Role != roleDealer || c.DealerID == dealerID
+}
+
+// checkAsset refuses access to an asset the caller may not operate
+func (c *caller) checkAsset(asset *Asset) error {
+	if !c.canAccessDealer(asset.DealerID) {
+		return newContractError(errForbidden, "asset %s does not belong to dealer %s", asset.MSISDN, c.DealerID)
+	}
+	return nil
+}
+
+// authorizeAssetRecords checks that the caller may read the transactions and
+// history of msisdn. Only dealers are checked against the asset itself, so
+// admins and agents can still audit assets that were removed before assets
+// were closed instead of deleted.
+func (s *SmartContract) authorizeAssetRecords(ctx contractapi.TransactionContextInterface, msisdn string) error {
+	c, err := authorize(ctx, roleAdmin, roleDealer, roleAgent)
+	if err != nil {
+		return err
+	}
+	if c.Role != roleDealer {
+		return nil
+	}
+
+	asset, err := s.readAsset(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	return c.checkAsset(asset)
+}
+
+// invokerID identifies the client that submitted the transaction as
+// "<MSP ID>/<X.509 subject and issuer>", for recording who made a change.
+func invokerID(ctx contractapi.TransactionContextInterface) (string, error) {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to read client MSP ID: %v", err)
+	}
+	id, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return "", fmt.Errorf("failed to read client identity: %v", err)
+	}
+
+	// GetID base64-encodes "x509::<subject>::<issuer>"
+	if decoded, err := base64.StdEncoding.DecodeString(id); err == nil {
+		id = string(decoded)
+	}
+	return mspID + "/" + id, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+// assertForbidden checks that err is a FORBIDDEN ContractError
+func assertForbidden(t *testing.T, err error) {
+	t.Helper()
+	var contractErr *ContractError
+	if assert.True(t, errors.As(err, &contractErr), "expected a ContractError, got %v", err) {
+		assert.Equal(t, errForbidden, contractErr.Code)
+	}
+}
+
+func TestGetCaller(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(100000), Status: "ACTIVE"}, "1234")
+	assetTransfer := SmartContract{}
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", "", ""))
+	_, err := assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.EqualError(t, err, "FORBIDDEN: client has no recognised role")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", "auditor", ""))
+	_, err = assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assertForbidden(t, err)
+
+	// Only the operator's organisation may issue admins
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org2MSP", roleAdmin, ""))
+	_, err = assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.EqualError(t, err, "FORBIDDEN: the admin role is not accepted from Org2MSP")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org2MSP", roleDealer, ""))
+	_, err = assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.EqualError(t, err, "FORBIDDEN: dealer identities must carry a dealerId attribute")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org2MSP", roleDealer, "DEALER001"))
+	asset, err := assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, "DEALER001", asset.DealerID)
+}
+
+func TestDealerAccess(t *testing.T) {
+	transactionContext, chaincodeStub, state := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(100000), Status: "ACTIVE"}, "1234")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER002", MSISDN: "1234567891", Balance: usd(200000), Status: "ACTIVE"}, "5678")
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER001", MSPID: "Org1MSP", Status: "ACTIVE"})
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleDealer, "DEALER001"))
+	assetTransfer := SmartContract{}
+
+	// Dealers are confined to their own assets
+	_, err := assetTransfer.ReadAsset(transactionContext, "1234567891")
+	assert.EqualError(t, err, "FORBIDDEN: asset 1234567891 does not belong to dealer DEALER001")
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "5678"), "1234567891", "10.00", "DEBIT", "Other dealer test")
+	assertForbidden(t, err)
+	_, err = assetTransfer.GetTransactionHistory(transactionContext, "1234567891")
+	assertForbidden(t, err)
+	err = assetTransfer.CreateAsset(withMPIN(transactionContext, "9012"), "1234567892", "DEALER002", "0.00", "USD", "ACTIVE", "Other dealer test")
+	assert.EqualError(t, err, "FORBIDDEN: dealer DEALER001 cannot create assets for dealer DEALER002")
+
+	err = assetTransfer.CreateAsset(withMPIN(transactionContext, "9012"), "1234567892", "DEALER001", "0.00", "USD", "ACTIVE", "Own dealer test")
+	assert.Nil(t, err)
+	assets, err := assetTransfer.GetAllAssets(transactionContext, false)
+	assert.Nil(t, err)
+	assert.Len(t, assets, 2)
+	for _, asset := range assets {
+		assert.Equal(t, "DEALER001", asset.DealerID)
+	}
+
+	// A dealer can pay out to any asset, but not from someone else's
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567891", "10.00", "Payout test")
+	assert.Nil(t, err)
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "5678"), "1234567891", "1234567890", "10.00", "Payout test")
+	assertForbidden(t, err)
+
+	// Only admins change status
+	err = assetTransfer.UpdateAssetStatus(transactionContext, "1234567890", "SUSPENDED", "Dealer status test")
+	assert.EqualError(t, err, "FORBIDDEN: the dealer role may not perform this operation")
+	err = assetTransfer.DeleteAsset(transactionContext, "1234567890", "", "Dealer delete test")
+	assertForbidden(t, err)
+
+	// Queries are pinned to the dealer's own assets
+	var query string
+	chaincodeStub.GetQueryResultWithPaginationStub = func(q string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+		query = q
+		return newStateIterator(state, nil), &peer.QueryResponseMetadata{}, nil
+	}
+	_, err = assetTransfer.QueryAssets(transactionContext, `{"status":"ACTIVE"}`, 10, "")
+	assert.Nil(t, err)
+	var parsed map[string]map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(query), &parsed))
+	assert.Equal(t, "DEALER001", parsed["selector"]["dealerId"])
+	_, err = assetTransfer.QueryAssets(transactionContext, `{"dealerId":"DEALER002"}`, 10, "")
+	assertForbidden(t, err)
+}
+
+func TestAgentAccess(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER002", MSISDN: "1234567891", Balance: usd(200000), Status: "ACTIVE"}, "5678")
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org2MSP", roleAgent, ""))
+	assetTransfer := SmartContract{}
+
+	// Agents serve subscribers of every dealer
+	transaction, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "5678"), "1234567891", "10.00", "DEBIT", "Agent cash-out test")
+	assert.Nil(t, err)
+	assert.Equal(t, "DEBIT", transaction.TransType)
+
+	err = assetTransfer.CreateAsset(withMPIN(transactionContext, "9012"), "1234567892", "DEALER002", "0.00", "USD", "ACTIVE", "Agent create test")
+	assertForbidden(t, err)
+	err = assetTransfer.UnlockAsset(transactionContext, "1234567891", "Agent unlock test")
+	assertForbidden(t, err)
+	assertForbidden(t, assetTransfer.InitLedger(transactionContext))
+	assertForbidden(t, assetTransfer.SetMPINMaxAttempts(transactionContext, 5))
+	_, err = assetTransfer.MigrateTransactions(transactionContext)
+	assertForbidden(t, err)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// KYC tiers, from unverified to fully verified. New assets start at TIER0.
+// The tier names the asset's limit profile, which caps its limits and
+// maximum balance, and decides whether it may send transfers.
+const (
+	kycTier0 = "TIER0"
+	kycTier1 = "TIER1"
+	kycTier2 = "TIER2"
+)
+
+// kycTierRanks orders the tiers; an upgrade moves to a higher rank
+var kycTierRanks = map[string]int{
+	kycTier0: 0,
+	kycTier1: 1,
+	kycTier2: 2,
+}
+
+// UpgradeKYC raises the KYC tier of an asset after the subscriber has been
+// verified. evidenceHash is the hash of the verification documents; it is
+// recorded on a KYC_CHANGE transaction together with the verifying identity.
+func (s *SmartContract) UpgradeKYC(ctx contractapi.TransactionContextInterface, msisdn, tier, evidenceHash, remarks string) error {
+	return s.changeKYCTier(ctx, msisdn, tier, evidenceHash, remarks, true)
+}
+
+// DowngradeKYC lowers the KYC tier of an asset, for example when its
+// verification documents have expired. It is recorded like UpgradeKYC.
+func (s *SmartContract) DowngradeKYC(ctx contractapi.TransactionContextInterface, msisdn, tier, evidenceHash, remarks string) error {
+	return s.changeKYCTier(ctx, msisdn, tier, evidenceHash, remarks, false)
+}
+
+// changeKYCTier moves an asset to tier, which must be above its current tier
+// when upgrade is set and below it otherwise. Assets onboarded before tiers
+// were introduced have none and may be given any tier.
+func (s *SmartContract) changeKYCTier(ctx contractapi.TransactionContextInterface, msisdn, tier, evidenceHash, remarks string, upgrade bool) error {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return err
+	}
+	newRank, ok := kycTierRanks[tier]
+	if !ok {
+		return newContractError(errInvalidStatus, "unknown KYC tier %q", tier)
+	}
+	if evidenceHash == "" {
+		return newContractError(errInvalidArgument, "an evidence hash is required to change the KYC tier of account %s", msisdn)
+	}
+
+	asset, err := s.readAsset(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	if asset.Status == statusClosed {
+		return newContractError(errInvalidStatus, "account %s is closed", msisdn)
+	}
+	if rank, ok := kycTierRanks[asset.KYCTier]; ok {
+		if upgrade && newRank <= rank {
+			return newContractError(errInvalidTransition, "cannot upgrade account %s from %s to %s", msisdn, asset.KYCTier, tier)
+		}
+		if !upgrade && newRank >= rank {
+			return newContractError(errInvalidTransition, "cannot downgrade account %s from %s to %s", msisdn, asset.KYCTier, tier)
+		}
+	}
+
+	actor, err := invokerID(ctx)
+	if err != nil {
+		return err
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	prevTier := asset.KYCTier
+	asset.KYCTier = tier
+	asset.TransAmount = Money{Currency: asset.Balance.Currency}
+	asset.TransType = "KYC_CHANGE"
+	asset.Remarks = remarks
+	asset.UpdatedAt = now
+	if err := s.putAsset(ctx, asset); err != nil {
+		return err
+	}
+
+	transaction := Transaction{
+		ID:           transactionID(ctx, msisdn, "KYC_CHANGE"),
+		AssetID:      msisdn,
+		TransType:    "KYC_CHANGE",
+		Amount:       Money{Currency: asset.Balance.Currency},
+		PrevBalance:  asset.Balance,
+		NewBalance:   asset.Balance,
+		Remarks:      remarks,
+		Timestamp:    now,
+		TxID:         ctx.GetStub().GetTxID(),
+		Actor:        actor,
+		PrevKYCTier:  prevTier,
+		NewKYCTier:   tier,
+		EvidenceHash: evidenceHash,
+	}
+	if err := s.recordTransaction(ctx, transaction); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, eventKYCChanged, transaction)
+}
+
+// checkCanTransfer refuses transfers from assets whose tier does not allow
+// them. Unverified TIER0 subscribers can only be paid and cash out.
+func checkCanTransfer(asset *Asset) error {
+	if asset.KYCTier == kycTier0 {
+		return newContractError(errForbidden, "account %s is %s and cannot send transfers", asset.MSISDN, asset.KYCTier)
+	}
+	return nil
+}
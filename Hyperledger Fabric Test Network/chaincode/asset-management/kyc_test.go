@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangeKYCTier(t *testing.T) {
+	transactionContext, chaincodeStub, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", KYCTier: "TIER0", MSISDN: "1234567890", Balance: usd(10000), Status: "ACTIVE"}, "1234")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567891", Balance: usd(0), Status: "ACTIVE"}, "5678")
+	assetTransfer := SmartContract{}
+
+	err := assetTransfer.UpgradeKYC(transactionContext, "1234567890", "TIER9", "9f86d081", "Unknown")
+	assert.EqualError(t, err, `INVALID_STATUS: unknown KYC tier "TIER9"`)
+	err = assetTransfer.UpgradeKYC(transactionContext, "1234567890", "TIER2", "", "No evidence")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: an evidence hash is required to change the KYC tier of account 1234567890")
+	err = assetTransfer.DowngradeKYC(transactionContext, "1234567890", "TIER1", "9f86d081", "Not lower")
+	assert.EqualError(t, err, "INVALID_TRANSITION: cannot downgrade account 1234567890 from TIER0 to TIER1")
+
+	chaincodeStub.GetTxIDReturns("txid1")
+	assert.Nil(t, assetTransfer.UpgradeKYC(transactionContext, "1234567890", "TIER2", "9f86d081", "Full KYC"))
+	asset, err := assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, "TIER2", asset.KYCTier)
+	assert.Equal(t, "KYC_CHANGE", asset.TransType)
+
+	transaction, err := assetTransfer.readTransaction(transactionContext, "1234567890-KYC_CHANGE-txid1")
+	assert.Nil(t, err)
+	assert.Equal(t, "TIER0", transaction.PrevKYCTier)
+	assert.Equal(t, "TIER2", transaction.NewKYCTier)
+	assert.Equal(t, "9f86d081", transaction.EvidenceHash)
+	assert.Equal(t, "Org1MSP/"+testClientID, transaction.Actor)
+
+	err = assetTransfer.UpgradeKYC(transactionContext, "1234567890", "TIER1", "9f86d081", "Not higher")
+	assert.EqualError(t, err, "INVALID_TRANSITION: cannot upgrade account 1234567890 from TIER2 to TIER1")
+	chaincodeStub.GetTxIDReturns("txid2")
+	assert.Nil(t, assetTransfer.DowngradeKYC(transactionContext, "1234567890", "TIER1", "e3b0c442", "ID expired"))
+
+	// Assets onboarded before tiers may be given any tier
+	assert.Nil(t, assetTransfer.DowngradeKYC(transactionContext, "1234567891", "TIER0", "e3b0c442", "Unverified"))
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleDealer, "DEALER001"))
+	err = assetTransfer.UpgradeKYC(transactionContext, "1234567890", "TIER2", "9f86d081", "Dealer")
+	assertForbidden(t, err)
+}
+
+func TestKYCTierCapabilities(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", KYCTier: "TIER0", MSISDN: "1234567890", Balance: usd(12000), Status: "ACTIVE"}, "1234")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", KYCTier: "TIER1", MSISDN: "1234567891", Balance: usd(10000), Status: "ACTIVE"}, "5678")
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER001", MSPID: "Org1MSP", Status: "ACTIVE", FloatBalance: usd(100000)})
+	assetTransfer := SmartContract{}
+	assert.Nil(t, assetTransfer.SetLimitProfile(transactionContext, "TIER0", "USD", "100.00", "0", "0", "150.00"))
+	assert.Nil(t, assetTransfer.SetLimitProfile(transactionContext, "TIER1", "USD", "0", "0", "0", "150.00"))
+
+	// TIER0 subscribers can cash out within their tier's limits but not send
+	_, err := assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567891", "10.00", "Unverified")
+	assert.EqualError(t, err, "FORBIDDEN: account 1234567890 is TIER0 and cannot send transfers")
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "110.00", "DEBIT", "Over the tier limit")
+	assert.EqualError(t, err, "LIMIT_EXCEEDED: 110.00 exceeds the per-transaction limit of 100.00 for account 1234567890; remaining allowance: 100.00")
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "40.00", "DEBIT", "Cash out")
+	assert.Nil(t, err)
+
+	// Receiving is capped by the tier's maximum balance
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "5678"), "1234567891", "1234567890", "80.00", "Over the maximum balance")
+	assert.EqualError(t, err, "LIMIT_EXCEEDED: 80.00 would take account 1234567890 above the maximum balance of 150.00; current balance: 80.00")
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "5678"), "1234567891", "1234567890", "70.00", "Transfer")
+	assert.Nil(t, err)
+	_, err = assetTransfer.DistributeFloat(transactionContext, "DEALER001", "1234567891", "130.00", "Over the maximum balance")
+	assert.EqualError(t, err, "LIMIT_EXCEEDED: 130.00 would take account 1234567891 above the maximum balance of 150.00; current balance: 30.00")
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// LimitProfile caps how much value may move through an asset per transaction,
+// per calendar day and per calendar month, and how much it may hold, in one
+// currency. A zero cap means no limit. Profiles are identified by the class of
+// asset they apply to: its KYC tier, or its status for assets onboarded before
+// tiers were introduced.
+type LimitProfile struct {
+	Currency       string    `json:"currency"`
+	Daily          Money     `json:"daily"`
+	DocType        string    `json:"docType"`
+	ID             string    `json:"id"`
+	MaxBalance     Money     `json:"maxBalance"`
+	Monthly        Money     `json:"monthly"`
+	PerTransaction Money     `json:"perTransaction"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+const (
+	limitProfileDocType = "limitProfile"
+	// limitProfileObjectType keys profiles by ID and currency
+	limitProfileObjectType = "limits"
+	// limitUsageObjectType keys the value moved through an asset by MSISDN and
+	// window, a transaction-timestamp day ("2006-01-02") or month ("2006-01")
+	limitUsageObjectType = "usage"
+)
+
+// SetLimitProfile creates or replaces the limits of profile id in currency.
+// The caps are decimal strings; "0" removes a cap.
+func (s *SmartContract) SetLimitProfile(ctx contractapi.TransactionContextInterface, id, currency, perTransaction, daily, monthly, maxBalance string) error {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return err
+	}
+	if id == "" {
+		return newContractError(errInvalidArgument, "a limit profile ID is required")
+	}
+
+	profile := LimitProfile{Currency: currency, ID: id}
+	var err error
+	if profile.PerTransaction, err = ParseMoney(perTransaction, currency); err != nil {
+		return err
+	}
+	if profile.Daily, err = ParseMoney(daily, currency); err != nil {
+		return err
+	}
+	if profile.Monthly, err = ParseMoney(monthly, currency); err != nil {
+		return err
+	}
+	if profile.MaxBalance, err = ParseMoney(maxBalance, currency); err != nil {
+		return err
+	}
+	if profile.UpdatedAt, err = txTimestamp(ctx); err != nil {
+		return err
+	}
+	return s.putLimitProfile(ctx, &profile)
+}
+
+// GetLimitProfile returns the limits of profile id in currency
+func (s *SmartContract) GetLimitProfile(ctx contractapi.TransactionContextInterface, id, currency string) (*LimitProfile, error) {
+	if _, err := authorize(ctx, roleAdmin, roleDealer, roleAgent); err != nil {
+		return nil, err
+	}
+
+	profile, err := s.getLimitProfile(ctx, id, currency)
+	if err != nil {
+		return nil, err
+	}
+	if profile == nil {
+		return nil, fmt.Errorf("no %s limit profile %s", currency, id)
+	}
+	return profile, nil
+}
+
+// useLimits checks that value may move through asset under the limit profile
+// of its class and adds it to the asset's usage of the current day and month.
+// It fails with LIMIT_EXCEEDED, naming the remaining allowance, when it would
+// break a cap. Assets without a profile are not limited. Like adjustIssued it
+// must be called at most once per asset in a transaction.
+func (s *SmartContract) useLimits(ctx contractapi.TransactionContextInterface, asset *Asset, value Money, now time.Time) error {
+	profile, err := s.getLimitProfile(ctx, limitProfileID(asset), value.Currency)
+	if err != nil || profile == nil {
+		return err
+	}
+
+	day, month := now.UTC().Format("2006-01-02"), now.UTC().Format("2006-01")
+	dailyUsed, err := s.getLimitUsage(ctx, asset.MSISDN, day, value.Currency)
+	if err != nil {
+		return err
+	}
+	monthlyUsed, err := s.getLimitUsage(ctx, asset.MSISDN, month, value.Currency)
+	if err != nil {
+		return err
+	}
+
+	// The tightest cap decides the remaining allowance
+	caps := []struct {
+		name      string
+		limit     Money
+		used      Money
+		remaining Money
+	}{
+		{name: "per-transaction", limit: profile.PerTransaction, used: Money{Currency: value.Currency}},
+		{name: "daily", limit: profile.Daily, used: dailyUsed},
+		{name: "monthly", limit: profile.Monthly, used: monthlyUsed},
+	}
+	binding := -1
+	for i := range caps {
+		if !caps[i].limit.IsPositive() {
+			continue
+		}
+		if caps[i].remaining, err = caps[i].limit.Sub(caps[i].used); err != nil {
+			return err
+		}
+		if caps[i].remaining.Amount < 0 {
+			caps[i].remaining.Amount = 0
+		}
+		if binding < 0 || caps[i].remaining.Amount < caps[binding].remaining.Amount {
+			binding = i
+		}
+	}
+	if binding >= 0 && caps[binding].remaining.Amount < value.Amount {
+		return newContractError(errLimitExceeded, "%s exceeds the %s limit of %s for account %s; remaining allowance: %s",
+			value, caps[binding].name, caps[binding].limit, asset.MSISDN, caps[binding].remaining)
+	}
+
+	if dailyUsed, err = dailyUsed.Add(value); err != nil {
+		return err
+	}
+	if monthlyUsed, err = monthlyUsed.Add(value); err != nil {
+		return err
+	}
+	if err := s.putLimitUsage(ctx, asset.MSISDN, day, dailyUsed); err != nil {
+		return err
+	}
+	return s.putLimitUsage(ctx, asset.MSISDN, month, monthlyUsed)
+}
+
+// checkMaxBalance fails with LIMIT_EXCEEDED when receiving value would take
+// asset above the maximum balance of its limit profile
+func (s *SmartContract) checkMaxBalance(ctx contractapi.TransactionContextInterface, asset *Asset, value Money) error {
+	profile, err := s.getLimitProfile(ctx, limitProfileID(asset), value.Currency)
+	if err != nil || profile == nil || !profile.MaxBalance.IsPositive() {
+		return err
+	}
+
+	balance, err := asset.Balance.Add(value)
+	if err != nil {
+		return err
+	}
+	over, err := profile.MaxBalance.LessThan(balance)
+	if err != nil {
+		return err
+	}
+	if over {
+		return newContractError(errLimitExceeded, "%s would take account %s above the maximum balance of %s; current balance: %s",
+			value, asset.MSISDN, profile.MaxBalance, asset.Balance)
+	}
+	return nil
+}
+
+// limitProfileID returns the ID of the limit profile that applies to asset
+func limitProfileID(asset *Asset) string {
+	if asset.KYCTier == "" {
+		return asset.Status
+	}
+	return asset.KYCTier
+}
+
+// defaultLimitProfiles returns the limits InitLedger gives each KYC tier in
+// the default currency, from the tight caps of an unverified TIER0 asset to
+// the generous ones of a fully verified TIER2 asset
+func defaultLimitProfiles(now time.Time) []LimitProfile {
+	caps := []struct {
+		id                                         string
+		perTransaction, daily, monthly, maxBalance int64
+	}{
+		{id: kycTier0, perTransaction: 10000, daily: 20000, monthly: 100000, maxBalance: 50000},
+		{id: kycTier1, perTransaction: 200000, daily: 500000, monthly: 2000000, maxBalance: 1000000},
+		{id: kycTier2, perTransaction: 1000000, daily: 2500000, monthly: 10000000, maxBalance: 5000000},
+	}
+
+	profiles := make([]LimitProfile, len(caps))
+	for i, c := range caps {
+		profiles[i] = LimitProfile{
+			Currency:       defaultCurrency,
+			Daily:          Money{c.daily, defaultCurrency},
+			ID:             c.id,
+			MaxBalance:     Money{c.maxBalance, defaultCurrency},
+			Monthly:        Money{c.monthly, defaultCurrency},
+			PerTransaction: Money{c.perTransaction, defaultCurrency},
+			UpdatedAt:      now,
+		}
+	}
+	return profiles
+}
+
+// putLimitProfile writes a limit profile under its ID and currency
+func (s *SmartContract) putLimitProfile(ctx contractapi.TransactionContextInterface, profile *LimitProfile) error {
+	profile.DocType = limitProfileDocType
+	profileJSON, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(limitProfileObjectType, []string{profile.ID, profile.Currency})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, profileJSON)
+}
+
+// getLimitProfile loads a limit profile, returning nil if it does not exist
+func (s *SmartContract) getLimitProfile(ctx contractapi.TransactionContextInterface, id, currency string) (*LimitProfile, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(limitProfileObjectType, []string{id, currency})
+	if err != nil {
+		return nil, err
+	}
+	profileJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if profileJSON == nil {
+		return nil, nil
+	}
+
+	var profile LimitProfile
+	if err := json.Unmarshal(profileJSON, &profile); err != nil {
+		return nil, fmt.Errorf("failed to read limit profile %s: %v", id, err)
+	}
+	return &profile, nil
+}
+
+// getLimitUsage returns the value moved through an asset in a window
+func (s *SmartContract) getLimitUsage(ctx contractapi.TransactionContextInterface, msisdn, window, currency string) (Money, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(limitUsageObjectType, []string{msisdn, window})
+	if err != nil {
+		return Money{}, err
+	}
+	usageJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return Money{}, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if usageJSON == nil {
+		return Money{Currency: currency}, nil
+	}
+
+	var used Money
+	if err := json.Unmarshal(usageJSON, &used); err != nil {
+		return Money{}, fmt.Errorf("failed to read usage of %s: %v", msisdn, err)
+	}
+	return used, nil
+}
+
+// putLimitUsage writes the value moved through an asset in a window
+func (s *SmartContract) putLimitUsage(ctx contractapi.TransactionContextInterface, msisdn, window string, used Money) error {
+	key, err := ctx.GetStub().CreateCompositeKey(limitUsageObjectType, []string{msisdn, window})
+	if err != nil {
+		return err
+	}
+	usageJSON, err := json.Marshal(used)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, usageJSON)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestLimitProfiles(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	assetTransfer := SmartContract{}
+
+	assert.Nil(t, assetTransfer.SetLimitProfile(transactionContext, "ACTIVE", "USD", "100.00", "150.00", "0", "0"))
+	profile, err := assetTransfer.GetLimitProfile(transactionContext, "ACTIVE", "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, "limitProfile", profile.DocType)
+	assert.Equal(t, usd(10000), profile.PerTransaction)
+	assert.Equal(t, usd(15000), profile.Daily)
+	assert.Equal(t, usd(0), profile.Monthly)
+
+	_, err = assetTransfer.GetLimitProfile(transactionContext, "ACTIVE", "KES")
+	assert.EqualError(t, err, "no KES limit profile ACTIVE")
+	err = assetTransfer.SetLimitProfile(transactionContext, "ACTIVE", "USD", "-1.00", "0", "0", "0")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: invalid amount "-1.00"`)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleAgent, ""))
+	err = assetTransfer.SetLimitProfile(transactionContext, "ACTIVE", "USD", "0", "0", "0", "0")
+	assertForbidden(t, err)
+}
+
+func TestDefaultLimitProfiles(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	assetTransfer := SmartContract{}
+	assert.Nil(t, assetTransfer.InitLedger(transactionContext))
+
+	tier0, err := assetTransfer.GetLimitProfile(transactionContext, "TIER0", "USD")
+	assert.Nil(t, err)
+	tier1, err := assetTransfer.GetLimitProfile(transactionContext, "TIER1", "USD")
+	assert.Nil(t, err)
+	tier2, err := assetTransfer.GetLimitProfile(transactionContext, "TIER2", "USD")
+	assert.Nil(t, err)
+	for _, caps := range [][3]Money{
+		{tier0.PerTransaction, tier1.PerTransaction, tier2.PerTransaction},
+		{tier0.Daily, tier1.Daily, tier2.Daily},
+		{tier0.Monthly, tier1.Monthly, tier2.Monthly},
+		{tier0.MaxBalance, tier1.MaxBalance, tier2.MaxBalance},
+	} {
+		assert.True(t, caps[0].IsPositive())
+		assert.Less(t, caps[0].Amount, caps[1].Amount)
+		assert.Less(t, caps[1].Amount, caps[2].Amount)
+	}
+
+	// The same debit is refused or allowed depending on the asset's tier
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567893", Balance: usd(50000), KYCTier: "TIER0", Status: "ACTIVE"}, "1111")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567894", Balance: usd(50000), KYCTier: "TIER1", Status: "ACTIVE"}, "2222")
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1111"), "1234567893", "150.00", "DEBIT", "Debit")
+	assert.EqualError(t, err, "LIMIT_EXCEEDED: 150.00 exceeds the per-transaction limit of 100.00 for account 1234567893; remaining allowance: 100.00")
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "2222"), "1234567894", "150.00", "DEBIT", "Debit")
+	assert.Nil(t, err)
+
+	// Receiving is capped by the maximum balance of the tier
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "2222"), "1234567894", "1234567893", "1.00", "Transfer")
+	assert.EqualError(t, err, "LIMIT_EXCEEDED: 1.00 would take account 1234567893 above the maximum balance of 500.00; current balance: 500.00")
+}
+
+func TestLimitsAreEnforced(t *testing.T) {
+	transactionContext, chaincodeStub, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(100000), Status: "ACTIVE"}, "1234")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567891", Balance: usd(0), Status: "ACTIVE"}, "5678")
+	assetTransfer := SmartContract{}
+	assert.Nil(t, assetTransfer.SetLimitProfile(transactionContext, "ACTIVE", "USD", "100.00", "150.00", "200.00", "0"))
+
+	_, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "100.01", "DEBIT", "Over the per-transaction limit")
+	var contractErr *ContractError
+	assert.True(t, errors.As(err, &contractErr))
+	assert.Equal(t, errLimitExceeded, contractErr.Code)
+	assert.EqualError(t, err, "LIMIT_EXCEEDED: 100.01 exceeds the per-transaction limit of 100.00 for account 1234567890; remaining allowance: 100.00")
+
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "100.00", "DEBIT", "Debit")
+	assert.Nil(t, err)
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567891", "60.00", "Over the daily limit")
+	assert.EqualError(t, err, "LIMIT_EXCEEDED: 60.00 exceeds the daily limit of 150.00 for account 1234567890; remaining allowance: 50.00")
+
+	// Transfers count against the receiver as well
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567891", "50.00", "Transfer")
+	assert.Nil(t, err)
+	used, err := assetTransfer.getLimitUsage(transactionContext, "1234567891", testTxTimestamp.Format("2006-01-02"), "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(5000), used)
+
+	// A new day resets the daily window but not the monthly one
+	chaincodeStub.GetTxTimestampReturns(timestamppb.New(testTxTimestamp.Add(24*time.Hour)), nil)
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "60.00", "DEBIT", "Over the monthly limit")
+	assert.EqualError(t, err, "LIMIT_EXCEEDED: 60.00 exceeds the monthly limit of 200.00 for account 1234567890; remaining allowance: 50.00")
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "50.00", "DEBIT", "Debit")
+	assert.Nil(t, err)
+
+	// Zero caps lift the limits
+	assert.Nil(t, assetTransfer.SetLimitProfile(transactionContext, "ACTIVE", "USD", "0", "0", "0", "0"))
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "500.00", "DEBIT", "Unlimited")
+	assert.Nil(t, err)
+}
+
+func TestLimitExemptions(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER001", MSPID: "Org1MSP", Status: "ACTIVE"})
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(40000), KYCTier: "TIER1", Status: "ACTIVE"}, "1234")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567891", Balance: usd(30000), KYCTier: "TIER2", Status: "ACTIVE"}, "5678")
+	assetTransfer := SmartContract{}
+	assert.Nil(t, assetTransfer.SetLimitProfile(transactionContext, "TIER1", "USD", "100.00", "100.00", "0", "0"))
+	assert.Nil(t, assetTransfer.SetLimitProfile(transactionContext, "TIER2", "USD", "100.00", "0", "0", "400.00"))
+
+	_, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "100.00", "DEBIT", "Use up the daily limit")
+	assert.Nil(t, err)
+
+	// Reclaiming float is an admin correction and ignores the asset's limits
+	_, err = assetTransfer.ReclaimFloat(transactionContext, "DEALER001", "1234567890", "150.00", "Sold in error")
+	assert.Nil(t, err)
+
+	// The settlement of a closing account counts against the receiver
+	err = assetTransfer.DeleteAsset(transactionContext, "1234567890", "1234567891", "Closing")
+	assert.EqualError(t, err, "LIMIT_EXCEEDED: 150.00 exceeds the per-transaction limit of 100.00 for account 1234567891; remaining allowance: 100.00")
+	assert.Nil(t, assetTransfer.SetLimitProfile(transactionContext, "TIER2", "USD", "0", "0", "0", "400.00"))
+	err = assetTransfer.DeleteAsset(transactionContext, "1234567890", "1234567891", "Closing")
+	assert.EqualError(t, err, "LIMIT_EXCEEDED: 150.00 would take account 1234567891 above the maximum balance of 400.00; current balance: 300.00")
+
+	// but not against the closing account, whose daily limit is used up
+	assert.Nil(t, assetTransfer.SetLimitProfile(transactionContext, "TIER2", "USD", "0", "0", "0", "500.00"))
+	assert.Nil(t, assetTransfer.DeleteAsset(transactionContext, "1234567890", "1234567891", "Closing"))
+	closed, err := assetTransfer.readAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, "CLOSED", closed.Status)
+	assert.Equal(t, usd(0), closed.Balance)
+	used, err := assetTransfer.getLimitUsage(transactionContext, "1234567890", testTxTimestamp.Format("2006-01-02"), "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(10000), used)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// defaultCurrency is used for the assets created by InitLedger
+const defaultCurrency = "USD"
+
+// currencyMinorUnits lists the supported ISO-4217 codes and how many decimal
+// places each one has
+var currencyMinorUnits = map[string]int{
+	"BHD": 3,
+	"EUR": 2,
+	"GBP": 2,
+	"GHS": 2,
+	"INR": 2,
+	"JPY": 0,
+	"KES": 2,
+	"KWD": 3,
+	"NGN": 2,
+	"TZS": 2,
+	"UGX": 0,
+	"USD": 2,
+	"ZAR": 2,
+}
+
+var decimalPattern = regexp.MustCompile(`^([0-9]+)(\.([0-9]+))?$`)
+
+// maxAmount caps amounts at 2^53-1 minor units, the largest integer clients
+// decoding JSON numbers as float64 still read exactly
+const maxAmount = 1<<53 - 1
+
+// Money is an amount of a single currency held as integer minor units
+// (e.g. cents), so balances never accumulate floating point rounding errors.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// ParseMoney parses a non-negative decimal string such as "1250.50" in the given
+// currency. It rejects signs, exponents and more decimal places than the
+// currency allows instead of rounding them away, with INVALID_ARGUMENT.
+func ParseMoney(value, currency string) (Money, error) {
+	digits, ok := currencyMinorUnits[currency]
+	if !ok {
+		return Money{}, newContractError(errInvalidArgument, "unsupported currency %q", currency)
+	}
+
+	match := decimalPattern.FindStringSubmatch(value)
+	if match == nil {
+		return Money{}, newContractError(errInvalidArgument, "invalid amount %q", value)
+	}
+	fraction := match[3]
+	if len(fraction) > digits {
+		return Money{}, newContractError(errInvalidArgument, "amount %q has more than %d decimal places for %s", value, digits, currency)
+	}
+
+	amount, err := strconv.ParseInt(match[1]+fraction+strings.Repeat("0", digits-len(fraction)), 10, 64)
+	if err != nil || amount > maxAmount {
+		return Money{}, newContractError(errInvalidArgument, "amount %q is out of range", value)
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// moneyFromFloat converts a legacy float64 amount, rounding to the nearest minor unit
+func moneyFromFloat(value float64, currency string) (Money, error) {
+	digits, ok := currencyMinorUnits[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("unsupported currency %q", currency)
+	}
+
+	minor := math.Round(value * math.Pow10(digits))
+	if minor > math.MaxInt64 || minor < math.MinInt64 {
+		return Money{}, fmt.Errorf("amount %v is out of range", value)
+	}
+
+	return Money{Amount: int64(minor), Currency: currency}, nil
+}
+
+// String formats the amount as a plain decimal, e.g. "1250.50"
+func (m Money) String() string {
+	digits := currencyMinorUnits[m.Currency]
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if digits == 0 {
+		return fmt.Sprintf("%s%d", sign, amount)
+	}
+
+	scale := int64(math.Pow10(digits))
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/scale, digits, amount%scale)
+}
+
+// IsPositive reports whether the amount is greater than zero
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// Add returns m + other. Both must be in the same currency.
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("currency mismatch: %s and %s", m.Currency, other.Currency)
+	}
+	if (other.Amount > 0 && m.Amount > math.MaxInt64-other.Amount) ||
+		(other.Amount < 0 && m.Amount < math.MinInt64-other.Amount) {
+		return Money{}, fmt.Errorf("amount overflow")
+	}
+
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns m - other. Both must be in the same currency.
+func (m Money) Sub(other Money) (Money, error) {
+	return m.Add(Money{Amount: -other.Amount, Currency: other.Currency})
+}
+
+// LessThan reports whether m is smaller than other. Amounts in different
+// currencies are never comparable.
+func (m Money) LessThan(other Money) (bool, error) {
+	if m.Currency != other.Currency {
+		return false, fmt.Errorf("currency mismatch: %s and %s", m.Currency, other.Currency)
+	}
+
+	return m.Amount < other.Amount, nil
+}
+
+// legacyAmountFields are the float64 fields that became Money
+var legacyAmountFields = []string{"amount", "balance", "newBalance", "prevBalance", "transAmount"}
+
+// MigrateBalances converts assets and transaction records written with float64
+// amounts into Money in the given currency. It returns the number of records
+// converted and is safe to run more than once.
+func (s *SmartContract) MigrateBalances(ctx contractapi.TransactionContextInterface, currency string) (int, error) {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return 0, err
+	}
+	if _, ok := currencyMinorUnits[currency]; !ok {
+		return 0, newContractError(errInvalidArgument, "unsupported currency %q", currency)
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(queryResponse.Value, &fields); err != nil {
+			return 0, err
+		}
+
+		converted, err := convertLegacyAmounts(queryResponse.Key, fields, currency)
+		if err != nil {
+			return 0, err
+		}
+		if !converted {
+			continue
+		}
+
+		// Other fields, such as a plaintext MPIN still waiting for MigrateMPINs,
+		// are carried over untouched
+		valueJSON, err := json.Marshal(fields)
+		if err != nil {
+			return 0, err
+		}
+		if err := ctx.GetStub().PutState(queryResponse.Key, valueJSON); err != nil {
+			return 0, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// convertLegacyAmounts replaces the float64 amounts in the decoded fields of
+// the record under key with Money in the given currency, and reports whether
+// there were any
+func convertLegacyAmounts(key string, fields map[string]json.RawMessage, currency string) (bool, error) {
+	converted := false
+	for _, name := range legacyAmountFields {
+		raw, ok := fields[name]
+		if !ok || len(raw) == 0 || raw[0] == '{' {
+			continue
+		}
+
+		var value float64
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return false, fmt.Errorf("failed to convert %s of %s: %v", name, key, err)
+		}
+		money, err := moneyFromFloat(value, currency)
+		if err != nil {
+			return false, err
+		}
+		if fields[name], err = json.Marshal(money); err != nil {
+			return false, err
+		}
+		converted = true
+	}
+	return converted, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMoney(t *testing.T) {
+	money, err := ParseMoney("1250.5", "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, Money{Amount: 125050, Currency: "USD"}, money)
+	assert.Equal(t, "1250.50", money.String())
+
+	money, err = ParseMoney("1500", "JPY")
+	assert.Nil(t, err)
+	assert.Equal(t, Money{Amount: 1500, Currency: "JPY"}, money)
+	assert.Equal(t, "1500", money.String())
+
+	money, err = ParseMoney("0.125", "BHD")
+	assert.Nil(t, err)
+	assert.Equal(t, "0.125", money.String())
+
+	_, err = ParseMoney("10.005", "USD")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: amount "10.005" has more than 2 decimal places for USD`)
+
+	_, err = ParseMoney("10.5", "JPY")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: amount "10.5" has more than 0 decimal places for JPY`)
+
+	for _, value := range []string{"", "-500", "+5", "1e3", "1,000.00", ".5", "5.", " 5"} {
+		_, err = ParseMoney(value, "USD")
+		assert.Error(t, err, value)
+	}
+
+	_, err = ParseMoney("99999999999999999999", "USD")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: amount "99999999999999999999" is out of range`)
+	_, err = ParseMoney("90071992547409.91", "USD")
+	assert.Nil(t, err)
+	_, err = ParseMoney("90071992547409.92", "USD")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: amount "90071992547409.92" is out of range`)
+
+	_, err = ParseMoney("10", "XXX")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: unsupported currency "XXX"`)
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	// 0.1 + 0.2 is exact in minor units
+	sum, err := usd(10).Add(usd(20))
+	assert.Nil(t, err)
+	assert.Equal(t, usd(30), sum)
+
+	difference, err := usd(10).Sub(usd(25))
+	assert.Nil(t, err)
+	assert.Equal(t, "-0.15", difference.String())
+
+	_, err = usd(10).Add(Money{Amount: 10, Currency: "EUR"})
+	assert.EqualError(t, err, "currency mismatch: USD and EUR")
+
+	_, err = usd(9223372036854775807).Add(usd(1))
+	assert.EqualError(t, err, "amount overflow")
+
+	less, err := usd(10).LessThan(usd(20))
+	assert.Nil(t, err)
+	assert.True(t, less)
+}
+
+func TestMigrateBalances(t *testing.T) {
+	transactionContext, _, state := newWorldState()
+	state["1234567890"] = []byte(`{"balance":1000.1,"dealerId":"DEALER001","mpin":"1234","msisdn":"1234567890","status":"ACTIVE","transAmount":0.3}`)
+	state["TXN_1234567890-CREDIT-1"] = []byte(`{"id":"1234567890-CREDIT-1","assetId":"1234567890","transType":"CREDIT","amount":0.1,"prevBalance":1000,"newBalance":1000.1}`)
+
+	assetTransfer := SmartContract{}
+	migrated, err := assetTransfer.MigrateBalances(transactionContext, "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, migrated)
+
+	var asset Asset
+	assert.Nil(t, json.Unmarshal(state["1234567890"], &asset))
+	assert.Equal(t, usd(100010), asset.Balance)
+	assert.Equal(t, usd(30), asset.TransAmount)
+	// The plaintext MPIN is left for MigrateMPINs to pick up
+	assert.Contains(t, string(state["1234567890"]), `"mpin":"1234"`)
+
+	var transaction Transaction
+	assert.Nil(t, json.Unmarshal(state["TXN_1234567890-CREDIT-1"], &transaction))
+	assert.Equal(t, usd(10), transaction.Amount)
+	assert.Equal(t, usd(100000), transaction.PrevBalance)
+	assert.Equal(t, usd(100010), transaction.NewBalance)
+
+	// Running it again finds nothing left to migrate
+	migrated, err = assetTransfer.MigrateBalances(transactionContext, "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, migrated)
+
+	_, err = assetTransfer.MigrateBalances(transactionContext, "usd")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: unsupported currency "usd"`)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// mpinObjectType keyed MPIN hashes in public world state before they
+	// moved to private data
+	mpinObjectType   = "mpin"
+	mpinTransientKey = "mpin"
+	configObjectType = "config"
+	// mpinCredentialCollection must match collections_config.json
+	mpinCredentialCollection = "mpinCredentials"
+
+	// defaultMPINMaxAttempts applies until SetMPINMaxAttempts has been called
+	defaultMPINMaxAttempts = 3
+	mpinMaxAttemptsSetting = "mpinMaxAttempts"
+
+	mpinAlgorithm  = "PBKDF2-SHA256"
+	mpinIterations = 100000
+	mpinKeyLength  = 32
+)
+
+// mpinCredential is the stored form of an asset's MPIN. It is kept in its own
+// private data collection, which every endorsing organisation belongs to, so
+// the PIN material is never part of the Asset returned to clients, nor of
+// public world state, and any endorsing peer can check it.
+type mpinCredential struct {
+	Algorithm  string `json:"algorithm"`
+	Hash       string `json:"hash"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`
+}
+
+// legacyMPIN picks the plaintext PIN out of assets written before MPINs were hashed
+type legacyMPIN struct {
+	MPIN   string `json:"mpin"`
+	MSISDN string `json:"msisdn"`
+}
+
+// MigrateMPINs hashes the plaintext MPIN of every asset that still carries one
+// and rewrites the asset without it. It returns the number of assets migrated
+// and is safe to run more than once.
+func (s *SmartContract) MigrateMPINs(ctx contractapi.TransactionContextInterface) (int, error) {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return 0, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var legacy legacyMPIN
+		if err := json.Unmarshal(queryResponse.Value, &legacy); err != nil {
+			return 0, err
+		}
+		if legacy.MPIN == "" || legacy.MSISDN != queryResponse.Key {
+			continue
+		}
+
+		var asset Asset
+		if err := json.Unmarshal(queryResponse.Value, &asset); err != nil {
+			return 0, err
+		}
+		if err := s.setMPIN(ctx, asset.MSISDN, legacy.MPIN); err != nil {
+			return 0, err
+		}
+		if err := s.putAsset(ctx, &asset); err != nil {
+			return 0, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// SetMPINMaxAttempts sets how many consecutive wrong MPINs an asset tolerates
+// before it is LOCKED.
+func (s *SmartContract) SetMPINMaxAttempts(ctx contractapi.TransactionContextInterface, maxAttempts int) error {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return err
+	}
+	if maxAttempts < 1 {
+		return newContractError(errInvalidArgument, "max MPIN attempts must be at least 1")
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(configObjectType, []string{mpinMaxAttemptsSetting})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, []byte(strconv.Itoa(maxAttempts)))
+}
+
+// UnlockAsset reactivates an asset that was LOCKED after too many wrong MPINs,
+// resetting its failed-attempt counter, and records the STATUS_CHANGE.
+func (s *SmartContract) UnlockAsset(ctx contractapi.TransactionContextInterface, msisdn, remarks string) error {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return err
+	}
+
+	asset, err := s.readAsset(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	if asset.Status != statusLocked {
+		return newContractError(errInvalidTransition, "account %s is not locked", msisdn)
+	}
+
+	transaction, err := s.changeStatus(ctx, asset, statusActive, remarks)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, eventStatusChanged, *transaction)
+}
+
+// checkMPIN verifies the MPIN in the transient map for asset. On success the failed-attempt counter is
+// reset on the in-memory asset and nil is returned; the caller persists it with
+// the rest of its update. On failure the counter is incremented, the asset is
+// LOCKED once the limit is reached, and the asset and an MPIN_FAILED record are
+// written, together with a STATUS_CHANGE when the asset gets locked. That
+// MPIN_FAILED record is returned so the caller can end the transaction
+// successfully: returning an error would discard the counter update.
+func (s *SmartContract) checkMPIN(ctx contractapi.TransactionContextInterface, asset *Asset) (*Transaction, error) {
+	if asset.Status == statusLocked {
+		return nil, newContractError(errInvalidStatus, "account %s is locked after too many invalid MPIN attempts", asset.MSISDN)
+	}
+
+	mpin, err := transientMPIN(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := s.mpinMatches(ctx, asset.MSISDN, mpin)
+	if err != nil {
+		return nil, err
+	}
+	if matches {
+		asset.FailedMPINAttempts = 0
+		return nil, nil
+	}
+
+	maxAttempts, err := s.getMPINMaxAttempts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	remarks := fmt.Sprintf("invalid MPIN for asset %s", asset.MSISDN)
+	asset.FailedMPINAttempts++
+	var lock *Transaction
+	if asset.FailedMPINAttempts >= maxAttempts && canTransition(asset.Status, statusLocked) {
+		remarks += ", account locked"
+		lock, err = s.changeStatus(ctx, asset, statusLocked, remarks)
+	} else {
+		asset.UpdatedAt = now
+		err = s.putAsset(ctx, asset)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	transaction := Transaction{
+		ID:          transactionID(ctx, asset.MSISDN, "MPIN_FAILED"),
+		AssetID:     asset.MSISDN,
+		TransType:   "MPIN_FAILED",
+		Amount:      Money{Currency: asset.Balance.Currency},
+		PrevBalance: asset.Balance,
+		NewBalance:  asset.Balance,
+		Remarks:     remarks,
+		Timestamp:   now,
+		TxID:        ctx.GetStub().GetTxID(),
+	}
+
+	err = s.recordTransaction(ctx, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	if lock != nil {
+		err = emitEvent(ctx, eventStatusChanged, *lock)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &transaction, nil
+}
+
+// transientMPIN returns the MPIN passed under the "mpin" key of the proposal's
+// transient map. Unlike arguments, transient data is not kept in the block.
+func transientMPIN(ctx contractapi.TransactionContextInterface) (string, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the transient map: %v", err)
+	}
+	mpin, ok := transient[mpinTransientKey]
+	if !ok || len(mpin) == 0 {
+		return "", newContractError(errInvalidArgument, "the MPIN must be passed in the transient map under %q", mpinTransientKey)
+	}
+	return string(mpin), nil
+}
+
+// getMPINMaxAttempts returns the configured lockout threshold
+func (s *SmartContract) getMPINMaxAttempts(ctx contractapi.TransactionContextInterface) (int, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(configObjectType, []string{mpinMaxAttemptsSetting})
+	if err != nil {
+		return 0, err
+	}
+	value, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if value == nil {
+		return defaultMPINMaxAttempts, nil
+	}
+
+	return strconv.Atoi(string(value))
+}
+
+// setMPIN stores a salted hash of mpin as the private MPIN credential of the
+// given asset. The salt is derived from the transaction ID so that every endorsing
+// peer computes the same value.
+func (s *SmartContract) setMPIN(ctx contractapi.TransactionContextInterface, msisdn, mpin string) error {
+	if mpin == "" {
+		return newContractError(errInvalidArgument, "MPIN must not be empty")
+	}
+
+	salt := sha256.Sum256([]byte(ctx.GetStub().GetTxID() + "/" + msisdn))
+	credential := mpinCredential{
+		Algorithm:  mpinAlgorithm,
+		Hash:       base64.StdEncoding.EncodeToString(pbkdf2.Key([]byte(mpin), salt[:], mpinIterations, mpinKeyLength, sha256.New)),
+		Iterations: mpinIterations,
+		Salt:       base64.StdEncoding.EncodeToString(salt[:]),
+	}
+	return s.putMPINCredential(ctx, msisdn, &credential)
+}
+
+// mpinMatches reports whether mpin matches the stored hash for the given asset
+func (s *SmartContract) mpinMatches(ctx contractapi.TransactionContextInterface, msisdn, mpin string) (bool, error) {
+	credential, err := s.getMPINCredential(ctx, msisdn)
+	if err != nil {
+		return false, err
+	}
+	if credential == nil {
+		return false, nil
+	}
+
+	if credential.Algorithm != mpinAlgorithm {
+		return false, fmt.Errorf("unsupported MPIN algorithm %s for asset %s", credential.Algorithm, msisdn)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(credential.Salt)
+	if err != nil {
+		return false, err
+	}
+	expected, err := base64.StdEncoding.DecodeString(credential.Hash)
+	if err != nil {
+		return false, err
+	}
+
+	actual := pbkdf2.Key([]byte(mpin), salt, credential.Iterations, len(expected), sha256.New)
+	return subtle.ConstantTimeCompare(actual, expected) == 1, nil
+}
+
+// getMPINCredential loads the MPIN credential of an asset, returning nil if it
+// has none
+func (s *SmartContract) getMPINCredential(ctx contractapi.TransactionContextInterface, msisdn string) (*mpinCredential, error) {
+	credentialJSON, err := ctx.GetStub().GetPrivateData(mpinCredentialCollection, msisdn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from private data collection: %v", err)
+	}
+	if credentialJSON == nil {
+		return nil, nil
+	}
+
+	var credential mpinCredential
+	if err := json.Unmarshal(credentialJSON, &credential); err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// putMPINCredential writes the MPIN credential of an asset
+func (s *SmartContract) putMPINCredential(ctx contractapi.TransactionContextInterface, msisdn string, credential *mpinCredential) error {
+	credentialJSON, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutPrivateData(mpinCredentialCollection, msisdn, credentialJSON)
+}
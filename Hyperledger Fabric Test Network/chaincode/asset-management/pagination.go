@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// AssetsPage is one page of assets. FetchedCount is the number of ledger
+// records the page covered, which can exceed len(Records) when some of them
+// were not assets. Bookmark is passed back to fetch the next page and is empty
+// after the last one.
+type AssetsPage struct {
+	Records      []*Asset `json:"records"`
+	FetchedCount int32    `json:"fetchedCount"`
+	Bookmark     string   `json:"bookmark"`
+}
+
+// TransactionsPage is one page of an asset's transaction history
+type TransactionsPage struct {
+	Records      []*Transaction `json:"records"`
+	FetchedCount int32          `json:"fetchedCount"`
+	Bookmark     string         `json:"bookmark"`
+}
+
+// GetAssetsWithPagination returns up to pageSize assets starting at bookmark.
+// An empty bookmark starts from the first asset. CLOSED assets are left out
+// unless includeClosed is set, and dealers only see their own assets.
+func (s *SmartContract) GetAssetsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string, includeClosed bool) (*AssetsPage, error) {
+	c, err := authorize(ctx, roleAdmin, roleDealer, roleAgent)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize < 1 {
+		return nil, newContractError(errInvalidArgument, "page size must be at least 1")
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination("", "", pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &AssetsPage{Records: []*Asset{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		asset, ok, err := assetFromRecord(queryResponse.Key, queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || (asset.Status == statusClosed && !includeClosed) || !c.canAccessDealer(asset.DealerID) {
+			continue
+		}
+		if err := s.excludeExpiredHolds(ctx, asset); err != nil {
+			return nil, err
+		}
+		page.Records = append(page.Records, asset)
+	}
+	if metadata != nil {
+		page.FetchedCount = metadata.FetchedRecordsCount
+		page.Bookmark = metadata.Bookmark
+	}
+
+	return page, nil
+}
+
+// GetTransactionHistoryWithPagination returns up to pageSize transactions of
+// the given asset, oldest first, starting at bookmark.
+func (s *SmartContract) GetTransactionHistoryWithPagination(ctx contractapi.TransactionContextInterface, msisdn string, pageSize int32, bookmark string) (*TransactionsPage, error) {
+	if pageSize < 1 {
+		return nil, newContractError(errInvalidArgument, "page size must be at least 1")
+	}
+	if err := s.authorizeAssetRecords(ctx, msisdn); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(transactionObjectType, []string{msisdn}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &TransactionsPage{Records: []*Transaction{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var transaction Transaction
+		if err := json.Unmarshal(queryResponse.Value, &transaction); err != nil {
+			return nil, err
+		}
+		page.Records = append(page.Records, &transaction)
+	}
+	if metadata != nil {
+		page.FetchedCount = metadata.FetchedRecordsCount
+		page.Bookmark = metadata.Bookmark
+	}
+
+	return page, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAssetsWithPagination(t *testing.T) {
+	transactionContext, _, state := newWorldState()
+	for i := 0; i < 5; i++ {
+		putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: fmt.Sprintf("123456789%d", i), Balance: usd(1000), Status: "ACTIVE"}, "1234")
+	}
+	state["TXN_1234567890-CREATE-1"] = []byte(`{"id":"1234567890-CREATE-1","assetId":"1234567890","transType":"CREATE"}`)
+
+	assetTransfer := SmartContract{}
+
+	var msisdns []string
+	bookmark := ""
+	for {
+		page, err := assetTransfer.GetAssetsWithPagination(transactionContext, 2, bookmark, false)
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, len(page.Records), int(page.FetchedCount))
+		for _, asset := range page.Records {
+			msisdns = append(msisdns, asset.MSISDN)
+		}
+		if page.Bookmark == "" {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+	assert.Equal(t, []string{"1234567890", "1234567891", "1234567892", "1234567893", "1234567894"}, msisdns)
+
+	_, err := assetTransfer.GetAssetsWithPagination(transactionContext, 0, "", false)
+	assert.EqualError(t, err, "INVALID_ARGUMENT: page size must be at least 1")
+}
+
+func TestGetTransactionHistoryWithPagination(t *testing.T) {
+	transactionContext, chaincodeStub, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(100000), Status: "ACTIVE"}, "1234")
+
+	assetTransfer := SmartContract{}
+	for i := 1; i <= 3; i++ {
+		chaincodeStub.GetTxIDReturns(fmt.Sprintf("txid%d", i))
+		_, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "1.00", "DEBIT", "Debit")
+		assert.Nil(t, err)
+	}
+
+	page, err := assetTransfer.GetTransactionHistoryWithPagination(transactionContext, "1234567890", 2, "")
+	assert.Nil(t, err)
+	assert.Len(t, page.Records, 2)
+	assert.Equal(t, int32(2), page.FetchedCount)
+	assert.NotEmpty(t, page.Bookmark)
+	assert.Equal(t, "1234567890-DEBIT-txid1", page.Records[0].ID)
+
+	page, err = assetTransfer.GetTransactionHistoryWithPagination(transactionContext, "1234567890", 2, page.Bookmark)
+	assert.Nil(t, err)
+	assert.Len(t, page.Records, 1)
+	assert.Equal(t, "1234567890-DEBIT-txid3", page.Records[0].ID)
+	assert.Empty(t, page.Bookmark)
+
+	// An asset without history yields an empty page rather than null
+	page, err = assetTransfer.GetTransactionHistoryWithPagination(transactionContext, "1234567899", 2, "")
+	assert.Nil(t, err)
+	assert.NotNil(t, page.Records)
+	assert.Empty(t, page.Records)
+}
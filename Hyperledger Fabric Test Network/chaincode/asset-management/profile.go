@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SubscriberProfile holds the personal and KYC details of an asset's
+// subscriber. It is kept in a private data collection, so only its hash is
+// written to the channel ledger.
+type SubscriberProfile struct {
+	Address        string    `json:"address"`
+	DocType        string    `json:"docType"`
+	IDDocumentHash string    `json:"idDocumentHash"`
+	MSISDN         string    `json:"msisdn"`
+	Name           string    `json:"name"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// subscriberProfileRecord is the stored form of a profile. Profiles written
+// before MPIN hashes moved to their own collection also hold the asset's MPIN
+// hash, which is never returned to clients and is moved out by
+// MigrateSubscriberProfiles.
+type subscriberProfileRecord struct {
+	SubscriberProfile
+	MPIN *mpinCredential `json:"mpin,omitempty"`
+}
+
+// profileDetails is the profile data a client passes in the transient map
+type profileDetails struct {
+	Address        string `json:"address"`
+	IDDocumentHash string `json:"idDocumentHash"`
+	Name           string `json:"name"`
+}
+
+const (
+	subscriberProfileDocType = "subscriberProfile"
+	// subscriberProfileCollection must match collections_config.json
+	subscriberProfileCollection = "subscriberProfiles"
+	profileTransientKey         = "profile"
+)
+
+// ReadSubscriberProfile returns the private profile of the subscriber of the
+// given asset. Only peers of Org1, the collection's only member, can serve it.
+func (s *SmartContract) ReadSubscriberProfile(ctx contractapi.TransactionContextInterface, msisdn string) (*SubscriberProfile, error) {
+	c, err := authorize(ctx, roleAdmin, roleDealer, roleAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	asset, err := s.readAsset(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkAsset(asset); err != nil {
+		return nil, err
+	}
+
+	record, err := s.getProfileRecord(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("the subscriber profile %s does not exist", msisdn)
+	}
+
+	return &record.SubscriberProfile, nil
+}
+
+// UpdateSubscriberProfile sets the name, ID document hash and address of the
+// subscriber of the given asset. They are passed as JSON under the "profile"
+// key of the transient map so that they are never written to the block.
+func (s *SmartContract) UpdateSubscriberProfile(ctx contractapi.TransactionContextInterface, msisdn string) error {
+	c, err := authorize(ctx, roleAdmin, roleDealer)
+	if err != nil {
+		return err
+	}
+
+	asset, err := s.readAsset(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	if err := c.checkAsset(asset); err != nil {
+		return err
+	}
+	if asset.Status == statusClosed {
+		return newContractError(errInvalidStatus, "account %s is closed", msisdn)
+	}
+
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to read the transient map: %v", err)
+	}
+	detailsJSON, ok := transient[profileTransientKey]
+	if !ok {
+		return newContractError(errInvalidArgument, "the profile must be passed in the transient map under %q", profileTransientKey)
+	}
+	var details profileDetails
+	if err := json.Unmarshal(detailsJSON, &details); err != nil {
+		return newContractError(errInvalidArgument, "failed to parse the profile: %v", err)
+	}
+	if details.Name == "" || details.IDDocumentHash == "" {
+		return newContractError(errInvalidArgument, "name and ID document hash are required")
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	record, err := s.getProfileRecord(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		record = &subscriberProfileRecord{}
+	}
+	record.Address = details.Address
+	record.IDDocumentHash = details.IDDocumentHash
+	record.MSISDN = msisdn
+	record.Name = details.Name
+	record.UpdatedAt = now
+
+	return s.putProfileRecord(ctx, record)
+}
+
+// MigrateSubscriberProfiles moves every MPIN hash still kept in a subscriber
+// profile or in public world state into the MPIN credential collection. A hash
+// never replaces a newer one: the collection's wins over a profile's, which
+// wins over world state's. It returns the number of hashes moved and is safe
+// to run more than once.
+func (s *SmartContract) MigrateSubscriberProfiles(ctx contractapi.TransactionContextInterface) (int, error) {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return 0, err
+	}
+
+	profilesIterator, err := ctx.GetStub().GetPrivateDataByRange(subscriberProfileCollection, "", "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read from private data collection: %v", err)
+	}
+	defer profilesIterator.Close()
+
+	// Writes are not visible to reads in the same transaction, so the assets
+	// given a hash here are tracked to keep world state from overwriting it
+	moved := map[string]bool{}
+	for profilesIterator.HasNext() {
+		queryResponse, err := profilesIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var record subscriberProfileRecord
+		if err := json.Unmarshal(queryResponse.Value, &record); err != nil {
+			return 0, err
+		}
+		if record.MPIN == nil {
+			continue
+		}
+
+		if err := s.moveMPINCredential(ctx, record.MSISDN, record.MPIN, moved); err != nil {
+			return 0, err
+		}
+		record.MPIN = nil
+		if err := s.putProfileRecord(ctx, &record); err != nil {
+			return 0, err
+		}
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(mpinObjectType, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return 0, err
+		}
+
+		var credential mpinCredential
+		if err := json.Unmarshal(queryResponse.Value, &credential); err != nil {
+			return 0, err
+		}
+
+		if err := s.moveMPINCredential(ctx, attributes[0], &credential, moved); err != nil {
+			return 0, err
+		}
+		if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(moved), nil
+}
+
+// moveMPINCredential stores a credential found in an older location and
+// records it in moved, unless the asset already has a credential in the
+// collection or in moved
+func (s *SmartContract) moveMPINCredential(ctx contractapi.TransactionContextInterface, msisdn string, credential *mpinCredential, moved map[string]bool) error {
+	if moved[msisdn] {
+		return nil
+	}
+	existing, err := s.getMPINCredential(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return nil
+	}
+	moved[msisdn] = true
+	return s.putMPINCredential(ctx, msisdn, credential)
+}
+
+// getProfileRecord loads the private profile record of an asset, returning nil
+// if it does not exist
+func (s *SmartContract) getProfileRecord(ctx contractapi.TransactionContextInterface, msisdn string) (*subscriberProfileRecord, error) {
+	recordJSON, err := ctx.GetStub().GetPrivateData(subscriberProfileCollection, msisdn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from private data collection: %v", err)
+	}
+	if recordJSON == nil {
+		return nil, nil
+	}
+
+	var record subscriberProfileRecord
+	if err := json.Unmarshal(recordJSON, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// putProfileRecord writes a private profile record
+func (s *SmartContract) putProfileRecord(ctx contractapi.TransactionContextInterface, record *subscriberProfileRecord) error {
+	record.DocType = subscriberProfileDocType
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutPrivateData(subscriberProfileCollection, record.MSISDN, recordJSON)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriberProfile(t *testing.T) {
+	transactionContext, chaincodeStub, state := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(10000), Status: "ACTIVE"}, "1234")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER002", MSISDN: "1234567891", Balance: usd(10000), Status: "ACTIVE"}, "5678")
+	assetTransfer := SmartContract{}
+
+	chaincodeStub.GetTransientReturns(map[string][]byte{}, nil)
+	err := assetTransfer.UpdateSubscriberProfile(transactionContext, "1234567890")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: the profile must be passed in the transient map under "profile"`)
+	chaincodeStub.GetTransientReturns(map[string][]byte{"profile": []byte(`{"name":"Jane Doe"}`)}, nil)
+	err = assetTransfer.UpdateSubscriberProfile(transactionContext, "1234567890")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: name and ID document hash are required")
+
+	chaincodeStub.GetTransientReturns(map[string][]byte{"profile": []byte(`{"name":"Jane Doe","idDocumentHash":"9f86d081","address":"1 Main Street"}`)}, nil)
+	assert.Nil(t, assetTransfer.UpdateSubscriberProfile(transactionContext, "1234567890"))
+
+	profile, err := assetTransfer.ReadSubscriberProfile(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, &SubscriberProfile{
+		Address:        "1 Main Street",
+		DocType:        "subscriberProfile",
+		IDDocumentHash: "9f86d081",
+		MSISDN:         "1234567890",
+		Name:           "Jane Doe",
+		UpdatedAt:      testTxTimestamp,
+	}, profile)
+
+	// The profile is private and updating it keeps the MPIN
+	for _, value := range state {
+		assert.NotContains(t, string(value), "Jane Doe")
+	}
+	matches, err := assetTransfer.mpinMatches(transactionContext, "1234567890", "1234")
+	assert.Nil(t, err)
+	assert.True(t, matches)
+
+	_, err = assetTransfer.ReadSubscriberProfile(transactionContext, "1234567899")
+	assert.EqualError(t, err, "the asset 1234567899 does not exist")
+
+	// Dealers only see the profiles of their own subscribers
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleDealer, "DEALER001"))
+	_, err = assetTransfer.ReadSubscriberProfile(transactionContext, "1234567891")
+	assertForbidden(t, err)
+	err = assetTransfer.UpdateSubscriberProfile(transactionContext, "1234567891")
+	assertForbidden(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleAgent, ""))
+	err = assetTransfer.UpdateSubscriberProfile(transactionContext, "1234567890")
+	assertForbidden(t, err)
+}
+
+func TestMigrateSubscriberProfiles(t *testing.T) {
+	transactionContext, chaincodeStub, state := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(10000), Status: "ACTIVE"}, "1234")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567891", Balance: usd(10000), Status: "ACTIVE"}, "0000")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567892", Balance: usd(10000), Status: "ACTIVE"}, "9999")
+	assetTransfer := SmartContract{}
+	stale, err := assetTransfer.getMPINCredential(transactionContext, "1234567891")
+	assert.Nil(t, err)
+	chaincodeStub.GetTxIDReturns("txid-reset")
+	assert.Nil(t, assetTransfer.setMPIN(transactionContext, "1234567891", "5678"))
+
+	// Move the hashes back to where they used to be kept: 1234567890's in world
+	// state, 1234567891's in its profile with an older one left in world state
+	moveToState := func(msisdn string, credential *mpinCredential) string {
+		credentialJSON, err := json.Marshal(credential)
+		assert.Nil(t, err)
+		key, err := shim.CreateCompositeKey(mpinObjectType, []string{msisdn})
+		assert.Nil(t, err)
+		state[key] = credentialJSON
+		return key
+	}
+	credential, err := assetTransfer.getMPINCredential(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	stateKey := moveToState("1234567890", credential)
+	assert.Nil(t, chaincodeStub.DelPrivateData(mpinCredentialCollection, "1234567890"))
+
+	credential, err = assetTransfer.getMPINCredential(transactionContext, "1234567891")
+	assert.Nil(t, err)
+	staleKey := moveToState("1234567891", stale)
+	assert.Nil(t, assetTransfer.putProfileRecord(transactionContext, &subscriberProfileRecord{
+		SubscriberProfile: SubscriberProfile{MSISDN: "1234567891", Name: "Jane Doe"},
+		MPIN:              credential,
+	}))
+	assert.Nil(t, chaincodeStub.DelPrivateData(mpinCredentialCollection, "1234567891"))
+
+	migrated, err := assetTransfer.MigrateSubscriberProfiles(transactionContext)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, migrated)
+	assert.NotContains(t, state, stateKey)
+	assert.NotContains(t, state, staleKey)
+	for msisdn, mpin := range map[string]string{"1234567890": "1234", "1234567891": "5678", "1234567892": "9999"} {
+		matches, err := assetTransfer.mpinMatches(transactionContext, msisdn, mpin)
+		assert.Nil(t, err)
+		assert.True(t, matches, msisdn)
+	}
+	record, err := assetTransfer.getProfileRecord(transactionContext, "1234567891")
+	assert.Nil(t, err)
+	assert.Equal(t, "Jane Doe", record.Name)
+	assert.Nil(t, record.MPIN)
+
+	// Running it again finds nothing left to migrate
+	migrated, err = assetTransfer.MigrateSubscriberProfiles(transactionContext)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, migrated)
+}
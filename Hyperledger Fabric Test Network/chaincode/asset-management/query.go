@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// AssetFilter selects assets in QueryAssets, which receives it as JSON. Empty
+// fields do not filter. MinBalance and MaxBalance are inclusive decimal amounts
+// in Currency, and UpdatedFrom and UpdatedTo are an inclusive RFC 3339 range on
+// updatedAt. CLOSED assets only match when IncludeClosed is set or Status asks
+// for them.
+type AssetFilter struct {
+	DealerID      string `json:"dealerId,omitempty"`
+	Status        string `json:"status,omitempty"`
+	Currency      string `json:"currency,omitempty"`
+	MinBalance    string `json:"minBalance,omitempty"`
+	MaxBalance    string `json:"maxBalance,omitempty"`
+	UpdatedFrom   string `json:"updatedFrom,omitempty"`
+	UpdatedTo     string `json:"updatedTo,omitempty"`
+	IncludeClosed bool   `json:"includeClosed,omitempty"`
+}
+
+// QueryAssets returns up to pageSize assets matching the AssetFilter in
+// filterJSON, starting at bookmark. It runs a CouchDB rich query, so it requires
+// CouchDB as the state database; the indexes it relies on are shipped under
+// META-INF. Dealers can only query their own assets.
+func (s *SmartContract) QueryAssets(ctx contractapi.TransactionContextInterface, filterJSON string, pageSize int32, bookmark string) (*AssetsPage, error) {
+	c, err := authorize(ctx, roleAdmin, roleDealer, roleAgent)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize < 1 {
+		return nil, newContractError(errInvalidArgument, "page size must be at least 1")
+	}
+
+	var filter AssetFilter
+	if filterJSON != "" {
+		if err := json.Unmarshal([]byte(filterJSON), &filter); err != nil {
+			return nil, fmt.Errorf("invalid asset filter: %v", err)
+		}
+	}
+
+	if c.Role == roleDealer {
+		if filter.DealerID != "" && filter.DealerID != c.DealerID {
+			return nil, newContractError(errForbidden, "dealer %s cannot query assets of dealer %s", c.DealerID, filter.DealerID)
+		}
+		filter.DealerID = c.DealerID
+	}
+
+	query, err := assetQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(query, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &AssetsPage{Records: []*Asset{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		asset, ok, err := assetFromRecord(queryResponse.Key, queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if err := s.excludeExpiredHolds(ctx, asset); err != nil {
+			return nil, err
+		}
+		page.Records = append(page.Records, asset)
+	}
+	if metadata != nil {
+		page.FetchedCount = metadata.FetchedRecordsCount
+		page.Bookmark = metadata.Bookmark
+	}
+
+	return page, nil
+}
+
+// assetQuery builds the CouchDB query for filter
+func assetQuery(filter AssetFilter) (string, error) {
+	selector := map[string]interface{}{"docType": assetDocType}
+	if filter.DealerID != "" {
+		selector["dealerId"] = filter.DealerID
+	}
+	if filter.Status != "" {
+		selector["status"] = filter.Status
+	} else if !filter.IncludeClosed {
+		selector["status"] = map[string]interface{}{"$ne": statusClosed}
+	}
+
+	if filter.MinBalance != "" || filter.MaxBalance != "" {
+		if filter.Currency == "" {
+			return "", newContractError(errInvalidArgument, "currency is required to filter by balance")
+		}
+		amount := map[string]interface{}{}
+		if filter.MinBalance != "" {
+			minBalance, err := ParseMoney(filter.MinBalance, filter.Currency)
+			if err != nil {
+				return "", err
+			}
+			amount["$gte"] = minBalance.Amount
+		}
+		if filter.MaxBalance != "" {
+			maxBalance, err := ParseMoney(filter.MaxBalance, filter.Currency)
+			if err != nil {
+				return "", err
+			}
+			amount["$lte"] = maxBalance.Amount
+		}
+		selector["balance.amount"] = amount
+	}
+	if filter.Currency != "" {
+		if _, ok := currencyMinorUnits[filter.Currency]; !ok {
+			return "", newContractError(errInvalidArgument, "unsupported currency %q", filter.Currency)
+		}
+		selector["balance.currency"] = filter.Currency
+	}
+
+	if filter.UpdatedFrom != "" || filter.UpdatedTo != "" {
+		// updatedAt is stored as a fixed width UTC string, so the bounds are
+		// compared as strings in the same form. Assets last written before the
+		// width was fixed only sort correctly to the second.
+		updatedAt := map[string]interface{}{}
+		if filter.UpdatedFrom != "" {
+			from, err := time.Parse(time.RFC3339, filter.UpdatedFrom)
+			if err != nil {
+				return "", fmt.Errorf("invalid updatedFrom %q: %v", filter.UpdatedFrom, err)
+			}
+			updatedAt["$gte"] = from.UTC().Format(assetTimeLayout)
+		}
+		if filter.UpdatedTo != "" {
+			to, err := time.Parse(time.RFC3339, filter.UpdatedTo)
+			if err != nil {
+				return "", fmt.Errorf("invalid updatedTo %q: %v", filter.UpdatedTo, err)
+			}
+			updatedAt["$lte"] = to.UTC().Format(assetTimeLayout)
+		}
+		selector["updatedAt"] = updatedAt
+	}
+
+	query, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return "", err
+	}
+	return string(query), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssetQuery(t *testing.T) {
+	query, err := assetQuery(AssetFilter{
+		DealerID:    "DEALER002",
+		Status:      "SUSPENDED",
+		Currency:    "USD",
+		MinBalance:  "10",
+		MaxBalance:  "100.50",
+		UpdatedFrom: "2024-01-15T12:30:00+02:00",
+		UpdatedTo:   "2024-01-31T00:00:00Z",
+	})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"selector":{
+		"docType":"asset",
+		"dealerId":"DEALER002",
+		"status":"SUSPENDED",
+		"balance.currency":"USD",
+		"balance.amount":{"$gte":1000,"$lte":10050},
+		"updatedAt":{"$gte":"2024-01-15T10:30:00.000000000Z","$lte":"2024-01-31T00:00:00.000000000Z"}
+	}}`, query)
+
+	// An empty filter still only matches assets, and only open ones
+	query, err = assetQuery(AssetFilter{})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"selector":{"docType":"asset","status":{"$ne":"CLOSED"}}}`, query)
+	query, err = assetQuery(AssetFilter{IncludeClosed: true})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"selector":{"docType":"asset"}}`, query)
+
+	_, err = assetQuery(AssetFilter{MaxBalance: "100"})
+	assert.EqualError(t, err, "INVALID_ARGUMENT: currency is required to filter by balance")
+	_, err = assetQuery(AssetFilter{Currency: "USD", MinBalance: "1.001"})
+	assert.EqualError(t, err, `INVALID_ARGUMENT: amount "1.001" has more than 2 decimal places for USD`)
+	_, err = assetQuery(AssetFilter{UpdatedTo: "yesterday"})
+	assert.Error(t, err)
+}
+
+func TestAssetQuerySubSecondBounds(t *testing.T) {
+	query, err := assetQuery(AssetFilter{UpdatedFrom: "2024-01-15T10:30:05.5Z", UpdatedTo: "2024-01-15T10:30:06Z", IncludeClosed: true})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"selector":{
+		"docType":"asset",
+		"updatedAt":{"$gte":"2024-01-15T10:30:05.500000000Z","$lte":"2024-01-15T10:30:06.000000000Z"}
+	}}`, query)
+
+	// CouchDB compares the stored updatedAt with the bounds as strings, so
+	// they must order the same way as the times
+	var parsed struct {
+		Selector struct {
+			UpdatedAt map[string]string `json:"updatedAt"`
+		} `json:"selector"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(query), &parsed))
+	bounds := parsed.Selector.UpdatedAt
+	updatedAt := func(at time.Time) string {
+		assetJSON, err := json.Marshal(Asset{UpdatedAt: at})
+		assert.Nil(t, err)
+		var stored map[string]interface{}
+		assert.Nil(t, json.Unmarshal(assetJSON, &stored))
+		return stored["updatedAt"].(string)
+	}
+	second := time.Date(2024, 1, 15, 10, 30, 5, 0, time.UTC)
+	assert.Equal(t, "2024-01-15T10:30:05.000000000Z", updatedAt(second))
+	assert.Less(t, updatedAt(second), bounds["$gte"])
+	assert.GreaterOrEqual(t, updatedAt(second.Add(500*time.Millisecond)), bounds["$gte"])
+	assert.LessOrEqual(t, updatedAt(second.Add(time.Second)), bounds["$lte"])
+	assert.Greater(t, updatedAt(second.Add(time.Second+time.Nanosecond)), bounds["$lte"])
+}
+
+func TestQueryAssets(t *testing.T) {
+	transactionContext, chaincodeStub, state := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER002", MSISDN: "1234567891", Balance: usd(5000), Status: "SUSPENDED"}, "5678")
+
+	var query string
+	chaincodeStub.GetQueryResultWithPaginationStub = func(q string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+		query = q
+		return newStateIterator(state, []string{"1234567891"}), &peer.QueryResponseMetadata{FetchedRecordsCount: 1, Bookmark: "next"}, nil
+	}
+
+	assetTransfer := SmartContract{}
+	page, err := assetTransfer.QueryAssets(transactionContext, `{"dealerId":"DEALER002","status":"SUSPENDED"}`, 10, "")
+	assert.Nil(t, err)
+	assert.Len(t, page.Records, 1)
+	assert.Equal(t, "1234567891", page.Records[0].MSISDN)
+	assert.Equal(t, int32(1), page.FetchedCount)
+	assert.Equal(t, "next", page.Bookmark)
+
+	var parsed map[string]map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(query), &parsed))
+	assert.Equal(t, "DEALER002", parsed["selector"]["dealerId"])
+	assert.Equal(t, "SUSPENDED", parsed["selector"]["status"])
+
+	_, err = assetTransfer.QueryAssets(transactionContext, `{"dealerId":`, 10, "")
+	assert.Error(t, err)
+}
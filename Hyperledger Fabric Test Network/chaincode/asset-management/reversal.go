@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// transactionStatusReversed marks a transaction that has been undone by
+// ReverseTransaction
+const transactionStatusReversed = "REVERSED"
+
+// reversiblePairs maps each leg of the two-asset movements ReverseTransaction
+// can undo to the type of the other leg
+var reversiblePairs = map[string]string{
+	"TRANSFER_OUT": "TRANSFER_IN",
+	"TRANSFER_IN":  "TRANSFER_OUT",
+	"FEE":          "FEE_IN",
+	"FEE_IN":       "FEE",
+}
+
+// ReverseTransaction undoes a DEBIT, a transfer or a fee by applying the
+// inverse movement. The reversal is recorded as REVERSAL on a debited asset or
+// as a linked REVERSAL_OUT/REVERSAL_IN pair, each pointing at the leg it
+// reverses, and the original legs are marked REVERSED. Either leg of a
+// transfer or fee may be named. The reversal record of the original's asset is
+// returned. Reversals are not charged fees and do not count against limits;
+// the fee of a reversed transaction is reversed separately through its FEE
+// record.
+func (s *SmartContract) ReverseTransaction(ctx contractapi.TransactionContextInterface, originalTxnId, reason string) (*Transaction, error) {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return nil, err
+	}
+	if reason == "" {
+		return nil, newContractError(errInvalidArgument, "a reason is required to reverse transaction %s", originalTxnId)
+	}
+
+	original, err := s.readTransaction(ctx, originalTxnId)
+	if err != nil {
+		return nil, err
+	}
+	if original.Status == transactionStatusReversed {
+		return nil, newContractError(errInvalidTransition, "transaction %s has already been reversed by %s", original.ID, original.ReversedBy)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	actor, err := invokerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reversal *Transaction
+	if original.TransType == "DEBIT" {
+		reversal, err = s.reverseDebit(ctx, original, reason, actor, now)
+	} else if pairType, ok := reversiblePairs[original.TransType]; ok {
+		reversal, err = s.reversePair(ctx, original, pairType, reason, actor, now)
+	} else {
+		return nil, newContractError(errInvalidArgument, "%s transactions cannot be reversed", original.TransType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := emitEvent(ctx, eventBalanceChanged, *reversal); err != nil {
+		return nil, err
+	}
+	return reversal, nil
+}
+
+// reverseDebit pays the amount of a DEBIT back into its asset. The debit
+// burnt that value, so the reversal issues it again.
+func (s *SmartContract) reverseDebit(ctx contractapi.TransactionContextInterface, original *Transaction, reason, actor string, now time.Time) (*Transaction, error) {
+	asset, err := s.reversibleAsset(ctx, original.AssetID)
+	if err != nil {
+		return nil, err
+	}
+
+	prevBalance := asset.Balance
+	if asset.Balance, err = asset.Balance.Add(original.Amount); err != nil {
+		return nil, err
+	}
+	asset.TransAmount = original.Amount
+	asset.TransType = "REVERSAL"
+	asset.Remarks = reason
+	asset.UpdatedAt = now
+	if err := s.putAsset(ctx, asset); err != nil {
+		return nil, err
+	}
+	if err := s.adjustIssued(ctx, original.Amount); err != nil {
+		return nil, err
+	}
+
+	reversal := Transaction{
+		ID:          transactionID(ctx, asset.MSISDN, "REVERSAL"),
+		AssetID:     asset.MSISDN,
+		TransType:   "REVERSAL",
+		Amount:      original.Amount,
+		PrevBalance: prevBalance,
+		NewBalance:  asset.Balance,
+		Remarks:     reason,
+		Timestamp:   now,
+		TxID:        ctx.GetStub().GetTxID(),
+		Actor:       actor,
+		ReversalOf:  original.ID,
+	}
+	if err := s.recordTransaction(ctx, reversal); err != nil {
+		return nil, err
+	}
+	if err := s.markReversed(ctx, original, reversal.ID); err != nil {
+		return nil, err
+	}
+	return &reversal, nil
+}
+
+// reversePair moves the amount of a two-asset movement back from the asset
+// that received it to the one that paid it
+func (s *SmartContract) reversePair(ctx contractapi.TransactionContextInterface, original *Transaction, pairType, reason, actor string, now time.Time) (*Transaction, error) {
+	// The other leg was written by the same Fabric transaction for the
+	// counterparty, so it is stored under the same timestamp and TxID
+	pairKey, err := transactionKey(ctx, Transaction{
+		AssetID:   original.CounterpartyID,
+		Timestamp: original.Timestamp,
+		TxID:      original.TxID,
+		TransType: pairType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	pair, err := s.transactionAt(ctx, pairKey)
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, fmt.Errorf("the transaction %s-%s-%s does not exist", original.CounterpartyID, pairType, original.TxID)
+	}
+	paid, received := original, pair
+	if strings.HasSuffix(original.TransType, "_IN") {
+		paid, received = pair, original
+	}
+
+	payer, err := s.reversibleAsset(ctx, paid.AssetID)
+	if err != nil {
+		return nil, err
+	}
+	payee, err := s.reversibleAsset(ctx, received.AssetID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAvailable(payee, received.Amount); err != nil {
+		return nil, err
+	}
+
+	value := received.Amount
+	payeePrev, payerPrev := payee.Balance, payer.Balance
+	if payee.Balance, err = payee.Balance.Sub(value); err != nil {
+		return nil, err
+	}
+	if payer.Balance, err = payer.Balance.Add(value); err != nil {
+		return nil, err
+	}
+	for _, asset := range []*Asset{payee, payer} {
+		asset.TransAmount = value
+		asset.Remarks = reason
+		asset.UpdatedAt = now
+	}
+	payee.TransType = "REVERSAL_OUT"
+	payer.TransType = "REVERSAL_IN"
+	if err := s.putAsset(ctx, payee); err != nil {
+		return nil, err
+	}
+	if err := s.putAsset(ctx, payer); err != nil {
+		return nil, err
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	debit := Transaction{
+		ID:             transactionID(ctx, payee.MSISDN, payee.TransType),
+		AssetID:        payee.MSISDN,
+		TransType:      payee.TransType,
+		Amount:         value,
+		PrevBalance:    payeePrev,
+		NewBalance:     payee.Balance,
+		Remarks:        reason,
+		Timestamp:      now,
+		TxID:           txID,
+		CounterpartyID: payer.MSISDN,
+		Actor:          actor,
+		ReversalOf:     received.ID,
+	}
+	credit := Transaction{
+		ID:             transactionID(ctx, payer.MSISDN, payer.TransType),
+		AssetID:        payer.MSISDN,
+		TransType:      payer.TransType,
+		Amount:         value,
+		PrevBalance:    payerPrev,
+		NewBalance:     payer.Balance,
+		Remarks:        reason,
+		Timestamp:      now,
+		TxID:           txID,
+		CounterpartyID: payee.MSISDN,
+		Actor:          actor,
+		ReversalOf:     paid.ID,
+	}
+	debit.LinkedTxnID = credit.ID
+	credit.LinkedTxnID = debit.ID
+
+	if err := s.recordTransaction(ctx, debit); err != nil {
+		return nil, err
+	}
+	if err := s.recordTransaction(ctx, credit); err != nil {
+		return nil, err
+	}
+	if err := s.markReversed(ctx, received, debit.ID); err != nil {
+		return nil, err
+	}
+	if err := s.markReversed(ctx, paid, credit.ID); err != nil {
+		return nil, err
+	}
+
+	if original == paid {
+		return &credit, nil
+	}
+	return &debit, nil
+}
+
+// reversibleAsset loads an asset a reversal moves value on. Closed assets
+// cannot take part; they must be restored first.
+func (s *SmartContract) reversibleAsset(ctx contractapi.TransactionContextInterface, msisdn string) (*Asset, error) {
+	asset, err := s.readAsset(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	if asset.Status == statusClosed {
+		return nil, newContractError(errInvalidStatus, "account %s is closed", msisdn)
+	}
+	return asset, nil
+}
+
+// markReversed rewrites a transaction record as REVERSED by reversalID
+func (s *SmartContract) markReversed(ctx contractapi.TransactionContextInterface, transaction *Transaction, reversalID string) error {
+	transaction.Status = transactionStatusReversed
+	transaction.ReversedBy = reversalID
+	return s.recordTransaction(ctx, *transaction)
+}
+
+// readTransaction loads a transaction record by its ID through the ID index.
+// Records written before the index existed are found by scanning the history
+// of the asset named in the ID, which has the form
+// "<asset ID>-<transaction type>-<Fabric transaction ID>".
+func (s *SmartContract) readTransaction(ctx contractapi.TransactionContextInterface, id string) (*Transaction, error) {
+	indexKey, err := ctx.GetStub().CreateCompositeKey(transactionIDObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	key, err := ctx.GetStub().GetState(indexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if key != nil {
+		transaction, err := s.transactionAt(ctx, string(key))
+		if err != nil {
+			return nil, err
+		}
+		if transaction != nil {
+			return transaction, nil
+		}
+	}
+
+	typeEnd := strings.LastIndex(id, "-")
+	assetEnd := -1
+	if typeEnd > 0 {
+		assetEnd = strings.LastIndex(id[:typeEnd], "-")
+	}
+	if assetEnd <= 0 {
+		return nil, fmt.Errorf("the transaction %s does not exist", id)
+	}
+
+	transactions, err := s.transactionHistory(ctx, id[:assetEnd])
+	if err != nil {
+		return nil, err
+	}
+	for _, transaction := range transactions {
+		if transaction.ID == id {
+			return transaction, nil
+		}
+	}
+	return nil, fmt.Errorf("the transaction %s does not exist", id)
+}
+
+// transactionAt loads the transaction record stored under key, returning nil
+// if there is none
+func (s *SmartContract) transactionAt(ctx contractapi.TransactionContextInterface, key string) (*Transaction, error) {
+	transactionJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if transactionJSON == nil {
+		return nil, nil
+	}
+
+	var transaction Transaction
+	if err := json.Unmarshal(transactionJSON, &transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
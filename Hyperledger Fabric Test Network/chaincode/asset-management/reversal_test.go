@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverseDebit(t *testing.T) {
+	transactionContext, chaincodeStub, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(10000), Status: "ACTIVE"}, "1234")
+	assetTransfer := SmartContract{}
+
+	chaincodeStub.GetTxIDReturns("txid1")
+	debit, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "30.00", "DEBIT", "Debit in error")
+	assert.Nil(t, err)
+
+	chaincodeStub.GetTxIDReturns("txid2")
+	_, err = assetTransfer.ReverseTransaction(transactionContext, debit.ID, "")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: a reason is required to reverse transaction 1234567890-DEBIT-txid1")
+	reversal, err := assetTransfer.ReverseTransaction(transactionContext, debit.ID, "Debited twice")
+	assert.Nil(t, err)
+	assert.Equal(t, "REVERSAL", reversal.TransType)
+	assert.Equal(t, debit.ID, reversal.ReversalOf)
+	assert.Equal(t, usd(10000), reversal.NewBalance)
+	assert.Equal(t, "Org1MSP/"+testClientID, reversal.Actor)
+
+	// The original is marked and cannot be reversed again
+	original, err := assetTransfer.readTransaction(transactionContext, debit.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "REVERSED", original.Status)
+	assert.Equal(t, reversal.ID, original.ReversedBy)
+	chaincodeStub.GetTxIDReturns("txid3")
+	_, err = assetTransfer.ReverseTransaction(transactionContext, debit.ID, "Again")
+	assert.EqualError(t, err, "INVALID_TRANSITION: transaction 1234567890-DEBIT-txid1 has already been reversed by 1234567890-REVERSAL-txid2")
+
+	_, err = assetTransfer.ReverseTransaction(transactionContext, reversal.ID, "Undo the undo")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: REVERSAL transactions cannot be reversed")
+	_, err = assetTransfer.ReverseTransaction(transactionContext, "1234567890-DEBIT-txid404", "Missing")
+	assert.EqualError(t, err, "the transaction 1234567890-DEBIT-txid404 does not exist")
+	_, err = assetTransfer.ReverseTransaction(transactionContext, "garbage", "Missing")
+	assert.EqualError(t, err, "the transaction garbage does not exist")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleAgent, ""))
+	_, err = assetTransfer.ReverseTransaction(transactionContext, debit.ID, "Agent")
+	assertForbidden(t, err)
+}
+
+func TestReverseTransfer(t *testing.T) {
+	transactionContext, chaincodeStub, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(10000), Status: "ACTIVE"}, "1234")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567891", Balance: usd(0), Status: "ACTIVE"}, "5678")
+	assetTransfer := SmartContract{}
+
+	chaincodeStub.GetTxIDReturns("txid1")
+	transfer, err := assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567891", "40.00", "Wrong recipient")
+	assert.Nil(t, err)
+
+	// The recipient must still hold the amount
+	chaincodeStub.GetTxIDReturns("txid2")
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "5678"), "1234567891", "1234567890", "35.00", "Spent")
+	assert.Nil(t, err)
+	chaincodeStub.GetTxIDReturns("txid3")
+	_, err = assetTransfer.ReverseTransaction(transactionContext, transfer.ID, "Wrong recipient")
+	assert.EqualError(t, err, "insufficient balance. Current balance: 5.00, Requested: 40.00")
+
+	chaincodeStub.GetTxIDReturns("txid4")
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567891", "35.00", "Returned")
+	assert.Nil(t, err)
+
+	// Naming the credit leg reverses the whole transfer
+	chaincodeStub.GetTxIDReturns("txid5")
+	reversal, err := assetTransfer.ReverseTransaction(transactionContext, "1234567891-TRANSFER_IN-txid1", "Wrong recipient")
+	assert.Nil(t, err)
+	assert.Equal(t, "REVERSAL_OUT", reversal.TransType)
+	assert.Equal(t, "1234567891", reversal.AssetID)
+	assert.Equal(t, "1234567891-TRANSFER_IN-txid1", reversal.ReversalOf)
+	assert.Equal(t, usd(0), reversal.NewBalance)
+
+	sender, err := assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(10000), sender.Balance)
+	assert.Equal(t, "REVERSAL_IN", sender.TransType)
+	debit, err := assetTransfer.readTransaction(transactionContext, transfer.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "REVERSED", debit.Status)
+	assert.Equal(t, "1234567890-REVERSAL_IN-txid5", debit.ReversedBy)
+
+	chaincodeStub.GetTxIDReturns("txid6")
+	_, err = assetTransfer.ReverseTransaction(transactionContext, transfer.ID, "Again")
+	assert.EqualError(t, err, "INVALID_TRANSITION: transaction 1234567890-TRANSFER_OUT-txid1 has already been reversed by 1234567890-REVERSAL_IN-txid5")
+}
+
+func TestReverseUnsupportedTransaction(t *testing.T) {
+	transactionContext, chaincodeStub, _ := newWorldState()
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER001", MSPID: "Org1MSP", Status: "ACTIVE", CreditLimit: usd(10000)})
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(0), Status: "ACTIVE"}, "1234")
+	assetTransfer := SmartContract{}
+
+	chaincodeStub.GetTxIDReturns("txid1")
+	credit, err := assetTransfer.DistributeFloat(transactionContext, "DEALER001", "1234567890", "20.00", "Float sale")
+	assert.Nil(t, err)
+
+	chaincodeStub.GetTxIDReturns("txid2")
+	_, err = assetTransfer.ReverseTransaction(transactionContext, credit.ID, "Sold in error")
+	var contractErr *ContractError
+	assert.True(t, errors.As(err, &contractErr))
+	assert.Equal(t, errInvalidArgument, contractErr.Code)
+	assert.EqualError(t, err, "INVALID_ARGUMENT: DISTRIBUTION_IN transactions cannot be reversed")
+}
+
+func TestReadTransaction(t *testing.T) {
+	transactionContext, chaincodeStub, state := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(10000), Status: "ACTIVE"}, "1234")
+	assetTransfer := SmartContract{}
+
+	chaincodeStub.GetTxIDReturns("txid1")
+	debit, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "30.00", "DEBIT", "Debit")
+	assert.Nil(t, err)
+
+	// Records are indexed by ID
+	indexKey, err := shim.CreateCompositeKey("txnid", []string{debit.ID})
+	assert.Nil(t, err)
+	recordKey, err := transactionKey(transactionContext, *debit)
+	assert.Nil(t, err)
+	assert.Equal(t, recordKey, string(state[indexKey]))
+	transaction, err := assetTransfer.readTransaction(transactionContext, debit.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, debit.Amount, transaction.Amount)
+
+	// Records written before the index are found in the asset's history
+	delete(state, indexKey)
+	transaction, err = assetTransfer.readTransaction(transactionContext, debit.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, debit.ID, transaction.ID)
+}
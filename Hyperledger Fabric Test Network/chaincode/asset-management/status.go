@@ -0,0 +1,113 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Asset statuses. Only ACTIVE assets can move funds.
+const (
+	statusPendingKYC = "PENDING_KYC"
+	statusActive     = "ACTIVE"
+	statusSuspended  = "SUSPENDED"
+	statusBlocked    = "BLOCKED"
+	statusLocked     = "LOCKED"
+	statusClosed     = "CLOSED"
+)
+
+// statusTransitions lists the statuses each status may move to. LOCKED is
+// normally entered after too many wrong MPINs and only returns to ACTIVE
+// through UnlockAsset, and CLOSED is entered with DeleteAsset and left with
+// RestoreAsset.
+var statusTransitions = map[string][]string{
+	statusPendingKYC: {statusActive, statusBlocked, statusClosed},
+	statusActive:     {statusSuspended, statusBlocked, statusLocked, statusClosed},
+	statusSuspended:  {statusActive, statusBlocked, statusClosed},
+	statusBlocked:    {statusActive, statusSuspended, statusClosed},
+	statusLocked:     {statusActive, statusBlocked, statusClosed},
+	statusClosed:     {statusActive},
+}
+
+// validateStatus rejects anything outside the set of known statuses
+func validateStatus(status string) error {
+	if _, ok := statusTransitions[status]; !ok {
+		return newContractError(errInvalidStatus, "unknown status %q", status)
+	}
+	return nil
+}
+
+// canTransition reports whether an asset may move from one status to another.
+// Assets still carrying a status from before the state machine was introduced
+// may move to any known status.
+func canTransition(from, to string) bool {
+	allowed, known := statusTransitions[from]
+	if !known {
+		return true
+	}
+	for _, status := range allowed {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// changeStatus moves asset to newStatus, writes it and records a STATUS_CHANGE
+// transaction naming the invoking client and the reason. Leaving LOCKED resets
+// the failed MPIN counter. The caller emits the event.
+func (s *SmartContract) changeStatus(ctx contractapi.TransactionContextInterface, asset *Asset, newStatus, reason string) (*Transaction, error) {
+	if err := validateStatus(newStatus); err != nil {
+		return nil, err
+	}
+	if asset.Status == newStatus {
+		return nil, newContractError(errInvalidTransition, "account %s is already %s", asset.MSISDN, newStatus)
+	}
+	if !canTransition(asset.Status, newStatus) {
+		return nil, newContractError(errInvalidTransition, "cannot change account %s from %s to %s", asset.MSISDN, asset.Status, newStatus)
+	}
+
+	actor, err := invokerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prevStatus := asset.Status
+	if prevStatus == statusLocked {
+		asset.FailedMPINAttempts = 0
+	}
+	asset.Status = newStatus
+	asset.TransAmount = Money{Currency: asset.Balance.Currency}
+	asset.TransType = "STATUS_CHANGE"
+	asset.Remarks = reason
+	asset.UpdatedAt = now
+
+	err = s.putAsset(ctx, asset)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction := Transaction{
+		ID:          transactionID(ctx, asset.MSISDN, "STATUS_CHANGE"),
+		AssetID:     asset.MSISDN,
+		TransType:   "STATUS_CHANGE",
+		Amount:      Money{Currency: asset.Balance.Currency},
+		PrevBalance: asset.Balance,
+		NewBalance:  asset.Balance,
+		Remarks:     reason,
+		Timestamp:   now,
+		TxID:        ctx.GetStub().GetTxID(),
+		Actor:       actor,
+		PrevStatus:  prevStatus,
+		NewStatus:   newStatus,
+	}
+
+	err = s.recordTransaction(ctx, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transaction, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanTransition(t *testing.T) {
+	assert.True(t, canTransition(statusPendingKYC, statusActive))
+	assert.True(t, canTransition(statusActive, statusSuspended))
+	assert.True(t, canTransition(statusLocked, statusActive))
+	assert.True(t, canTransition(statusClosed, statusActive))
+	assert.False(t, canTransition(statusPendingKYC, statusLocked))
+	assert.False(t, canTransition(statusSuspended, statusLocked))
+	assert.False(t, canTransition(statusClosed, statusSuspended))
+
+	// Statuses written before the state machine existed can still be moved on
+	assert.True(t, canTransition("INACTIVE", statusActive))
+}
+
+func TestUpdateAssetStatus(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(100000), Status: "ACTIVE"}, "1234")
+
+	assetTransfer := SmartContract{}
+	err := assetTransfer.UpdateAssetStatus(transactionContext, "1234567890", "SUSPENDED", "")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: a reason is required to change the status of account 1234567890")
+
+	err = assetTransfer.UpdateAssetStatus(transactionContext, "1234567890", "DORMANT", "Unknown status test")
+	var contractErr *ContractError
+	assert.True(t, errors.As(err, &contractErr))
+	assert.Equal(t, errInvalidStatus, contractErr.Code)
+	assert.EqualError(t, err, `INVALID_STATUS: unknown status "DORMANT"`)
+
+	err = assetTransfer.UpdateAssetStatus(transactionContext, "1234567890", "SUSPENDED", "Fraud review")
+	assert.Nil(t, err)
+
+	err = assetTransfer.UpdateAssetStatus(transactionContext, "1234567890", "SUSPENDED", "Fraud review")
+	assert.EqualError(t, err, "INVALID_TRANSITION: account 1234567890 is already SUSPENDED")
+
+	err = assetTransfer.UpdateAssetStatus(transactionContext, "1234567890", "LOCKED", "Illegal transition test")
+	assert.True(t, errors.As(err, &contractErr))
+	assert.Equal(t, errInvalidTransition, contractErr.Code)
+	assert.EqualError(t, err, "INVALID_TRANSITION: cannot change account 1234567890 from SUSPENDED to LOCKED")
+
+	asset, err := assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, "SUSPENDED", asset.Status)
+	assert.Equal(t, "Fraud review", asset.Remarks)
+
+	history, err := assetTransfer.GetTransactionHistory(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, "STATUS_CHANGE", history[0].TransType)
+	assert.Equal(t, "ACTIVE", history[0].PrevStatus)
+	assert.Equal(t, "SUSPENDED", history[0].NewStatus)
+	assert.Equal(t, "Fraud review", history[0].Remarks)
+	assert.Equal(t, "Org1MSP/"+testClientID, history[0].Actor)
+	assert.Equal(t, usd(100000), history[0].NewBalance)
+}
+
+func TestUpdateAssetStatusLocked(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(100000), Status: "LOCKED", FailedMPINAttempts: 3}, "1234")
+
+	assetTransfer := SmartContract{}
+	err := assetTransfer.UpdateAssetStatus(transactionContext, "1234567890", "ACTIVE", "Bypass unlock test")
+	var contractErr *ContractError
+	assert.True(t, errors.As(err, &contractErr))
+	assert.Equal(t, errInvalidTransition, contractErr.Code)
+	assert.EqualError(t, err, "INVALID_TRANSITION: account 1234567890 is locked; use UnlockAsset to reactivate it")
+
+	// A locked account can still be blocked while it is investigated
+	err = assetTransfer.UpdateAssetStatus(transactionContext, "1234567890", "BLOCKED", "Fraud review")
+	assert.Nil(t, err)
+
+	asset, err := assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, "BLOCKED", asset.Status)
+	assert.Equal(t, 0, asset.FailedMPINAttempts)
+}
+
+func TestCreateAssetStatus(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER001", MSPID: "Org1MSP", Status: "ACTIVE"})
+
+	assetTransfer := SmartContract{}
+	err := assetTransfer.CreateAsset(withMPIN(transactionContext, "1234"), "1234567890", "DEALER001", "0.00", "USD", "SUSPENDED", "Create test")
+	assert.EqualError(t, err, `INVALID_STATUS: new assets must be PENDING_KYC or ACTIVE, not "SUSPENDED"`)
+
+	err = assetTransfer.CreateAsset(withMPIN(transactionContext, "1234"), "1234567890", "DEALER001", "0.00", "USD", "PENDING_KYC", "Create test")
+	assert.Nil(t, err)
+
+	// Funds cannot move until KYC is complete
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "1234"), "1234567890", "100.00", "DEBIT", "Pending KYC test")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// The float issued into the system in a currency is a base counter plus the
+// deltas written since. Every balance held by an asset or a dealer is
+// accounted for by it. Debits, captures and reversals change the total, so
+// each writes its own delta key instead of rewriting the counter: concurrent
+// transactions never read or write the same key and cannot fail each other's
+// MVCC validation. CompactSupply folds the deltas back into the counter.
+const (
+	// supplyObjectType keys the base counter, by currency
+	supplyObjectType = "supply"
+	// supplyDeltaObjectType keys a change to the total, by currency and transaction ID
+	supplyDeltaObjectType = "supplyDelta"
+)
+
+// SupplyCheck compares the float issued in one currency with what assets and
+// dealers hold. Balanced is false when value was created or lost outside the
+// float operations.
+type SupplyCheck struct {
+	AssetBalances Money  `json:"assetBalances"`
+	Balanced      bool   `json:"balanced"`
+	Currency      string `json:"currency"`
+	DealerFloat   Money  `json:"dealerFloat"`
+	Issued        Money  `json:"issued"`
+}
+
+// Issue mints amount of new float into a dealer's float once the operator has
+// received the matching funds, and records an ISSUE transaction under the
+// dealer's ID. It is the only way value enters the system. The amount is a
+// decimal string in the dealer's currency; reference identifies the payment.
+func (s *SmartContract) Issue(ctx contractapi.TransactionContextInterface, dealerId, amount, reference string) (*Transaction, error) {
+	return s.mint(ctx, dealerId, amount, reference, true)
+}
+
+// Burn destroys amount of a dealer's float when the operator pays the dealer
+// out, and records a BURN transaction under the dealer's ID. The float must
+// cover the amount on its own; the dealer's credit limit does not count.
+func (s *SmartContract) Burn(ctx contractapi.TransactionContextInterface, dealerId, amount, reference string) (*Transaction, error) {
+	return s.mint(ctx, dealerId, amount, reference, false)
+}
+
+// GetTotalSupply returns the float issued and not yet burnt in currency. It
+// should always match the operator's bank account for that currency.
+func (s *SmartContract) GetTotalSupply(ctx contractapi.TransactionContextInterface, currency string) (*Money, error) {
+	if _, err := authorize(ctx, roleAdmin, roleTreasury); err != nil {
+		return nil, err
+	}
+
+	issued, err := s.getIssued(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+	return &issued, nil
+}
+
+// CheckSupply verifies, per currency, that the float issued equals the sum of
+// all asset balances and dealer floats. It reads the whole world state, so it
+// is meant for audits rather than regular use.
+func (s *SmartContract) CheckSupply(ctx contractapi.TransactionContextInterface) ([]*SupplyCheck, error) {
+	if _, err := authorize(ctx, roleAdmin, roleTreasury); err != nil {
+		return nil, err
+	}
+
+	checks := map[string]*SupplyCheck{}
+	check := func(currency string) *SupplyCheck {
+		if checks[currency] == nil {
+			zero := Money{Currency: currency}
+			checks[currency] = &SupplyCheck{Currency: currency, Issued: zero, AssetBalances: zero, DealerFloat: zero}
+		}
+		return checks[currency]
+	}
+
+	holdings, err := s.assetHoldings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for currency, total := range holdings {
+		check(currency).AssetBalances = total
+	}
+
+	dealers, err := ctx.GetStub().GetStateByPartialCompositeKey(dealerObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer dealers.Close()
+	for dealers.HasNext() {
+		queryResponse, err := dealers.Next()
+		if err != nil {
+			return nil, err
+		}
+		var dealer Dealer
+		if err := json.Unmarshal(queryResponse.Value, &dealer); err != nil {
+			return nil, fmt.Errorf("failed to read record %s: %v", queryResponse.Key, err)
+		}
+		entry := check(dealer.FloatBalance.Currency)
+		if entry.DealerFloat, err = entry.DealerFloat.Add(dealer.FloatBalance); err != nil {
+			return nil, err
+		}
+	}
+
+	issued, err := s.allIssued(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for currency, total := range issued {
+		check(currency).Issued = total
+	}
+
+	results := []*SupplyCheck{}
+	for _, entry := range checks {
+		held, err := entry.AssetBalances.Add(entry.DealerFloat)
+		if err != nil {
+			return nil, err
+		}
+		entry.Balanced = held == entry.Issued
+		results = append(results, entry)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Currency < results[j].Currency })
+
+	return results, nil
+}
+
+// MigrateSupply starts the issued counter of every currency that does not have
+// one yet at the total currently held by assets and dealers, so that ledgers
+// with balances from before the counter existed pass CheckSupply. Currencies
+// that already have a counter or deltas are left alone. It returns the number
+// of counters started.
+func (s *SmartContract) MigrateSupply(ctx contractapi.TransactionContextInterface) (int, error) {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return 0, err
+	}
+
+	checks, err := s.CheckSupply(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	issued, err := s.allIssued(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	started := 0
+	for _, check := range checks {
+		if _, counted := issued[check.Currency]; counted {
+			continue
+		}
+
+		held, err := check.AssetBalances.Add(check.DealerFloat)
+		if err != nil {
+			return 0, err
+		}
+		if err := s.putIssued(ctx, held); err != nil {
+			return 0, err
+		}
+		started++
+	}
+
+	return started, nil
+}
+
+// CompactSupply folds the deltas written since the last compaction into the
+// issued counter of currency and returns the total. It reads every delta of
+// the currency, so a debit committed at the same time makes it fail MVCC
+// validation; it can simply be retried.
+func (s *SmartContract) CompactSupply(ctx contractapi.TransactionContextInterface, currency string) (*Money, error) {
+	if _, err := authorize(ctx, roleAdmin); err != nil {
+		return nil, err
+	}
+
+	issued, err := s.getIssued(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	deltas, err := ctx.GetStub().GetStateByPartialCompositeKey(supplyDeltaObjectType, []string{currency})
+	if err != nil {
+		return nil, err
+	}
+	defer deltas.Close()
+	for deltas.HasNext() {
+		queryResponse, err := deltas.Next()
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.putIssued(ctx, issued); err != nil {
+		return nil, err
+	}
+	return &issued, nil
+}
+
+// mint adds value to a dealer's float when issue is set and removes it
+// otherwise, moving the issued total with it
+func (s *SmartContract) mint(ctx contractapi.TransactionContextInterface, dealerId, amount, reference string, issue bool) (*Transaction, error) {
+	if _, err := authorize(ctx, roleTreasury); err != nil {
+		return nil, err
+	}
+
+	dealer, err := s.requireActiveDealer(ctx, dealerId)
+	if err != nil {
+		return nil, err
+	}
+	value, err := ParseMoney(amount, dealer.FloatBalance.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if !value.IsPositive() {
+		return nil, newContractError(errInvalidArgument, "amount must be positive")
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prevFloat := dealer.FloatBalance
+	transType, delta := "ISSUE", value
+	if issue {
+		if dealer.FloatBalance, err = dealer.FloatBalance.Add(value); err != nil {
+			return nil, err
+		}
+	} else {
+		insufficient, err := dealer.FloatBalance.LessThan(value)
+		if err != nil {
+			return nil, err
+		}
+		if insufficient {
+			return nil, fmt.Errorf("insufficient float. Dealer %s float: %s, requested: %s", dealerId, dealer.FloatBalance, value)
+		}
+		if dealer.FloatBalance, err = dealer.FloatBalance.Sub(value); err != nil {
+			return nil, err
+		}
+		transType, delta = "BURN", Money{Amount: -value.Amount, Currency: value.Currency}
+	}
+
+	dealer.UpdatedAt = now
+	if err := s.putDealer(ctx, dealer); err != nil {
+		return nil, err
+	}
+	if err := s.adjustIssued(ctx, delta); err != nil {
+		return nil, err
+	}
+
+	transaction := Transaction{
+		ID:          transactionID(ctx, dealer.ID, transType),
+		AssetID:     dealer.ID,
+		TransType:   transType,
+		Amount:      value,
+		PrevBalance: prevFloat,
+		NewBalance:  dealer.FloatBalance,
+		Remarks:     reference,
+		Timestamp:   now,
+		TxID:        ctx.GetStub().GetTxID(),
+	}
+	if err := s.recordTransaction(ctx, transaction); err != nil {
+		return nil, err
+	}
+	if err := emitEvent(ctx, eventBalanceChanged, transaction); err != nil {
+		return nil, err
+	}
+
+	return &transaction, nil
+}
+
+// assetHoldings sums the balances of all assets per currency
+func (s *SmartContract) assetHoldings(ctx contractapi.TransactionContextInterface) (map[string]Money, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	holdings := map[string]Money{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		asset, ok, err := assetFromRecord(queryResponse.Key, queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		total, found := holdings[asset.Balance.Currency]
+		if !found {
+			total = Money{Currency: asset.Balance.Currency}
+		}
+		if holdings[asset.Balance.Currency], err = total.Add(asset.Balance); err != nil {
+			return nil, err
+		}
+	}
+
+	return holdings, nil
+}
+
+// getIssued returns the float issued so far in currency: its counter plus
+// the deltas written since
+func (s *SmartContract) getIssued(ctx contractapi.TransactionContextInterface, currency string) (Money, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(supplyObjectType, []string{currency})
+	if err != nil {
+		return Money{}, err
+	}
+	issuedJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return Money{}, fmt.Errorf("failed to read from world state: %v", err)
+	}
+
+	issued := Money{Currency: currency}
+	if issuedJSON != nil {
+		if err := json.Unmarshal(issuedJSON, &issued); err != nil {
+			return Money{}, fmt.Errorf("failed to read %s supply: %v", currency, err)
+		}
+	}
+
+	deltas, err := ctx.GetStub().GetStateByPartialCompositeKey(supplyDeltaObjectType, []string{currency})
+	if err != nil {
+		return Money{}, err
+	}
+	defer deltas.Close()
+	for deltas.HasNext() {
+		queryResponse, err := deltas.Next()
+		if err != nil {
+			return Money{}, err
+		}
+		var delta Money
+		if err := json.Unmarshal(queryResponse.Value, &delta); err != nil {
+			return Money{}, fmt.Errorf("failed to read record %s: %v", queryResponse.Key, err)
+		}
+		if issued, err = issued.Add(delta); err != nil {
+			return Money{}, err
+		}
+	}
+	return issued, nil
+}
+
+// allIssued returns the float issued in every currency that has a counter or deltas
+func (s *SmartContract) allIssued(ctx contractapi.TransactionContextInterface) (map[string]Money, error) {
+	issued := map[string]Money{}
+	for _, objectType := range []string{supplyObjectType, supplyDeltaObjectType} {
+		resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{})
+		if err != nil {
+			return nil, err
+		}
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+			var amount Money
+			if err := json.Unmarshal(queryResponse.Value, &amount); err != nil {
+				resultsIterator.Close()
+				return nil, fmt.Errorf("failed to read record %s: %v", queryResponse.Key, err)
+			}
+			total, found := issued[amount.Currency]
+			if !found {
+				total = Money{Currency: amount.Currency}
+			}
+			if issued[amount.Currency], err = total.Add(amount); err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+		}
+		resultsIterator.Close()
+	}
+	return issued, nil
+}
+
+// adjustIssued records delta, which may be negative, as a change to the float
+// issued in its currency. It writes a key of its own without reading the
+// total, so it must be called at most once per currency in a transaction.
+func (s *SmartContract) adjustIssued(ctx contractapi.TransactionContextInterface, delta Money) error {
+	key, err := ctx.GetStub().CreateCompositeKey(supplyDeltaObjectType, []string{delta.Currency, ctx.GetStub().GetTxID()})
+	if err != nil {
+		return err
+	}
+	deltaJSON, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, deltaJSON)
+}
+
+// putIssued writes the issued counter of a currency
+func (s *SmartContract) putIssued(ctx contractapi.TransactionContextInterface, issued Money) error {
+	key, err := ctx.GetStub().CreateCompositeKey(supplyObjectType, []string{issued.Currency})
+	if err != nil {
+		return err
+	}
+	issuedJSON, err := json.Marshal(issued)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, issuedJSON)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssueAndBurn(t *testing.T) {
+	transactionContext, chaincodeStub, _ := newWorldState()
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER001", MSPID: "Org1MSP", Status: "ACTIVE", CreditLimit: usd(5000), FloatBalance: usd(0)})
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER002", MSPID: "Org1MSP", Status: "SUSPENDED"})
+	assetTransfer := SmartContract{}
+
+	// Only the treasury mints and burns
+	_, err := assetTransfer.Issue(transactionContext, "DEALER001", "100.00", "Admin issued")
+	assertForbidden(t, err)
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleTreasury, ""))
+	chaincodeStub.GetTxIDReturns("txid1")
+	issue, err := assetTransfer.Issue(transactionContext, "DEALER001", "100.00", "Bank ref 42")
+	assert.Nil(t, err)
+	assert.Equal(t, "ISSUE", issue.TransType)
+	assert.Equal(t, "DEALER001", issue.AssetID)
+	assert.Equal(t, "Bank ref 42", issue.Remarks)
+	assert.Equal(t, usd(10000), issue.NewBalance)
+
+	_, err = assetTransfer.Issue(transactionContext, "DEALER001", "0.00", "Nothing")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: amount must be positive")
+	_, err = assetTransfer.Issue(transactionContext, "DEALER002", "100.00", "Suspended")
+	assert.EqualError(t, err, "INVALID_STATUS: dealer DEALER002 is not active")
+
+	// The credit limit cannot be burnt
+	_, err = assetTransfer.Burn(transactionContext, "DEALER001", "100.01", "Payout")
+	assert.EqualError(t, err, "insufficient float. Dealer DEALER001 float: 100.00, requested: 100.01")
+	chaincodeStub.GetTxIDReturns("txid2")
+	burn, err := assetTransfer.Burn(transactionContext, "DEALER001", "40.00", "Payout")
+	assert.Nil(t, err)
+	assert.Equal(t, "BURN", burn.TransType)
+	assert.Equal(t, usd(6000), burn.NewBalance)
+
+	supply, err := assetTransfer.GetTotalSupply(transactionContext, "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(6000), *supply)
+	supply, err = assetTransfer.GetTotalSupply(transactionContext, "KES")
+	assert.Nil(t, err)
+	assert.Equal(t, Money{Currency: "KES"}, *supply)
+
+	// The treasury role is only honoured from the operator's organisation
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org2MSP", roleTreasury, ""))
+	_, err = assetTransfer.Issue(transactionContext, "DEALER001", "100.00", "Foreign treasury")
+	assert.EqualError(t, err, "FORBIDDEN: the treasury role is not accepted from Org2MSP")
+
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleDealer, "DEALER001"))
+	_, err = assetTransfer.GetTotalSupply(transactionContext, "USD")
+	assertForbidden(t, err)
+}
+
+func TestCheckSupply(t *testing.T) {
+	transactionContext, chaincodeStub, state := newWorldState()
+	assetTransfer := SmartContract{}
+	assert.Nil(t, assetTransfer.InitLedger(transactionContext))
+	counterKey, _ := shim.CreateCompositeKey(supplyObjectType, []string{"USD"})
+	counter := state[counterKey]
+
+	// Issues and debits move the issued total with them; distributions and
+	// transfers only move value around
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleTreasury, ""))
+	chaincodeStub.GetTxIDReturns("txid1")
+	_, err := assetTransfer.Issue(transactionContext, "DEALER001", "100.00", "Bank ref 42")
+	assert.Nil(t, err)
+	transactionContext.GetClientIdentityReturns(newClientIdentity("Org1MSP", roleAdmin, ""))
+	chaincodeStub.GetTxIDReturns("txid2")
+	_, err = assetTransfer.DistributeFloat(transactionContext, "DEALER001", "1234567890", "60.00", "Float sale")
+	assert.Nil(t, err)
+	chaincodeStub.GetTxIDReturns("txid3")
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "5678"), "1234567891", "25.00", "DEBIT", "Debit")
+	assert.Nil(t, err)
+	chaincodeStub.GetTxIDReturns("txid4")
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567892", "10.00", "Transfer")
+	assert.Nil(t, err)
+
+	checks, err := assetTransfer.CheckSupply(transactionContext)
+	assert.Nil(t, err)
+	assert.Len(t, checks, 1)
+	assert.Equal(t, usd(457500), checks[0].Issued)
+	assert.Equal(t, usd(4000), checks[0].DealerFloat)
+	assert.True(t, checks[0].Balanced)
+
+	// Each change is a delta of its own; the counter is never rewritten, so
+	// concurrent debits do not conflict on it
+	assert.Equal(t, counter, state[counterKey])
+	debitKey, _ := shim.CreateCompositeKey(supplyDeltaObjectType, []string{"USD", "txid3"})
+	assert.JSONEq(t, `{"amount":-2500,"currency":"USD"}`, string(state[debitKey]))
+
+	// Compaction folds the deltas into the counter
+	supply, err := assetTransfer.CompactSupply(transactionContext, "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(457500), *supply)
+	assert.JSONEq(t, `{"amount":457500,"currency":"USD"}`, string(state[counterKey]))
+	assert.Nil(t, state[debitKey])
+	supply, err = assetTransfer.GetTotalSupply(transactionContext, "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, usd(457500), *supply)
+
+	// A balance written behind the contract's back shows up
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567899", Balance: usd(100), Status: "ACTIVE"}, "0000")
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567898", Balance: Money{500, "KES"}, Status: "ACTIVE"}, "0000")
+	checks, err = assetTransfer.CheckSupply(transactionContext)
+	assert.Nil(t, err)
+	assert.Len(t, checks, 2)
+	assert.Equal(t, "KES", checks[0].Currency)
+	assert.False(t, checks[0].Balanced)
+	assert.False(t, checks[1].Balanced)
+
+	// MigrateSupply only starts counters that are missing
+	started, err := assetTransfer.MigrateSupply(transactionContext)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, started)
+	checks, err = assetTransfer.CheckSupply(transactionContext)
+	assert.Nil(t, err)
+	assert.True(t, checks[0].Balanced)
+	assert.Equal(t, Money{500, "KES"}, checks[0].Issued)
+	assert.False(t, checks[1].Balanced)
+}
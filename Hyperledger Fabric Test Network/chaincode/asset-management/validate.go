@@ -0,0 +1,53 @@
+package main
+
+import "regexp"
+
+// Formats of the identifiers clients choose. An MSISDN is either in E.164
+// form, "+" and up to 15 digits without a leading zero, or a national number
+// of 7 to 15 digits. Dealer IDs are upper case, like DEALER001.
+var (
+	msisdnPattern   = regexp.MustCompile(`^(\+[1-9][0-9]{6,14}|[0-9]{7,15})$`)
+	dealerIDPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_-]{2,31}$`)
+	mpinPattern     = regexp.MustCompile(`^[0-9]{4,6}$`)
+)
+
+// balanceTransTypes are the transaction types UpdateAssetBalance accepts.
+// CREDIT is known so that it can be refused with an explanation.
+var balanceTransTypes = map[string]bool{
+	"CREDIT": true,
+	"DEBIT":  true,
+}
+
+// validateMSISDN rejects MSISDNs that are neither E.164 nor national numbers
+func validateMSISDN(msisdn string) error {
+	if !msisdnPattern.MatchString(msisdn) {
+		return newContractError(errInvalidArgument, "invalid MSISDN %q: expected an E.164 or national number", msisdn)
+	}
+	return nil
+}
+
+// validateDealerID rejects dealer IDs that are not 3 to 32 upper case
+// letters, digits, hyphens or underscores starting with a letter
+func validateDealerID(id string) error {
+	if !dealerIDPattern.MatchString(id) {
+		return newContractError(errInvalidArgument, "invalid dealer ID %q: expected 3 to 32 upper case letters, digits, '-' or '_'", id)
+	}
+	return nil
+}
+
+// validateMPIN rejects MPINs that are not 4 to 6 digits. It applies when an
+// MPIN is set; a malformed MPIN offered for verification simply does not match.
+func validateMPIN(mpin string) error {
+	if !mpinPattern.MatchString(mpin) {
+		return newContractError(errInvalidArgument, "invalid MPIN: expected 4 to 6 digits")
+	}
+	return nil
+}
+
+// validateBalanceTransType rejects transaction types UpdateAssetBalance does not know
+func validateBalanceTransType(transType string) error {
+	if !balanceTransTypes[transType] {
+		return newContractError(errInvalidArgument, "invalid transaction type %q", transType)
+	}
+	return nil
+}
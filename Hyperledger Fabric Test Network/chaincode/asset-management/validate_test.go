@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateArguments(t *testing.T) {
+	for _, msisdn := range []string{"1234567890", "0712345678", "+254712345678", "+1234567"} {
+		assert.Nil(t, validateMSISDN(msisdn), msisdn)
+	}
+	for _, msisdn := range []string{"", "123456", "+0712345678", "+1234567890123456", "12345 67890", "0712-345678"} {
+		assert.EqualError(t, validateMSISDN(msisdn), `INVALID_ARGUMENT: invalid MSISDN "`+msisdn+`": expected an E.164 or national number`)
+	}
+
+	for _, id := range []string{"DEALER001", "AGENT_NORTH-2"} {
+		assert.Nil(t, validateDealerID(id), id)
+	}
+	for _, id := range []string{"", "D1", "dealer001", "1DEALER", "DEALER 001"} {
+		assert.Error(t, validateDealerID(id), id)
+	}
+
+	for _, mpin := range []string{"1234", "123456"} {
+		assert.Nil(t, validateMPIN(mpin), mpin)
+	}
+	for _, mpin := range []string{"", "123", "1234567", "12a4", " 1234"} {
+		assert.EqualError(t, validateMPIN(mpin), "INVALID_ARGUMENT: invalid MPIN: expected 4 to 6 digits", mpin)
+	}
+}
+
+func TestCreateAssetValidation(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	putTestDealer(t, transactionContext, Dealer{ID: "DEALER001", MSPID: "Org1MSP", Status: "ACTIVE"})
+	assetTransfer := SmartContract{}
+
+	err := assetTransfer.CreateAsset(withMPIN(transactionContext, "1234"), "", "DEALER001", "0.00", "USD", "ACTIVE", "No MSISDN")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: invalid MSISDN "": expected an E.164 or national number`)
+	err = assetTransfer.CreateAsset(withMPIN(transactionContext, "1234"), "1234567890", "dealer001", "0.00", "USD", "ACTIVE", "Bad dealer")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: invalid dealer ID "dealer001": expected 3 to 32 upper case letters, digits, '-' or '_'`)
+	err = assetTransfer.CreateAsset(withMPIN(transactionContext, "12"), "1234567890", "DEALER001", "0.00", "USD", "ACTIVE", "Short MPIN")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: invalid MPIN: expected 4 to 6 digits")
+	err = assetTransfer.CreateAsset(withMPIN(transactionContext, "1234"), "1234567890", "DEALER001", "-500", "USD", "ACTIVE", "Negative balance")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: invalid amount "-500"`)
+	err = assetTransfer.CreateAsset(withMPIN(transactionContext, "1234"), "1234567890", "DEALER001", "0.00", "USD", "OPEN", "Unknown status")
+	assert.EqualError(t, err, `INVALID_STATUS: new assets must be PENDING_KYC or ACTIVE, not "OPEN"`)
+	exists, err := assetTransfer.AssetExists(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	err = assetTransfer.CreateDealer(transactionContext, "dealer 2", "Dealer Two", "Org1MSP", "0", "USD")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: invalid dealer ID "dealer 2": expected 3 to 32 upper case letters, digits, '-' or '_'`)
+}
+
+func TestUpdateAssetBalanceValidation(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(10000), Status: "ACTIVE"}, "1234")
+	assetTransfer := SmartContract{}
+
+	// A negative debit would otherwise credit the account
+	_, err := assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "0000"), "1234567890", "-500", "DEBIT", "Negative")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: invalid amount "-500"`)
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "0000"), "1234567890", "0", "DEBIT", "Zero")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: amount must be positive")
+	_, err = assetTransfer.UpdateAssetBalance(withMPIN(transactionContext, "0000"), "1234567890", "10.00", "REFUND", "Unknown type")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: invalid transaction type "REFUND"`)
+
+	// Refused requests do not count as MPIN attempts
+	asset, err := assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, asset.FailedMPINAttempts)
+	assert.Equal(t, usd(10000), asset.Balance)
+}
+
+func TestTransferBalanceValidation(t *testing.T) {
+	transactionContext, _, _ := newWorldState()
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567890", Balance: usd(10000), KYCTier: "TIER1", Status: "ACTIVE"}, "1234")
+	assetTransfer := SmartContract{}
+
+	_, err := assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "12-34", "1234567890", "10.00", "Bad sender")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: invalid MSISDN "12-34": expected an E.164 or national number`)
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "", "10.00", "No recipient")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: invalid MSISDN "": expected an E.164 or national number`)
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "1234"), "1234567890", "1234567890", "10.00", "Self")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: cannot transfer from asset 1234567890 to itself")
+
+	// Refused requests do not count as MPIN attempts
+	putTestAsset(t, transactionContext, Asset{DealerID: "DEALER001", MSISDN: "1234567891", Balance: usd(0), KYCTier: "TIER1", Status: "ACTIVE"}, "5678")
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "0000"), "1234567890", "1234567891", "0", "Zero")
+	assert.EqualError(t, err, "INVALID_ARGUMENT: transfer amount must be positive")
+	_, err = assetTransfer.TransferBalance(withMPIN(transactionContext, "0000"), "1234567890", "1234567891", "1e3", "Malformed")
+	assert.EqualError(t, err, `INVALID_ARGUMENT: invalid amount "1e3"`)
+	asset, err := assetTransfer.ReadAsset(transactionContext, "1234567890")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, asset.FailedMPINAttempts)
+}
@@ -12,6 +12,12 @@ PURPLE='\033[0;35m'
 CYAN='\033[0;36m'
 NC='\033[0m' # No Color
 
+# API keys of the gateway identities, written by ./network.sh enrollUsers
+API_KEYS_FILE=${API_KEYS_FILE:-"organizations/peerOrganizations/org1.example.com/gateway-api-keys.env"}
+if [ -f "$API_KEYS_FILE" ]; then
+    . "$API_KEYS_FILE"
+fi
+
 # Function to print colored output
 print_header() {
     echo -e "${PURPLE}=========================================="
@@ -70,12 +76,14 @@ check_service() {
     return 1
 }
 
-# Function to make API call and show response
+# Function to make API call and show response. The call is signed by the
+# gateway with the identity of the given API key, the admin's by default.
 api_call() {
     local method=$1
     local endpoint=$2
     local data=$3
     local description=$4
+    local api_key=${5:-$ADMIN_API_KEY}
     
     print_step "$description"
     echo -e "${CYAN}Request: $method $endpoint${NC}"
@@ -83,11 +91,13 @@ api_call() {
     if [ -n "$data" ]; then
         echo -e "${CYAN}Data: $data${NC}"
         response=$(curl -s -X $method "http://localhost:8080$endpoint" \
+            -H "Authorization: Bearer $api_key" \
             -H "Content-Type: application/json" \
             -d "$data" \
             -w "\n%{http_code}")
     else
         response=$(curl -s -X $method "http://localhost:8080$endpoint" \
+            -H "Authorization: Bearer $api_key" \
             -w "\n%{http_code}")
     fi
     
@@ -147,90 +157,131 @@ demonstrate_api() {
     # Get all assets (should show initial data)
     api_call "GET" "/api/v1/assets" "" "3. Get All Assets (Initial Data)"
     
-    # Create a new asset
+    # Create a new asset. Assets open with a zero balance; the dealer creates
+    # them for its own dealer ID.
     local create_data='{
         "msisdn": "9876543210",
-        "dealerId": "DEALER999",
+        "dealerId": "DEALER001",
         "mpin": "9999",
-        "balance": 5000.0,
+        "currency": "USD",
         "status": "ACTIVE",
         "remarks": "Demo account created by verification script"
     }'
-    api_call "POST" "/api/v1/assets" "$create_data" "4. Create New Asset"
+    api_call "POST" "/api/v1/assets" "$create_data" "4. Create New Asset" "$DEALER001_API_KEY"
     
     # Get the created asset
-    api_call "GET" "/api/v1/assets/9876543210" "" "5. Get Created Asset"
+    api_call "GET" "/api/v1/assets/9876543210" "" "5. Get Created Asset" "$DEALER001_API_KEY"
     
-    # Credit the account
-    local credit_data='{
-        "mpin": "9999",
-        "amount": 1500.0,
-        "transType": "CREDIT",
-        "remarks": "Demo credit transaction"
+    # Upgrade the KYC tier; TIER0 assets cannot send transfers
+    local kyc_data='{
+        "tier": "TIER1",
+        "evidenceHash": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+        "remarks": "Demo KYC upgrade"
+    }'
+    api_call "POST" "/api/v1/assets/9876543210/kyc/upgrade" "$kyc_data" "6. Upgrade KYC Tier to TIER1"
+    
+    # Issue float to the dealer; only the treasury creates value
+    local issue_data='{
+        "dealerId": "DEALER001",
+        "amount": "5000.00",
+        "reference": "Demo bank transfer"
+    }'
+    api_call "POST" "/api/v1/supply/issue" "$issue_data" "7. Issue Float to DEALER001 (+5000)" "$TREASURY_API_KEY"
+    
+    # Distribute float from the dealer to the account
+    local distribute_data='{
+        "msisdn": "9876543210",
+        "amount": "1500.00",
+        "remarks": "Demo float distribution"
     }'
-    api_call "PUT" "/api/v1/assets/9876543210/balance" "$credit_data" "6. Credit Account (+1500)"
+    api_call "POST" "/api/v1/dealers/DEALER001/distribute" "$distribute_data" "8. Distribute Float to Account (+1500)" "$DEALER001_API_KEY"
     
-    # Get asset after credit
-    api_call "GET" "/api/v1/assets/9876543210" "" "7. Get Asset After Credit"
+    # Get asset after distribution
+    api_call "GET" "/api/v1/assets/9876543210" "" "9. Get Asset After Distribution" "$DEALER001_API_KEY"
     
     # Debit the account
     local debit_data='{
         "mpin": "9999",
-        "amount": 800.0,
+        "amount": "800.00",
         "transType": "DEBIT",
         "remarks": "Demo debit transaction"
     }'
-    api_call "PUT" "/api/v1/assets/9876543210/balance" "$debit_data" "8. Debit Account (-800)"
+    api_call "PUT" "/api/v1/assets/9876543210/balance" "$debit_data" "10. Debit Account (-800)" "$DEALER001_API_KEY"
+    
+    # Transfer to another account
+    local transfer_data='{
+        "fromMsisdn": "9876543210",
+        "toMsisdn": "1234567890",
+        "mpin": "9999",
+        "amount": "200.00",
+        "remarks": "Demo transfer"
+    }'
+    api_call "POST" "/api/v1/transfers" "$transfer_data" "11. Transfer to 1234567890 (-200)" "$DEALER001_API_KEY"
     
-    # Get asset after debit
-    api_call "GET" "/api/v1/assets/9876543210" "" "9. Get Asset After Debit"
+    # Get asset after debit and transfer
+    api_call "GET" "/api/v1/assets/9876543210" "" "12. Get Asset After Debit and Transfer" "$DEALER001_API_KEY"
     
     # Update status
     local status_data='{
         "status": "BLOCKED",
         "remarks": "Demo status update - account blocked"
     }'
-    api_call "PUT" "/api/v1/assets/9876543210/status" "$status_data" "10. Update Status to BLOCKED"
+    api_call "PUT" "/api/v1/assets/9876543210/status" "$status_data" "13. Update Status to BLOCKED"
     
     # Get asset after status update
-    api_call "GET" "/api/v1/assets/9876543210" "" "11. Get Asset After Status Update"
+    api_call "GET" "/api/v1/assets/9876543210" "" "14. Get Asset After Status Update"
     
     # Reactivate account
     local reactivate_data='{
         "status": "ACTIVE",
         "remarks": "Demo status update - account reactivated"
     }'
-    api_call "PUT" "/api/v1/assets/9876543210/status" "$reactivate_data" "12. Reactivate Account"
+    api_call "PUT" "/api/v1/assets/9876543210/status" "$reactivate_data" "15. Reactivate Account"
     
     # Get transaction history
-    api_call "GET" "/api/v1/assets/9876543210/transactions" "" "13. Get Transaction History"
+    api_call "GET" "/api/v1/assets/9876543210/transactions" "" "16. Get Transaction History"
     
-    # Get all assets (final state)
-    api_call "GET" "/api/v1/assets" "" "14. Get All Assets (Final State)"
+    # Check that the issued float matches what assets and dealers hold
+    api_call "GET" "/api/v1/supply/check" "" "17. Check Total Supply" "$TREASURY_API_KEY"
     
     # Test error scenarios
     print_header "ERROR SCENARIO TESTING"
     
+    # Test CREDIT; funds only arrive from a debited counterparty
+    local credit_data='{
+        "mpin": "9999",
+        "amount": "100.00",
+        "transType": "CREDIT",
+        "remarks": "Test credit"
+    }'
+    api_call "PUT" "/api/v1/assets/9876543210/balance" "$credit_data" "18. Test CREDIT (Should Fail with 400)" "$DEALER001_API_KEY"
+    
     # Test wrong MPIN
     local wrong_mpin_data='{
         "mpin": "0000",
-        "amount": 100.0,
-        "transType": "CREDIT",
+        "amount": "100.00",
+        "transType": "DEBIT",
         "remarks": "Test wrong MPIN"
     }'
-    api_call "PUT" "/api/v1/assets/9876543210/balance" "$wrong_mpin_data" "15. Test Wrong MPIN (Should Fail)"
+    api_call "PUT" "/api/v1/assets/9876543210/balance" "$wrong_mpin_data" "19. Test Wrong MPIN (Should Fail with 401)" "$DEALER001_API_KEY"
     
     # Test insufficient balance
     local insufficient_data='{
         "mpin": "9999",
-        "amount": 999999.0,
+        "amount": "999999.00",
         "transType": "DEBIT",
         "remarks": "Test insufficient balance"
     }'
-    api_call "PUT" "/api/v1/assets/9876543210/balance" "$insufficient_data" "16. Test Insufficient Balance (Should Fail)"
+    api_call "PUT" "/api/v1/assets/9876543210/balance" "$insufficient_data" "20. Test Insufficient Balance (Should Fail)" "$DEALER001_API_KEY"
     
     # Test non-existent asset
-    api_call "GET" "/api/v1/assets/0000000000" "" "17. Test Non-existent Asset (Should Fail)"
+    api_call "GET" "/api/v1/assets/0000000000" "" "21. Test Non-existent Asset (Should Fail)"
+    
+    # Close the demo account, settling its remaining balance
+    api_call "DELETE" "/api/v1/assets/9876543210?settlementMsisdn=1234567890&remarks=Demo+cleanup" "" "22. Close Demo Account"
+    
+    # Get all assets (final state)
+    api_call "GET" "/api/v1/assets" "" "23. Get All Assets (Final State)"
 }
 
 # Function to show deployment logs
@@ -17,6 +17,7 @@ function printHelp() {
   echo "      - 'restart' - restart the network"
   echo "      - 'generate' - generate required certificates and genesis block"
   echo "      - 'deployCC' - deploy the chaincode on the channel"
+  echo "      - 'enrollUsers' - enroll the API gateway's identities from the Org1 CA"
   echo
   echo "    Flags:"
   echo "    -ca <use CAs> -  create Certificate Authorities to generate the crypto material"
@@ -112,6 +113,9 @@ function networkUp() {
     COMPOSE_FILES="${COMPOSE_FILES} -f ${COMPOSE_FILE_COUCH}"
   fi
 
+  # the Org1 CA issues the gateway's identities, see enrollUsers
+  COMPOSE_FILES="${COMPOSE_FILES} -f ${COMPOSE_FILE_CA}"
+
   IMAGE_TAG=$IMAGETAG docker-compose ${COMPOSE_FILES} up -d 2>&1
 
   docker ps -a
@@ -182,6 +186,13 @@ function createOrgs() {
   ./organizations/ccp-generate.sh
 }
 
+# Enroll the identities the API gateway signs with. The chaincode takes the
+# caller's role and dealer ID from attributes of its certificate, which only
+# the CA can issue.
+function enrollUsers() {
+  DELAY=$CLI_DELAY MAX_RETRY=$MAX_RETRY scripts/enrollUsers.sh
+}
+
 # Generate orderer system channel genesis block.
 function generateGenesisBlock() {
   which configtxgen
@@ -410,6 +421,9 @@ elif [ "$MODE" == "restart" ]; then
 elif [ "$MODE" == "deployCC" ]; then
   echo "deploying chaincode on channel '${CHANNEL_NAME}'"
   echo
+elif [ "$MODE" == "enrollUsers" ]; then
+  echo "Enrolling API gateway identities"
+  echo
 else
   printHelp
   exit 1
@@ -421,6 +435,8 @@ elif [ "${MODE}" == "createChannel" ]; then
   createChannel
 elif [ "${MODE}" == "deployCC" ]; then
   deployCC
+elif [ "${MODE}" == "enrollUsers" ]; then
+  enrollUsers
 elif [ "${MODE}" == "down" ]; then
   networkDown
 elif [ "${MODE}" == "restart" ]; then
@@ -40,6 +40,25 @@ fatalln() {
 
 export FABRIC_CFG_PATH=$PWD/../config/
 
+# the chaincode derives every timestamp and ID from the transaction proposal, so
+# it can run under a policy that needs both orgs, e.g. "AND('Org1MSP.peer','Org2MSP.peer')"
+if [ "$CC_END_POLICY" = "NA" ]; then
+  CC_END_POLICY=""
+else
+  CC_END_POLICY="--signature-policy $CC_END_POLICY"
+fi
+
+# subscriber profiles and MPIN hashes are kept in private data collections, so
+# the chaincode's collections config is used unless another one is given
+if [ "$CC_COLL_CONFIG" = "NA" ] && [ -f "${CC_SRC_PATH}/collections_config.json" ]; then
+  CC_COLL_CONFIG="${CC_SRC_PATH}/collections_config.json"
+fi
+if [ "$CC_COLL_CONFIG" = "NA" ]; then
+  CC_COLL_CONFIG=""
+else
+  CC_COLL_CONFIG="--collections-config $CC_COLL_CONFIG"
+fi
+
 # import utils
 . scripts/envVar.sh
 
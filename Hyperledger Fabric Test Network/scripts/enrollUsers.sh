@@ -0,0 +1,147 @@
+#!/bin/bash
+#
+# Enrolls the identities the API gateway signs with from the Org1 CA. The
+# chaincode reads the caller's role, and a dealer's dealerId, from attributes
+# of its certificate, which cryptogen cannot add, so these come from the CA
+# started with docker-compose-ca.yaml. For each identity an API key is
+# generated; the gateway signs a request with the identity whose key it carries.
+#
+# The list of identities can be extended with "name:role[:dealerId]" arguments,
+# e.g. ./scripts/enrollUsers.sh dealer003:dealer:DEALER003
+
+DELAY=${DELAY:-"3"}
+MAX_RETRY=${MAX_RETRY:-"5"}
+CA_URL=${CA_URL:-"localhost:7054"}
+CA_NAME=${CA_NAME:-"ca-org1"}
+CA_ADMIN=${CA_ADMIN:-"admin"}
+CA_ADMIN_PW=${CA_ADMIN_PW:-"adminpw"}
+
+# import utils
+. scripts/envVar.sh
+
+export PATH=${PWD}/../bin:$PATH
+
+ORG1_DIR=${PWD}/organizations/peerOrganizations/org1.example.com
+CA_CERT=${ORG1_DIR}/ca/ca.org1.example.com-cert.pem
+# read by the API gateway; paths in it are relative to the file
+IDENTITIES_FILE=${ORG1_DIR}/gateway-identities.json
+# sourced by test-api.sh and demo-system.sh
+API_KEYS_FILE=${ORG1_DIR}/gateway-api-keys.env
+
+IDENTITIES=(
+  "admin:admin"
+  "treasury:treasury"
+  "agent:agent"
+  "dealer001:dealer:DEALER001"
+  "dealer002:dealer:DEALER002"
+  "$@"
+)
+
+# enrollRegistrar enrolls the CA's bootstrap identity, which registers the others
+enrollRegistrar() {
+  export FABRIC_CA_CLIENT_HOME=${ORG1_DIR}/ca-registrar
+  if [ -f "${FABRIC_CA_CLIENT_HOME}/msp/signcerts/cert.pem" ]; then
+    return
+  fi
+
+  infoln "Enrolling the CA registrar"
+  # Poll in case the CA is still starting
+  local res=1
+  local COUNTER=1
+  while [ $res -ne 0 -a $COUNTER -le $MAX_RETRY ] ; do
+    sleep $DELAY
+    set -x
+    fabric-ca-client enroll -u https://${CA_ADMIN}:${CA_ADMIN_PW}@${CA_URL} --caname ${CA_NAME} --tls.certfiles "${CA_CERT}" >&log.txt
+    res=$?
+    { set +x; } 2>/dev/null
+    let COUNTER=$COUNTER+1
+  done
+  cat log.txt
+  verifyResult $res "Failed to enroll the CA registrar"
+}
+
+# enrollUser NAME ROLE [DEALER_ID]
+enrollUser() {
+  local name=$1
+  local role=$2
+  local dealerId=$3
+  local msp=${ORG1_DIR}/users/${name}@org1.example.com/msp
+
+  if [ -f "${msp}/signcerts/cert.pem" ]; then
+    infoln "${name} is already enrolled"
+    return
+  fi
+
+  local attrs="role=${role}:ecert"
+  if [ -n "$dealerId" ]; then
+    attrs="${attrs},dealerId=${dealerId}:ecert"
+  fi
+  local secret=$(openssl rand -hex 16)
+
+  infoln "Registering ${name} as ${role} ${dealerId}"
+  set -x
+  fabric-ca-client register --caname ${CA_NAME} --id.name ${name} --id.secret ${secret} --id.type client \
+    --id.attrs "${attrs}" --tls.certfiles "${CA_CERT}" >&log.txt
+  res=$?
+  { set +x; } 2>/dev/null
+  cat log.txt
+  verifyResult $res "Failed to register ${name}"
+
+  set -x
+  fabric-ca-client enroll -u https://${name}:${secret}@${CA_URL} --caname ${CA_NAME} -M "${msp}" \
+    --tls.certfiles "${CA_CERT}" >&log.txt
+  res=$?
+  { set +x; } 2>/dev/null
+  cat log.txt
+  verifyResult $res "Failed to enroll ${name}"
+
+  # NodeOUs are enabled for Org1, so the MSP needs the OU configuration
+  cp "${ORG1_DIR}/msp/config.yaml" "${msp}/config.yaml"
+  successln "${name} enrolled"
+}
+
+# writeIdentities writes the gateway's identities file and the API keys of the
+# test scripts, keeping the keys of identities that already have one
+writeIdentities() {
+  touch "${API_KEYS_FILE}"
+  chmod 600 "${API_KEYS_FILE}"
+
+  local entries=()
+  for identity in "${IDENTITIES[@]}"; do
+    local name=${identity%%:*}
+    local variable=$(echo "${name}" | tr '[:lower:]-' '[:upper:]_')_API_KEY
+    local key=$(sed -n "s/^${variable}=//p" "${API_KEYS_FILE}")
+    if [ -z "$key" ]; then
+      key=$(openssl rand -hex 32)
+      echo "${variable}=${key}" >>"${API_KEYS_FILE}"
+    fi
+
+    entries+=("  {\"name\": \"${name}\", \"apiKey\": \"${key}\", \"mspId\": \"Org1MSP\", \"certPath\": \"users/${name}@org1.example.com/msp/signcerts/cert.pem\", \"keyPath\": \"users/${name}@org1.example.com/msp/keystore\"}")
+  done
+
+  (
+    echo "["
+    local last=$((${#entries[@]} - 1))
+    for i in "${!entries[@]}"; do
+      if [ $i -lt $last ]; then
+        echo "${entries[$i]},"
+      else
+        echo "${entries[$i]}"
+      fi
+    done
+    echo "]"
+  ) >"${IDENTITIES_FILE}"
+  chmod 600 "${IDENTITIES_FILE}"
+  successln "Gateway identities written to ${IDENTITIES_FILE}, API keys to ${API_KEYS_FILE}"
+}
+
+if [ ! -f "${CA_CERT}" ]; then
+  fatalln "Org1 crypto material not found, bring the network up first"
+fi
+
+enrollRegistrar
+for identity in "${IDENTITIES[@]}"; do
+  IFS=: read -r name role dealerId <<<"${identity}"
+  enrollUser "$name" "$role" "$dealerId"
+done
+writeIdentities
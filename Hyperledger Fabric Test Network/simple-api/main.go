@@ -15,9 +15,9 @@ type Asset struct {
 	MSISDN      string    `json:"msisdn"`
 	DealerID    string    `json:"dealerId"`
 	MPIN        string    `json:"mpin,omitempty"`
-	Balance     float64   `json:"balance"`
+	Balance     Money     `json:"balance"`
 	Status      string    `json:"status"`
-	TransAmount float64   `json:"transAmount"`
+	TransAmount Money     `json:"transAmount"`
 	TransType   string    `json:"transType"`
 	Remarks     string    `json:"remarks"`
 	CreatedAt   time.Time `json:"createdAt"`
@@ -29,9 +29,9 @@ var assets = map[string]Asset{
 	"1234567890": {
 		MSISDN:      "1234567890",
 		DealerID:    "DEALER001",
-		Balance:     1000.0,
+		Balance:     Money{Amount: 100000, Currency: "USD"},
 		Status:      "ACTIVE",
-		TransAmount: 0,
+		TransAmount: Money{Currency: "USD"},
 		TransType:   "INITIAL",
 		Remarks:     "Initial demo asset",
 		CreatedAt:   time.Now(),
@@ -40,9 +40,9 @@ var assets = map[string]Asset{
 	"1234567891": {
 		MSISDN:      "1234567891",
 		DealerID:    "DEALER002",
-		Balance:     2000.0,
+		Balance:     Money{Amount: 200000, Currency: "USD"},
 		Status:      "ACTIVE",
-		TransAmount: 0,
+		TransAmount: Money{Currency: "USD"},
 		TransType:   "INITIAL",
 		Remarks:     "Second demo asset",
 		CreatedAt:   time.Now(),
@@ -107,12 +107,36 @@ func main() {
 
 		// Create asset
 		api.POST("/assets", func(c *gin.Context) {
-			var newAsset Asset
-			if err := c.ShouldBindJSON(&newAsset); err != nil {
+			var request struct {
+				MSISDN   string      `json:"msisdn" binding:"required"`
+				DealerID string      `json:"dealerId" binding:"required"`
+				MPIN     string      `json:"mpin" binding:"required"`
+				Balance  json.Number `json:"balance" binding:"required"`
+				Currency string      `json:"currency" binding:"required"`
+				Status   string      `json:"status" binding:"required"`
+				Remarks  string      `json:"remarks"`
+			}
+			if err := c.ShouldBindJSON(&request); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			balance, err := parseMoney(request.Balance.String(), request.Currency)
+			if err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
+			newAsset := Asset{
+				MSISDN:      request.MSISDN,
+				DealerID:    request.DealerID,
+				MPIN:        request.MPIN,
+				Balance:     balance,
+				Status:      request.Status,
+				TransAmount: balance,
+				Remarks:     request.Remarks,
+			}
+
 			// Check if asset already exists
 			if _, exists := assets[newAsset.MSISDN]; exists {
 				c.JSON(http.StatusConflict, gin.H{"error": "Asset already exists"})
@@ -138,10 +162,10 @@ func main() {
 			msisdn := c.Param("msisdn")
 			
 			var request struct {
-				MPIN      string  `json:"mpin" binding:"required"`
-				Amount    float64 `json:"amount" binding:"required"`
-				TransType string  `json:"transType" binding:"required"`
-				Remarks   string  `json:"remarks"`
+				MPIN      string      `json:"mpin" binding:"required"`
+				Amount    json.Number `json:"amount" binding:"required"`
+				TransType string      `json:"transType" binding:"required"`
+				Remarks   string      `json:"remarks"`
 			}
 
 			if err := c.ShouldBindJSON(&request); err != nil {
@@ -161,26 +185,32 @@ func main() {
 				return
 			}
 
+			amount, err := parseMoney(request.Amount.String(), asset.Balance.Currency)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
 			// Update balance based on transaction type
 			switch request.TransType {
 			case "CREDIT":
-				asset.Balance += request.Amount
+				asset.Balance.Amount += amount.Amount
 			case "DEBIT":
-				if asset.Balance < request.Amount {
+				if asset.Balance.Amount < amount.Amount {
 					c.JSON(http.StatusBadRequest, gin.H{
-						"error": fmt.Sprintf("Insufficient balance. Current: %.2f, Requested: %.2f", 
-							asset.Balance, request.Amount),
+						"error": fmt.Sprintf("Insufficient balance. Current: %s, Requested: %s",
+							asset.Balance, amount),
 					})
 					return
 				}
-				asset.Balance -= request.Amount
+				asset.Balance.Amount -= amount.Amount
 			default:
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction type"})
 				return
 			}
 
 			// Update asset
-			asset.TransAmount = request.Amount
+			asset.TransAmount = amount
 			asset.TransType = request.TransType
 			asset.Remarks = request.Remarks
 			asset.UpdatedAt = time.Now()
@@ -241,9 +271,9 @@ func main() {
 					MSISDN:      "1234567890",
 					DealerID:    "DEALER001",
 					MPIN:        "1234",
-					Balance:     1000.0,
+					Balance:     Money{Amount: 100000, Currency: "USD"},
 					Status:      "ACTIVE",
-					TransAmount: 0,
+					TransAmount: Money{Currency: "USD"},
 					TransType:   "INITIAL",
 					Remarks:     "Initial demo asset",
 					CreatedAt:   time.Now(),
@@ -253,9 +283,9 @@ func main() {
 					MSISDN:      "1234567891",
 					DealerID:    "DEALER002",
 					MPIN:        "5678",
-					Balance:     2000.0,
+					Balance:     Money{Amount: 200000, Currency: "USD"},
 					Status:      "ACTIVE",
-					TransAmount: 0,
+					TransAmount: Money{Currency: "USD"},
 					TransType:   "INITIAL",
 					Remarks:     "Second demo asset",
 					CreatedAt:   time.Now(),
@@ -284,9 +314,9 @@ func main() {
 					"id":          fmt.Sprintf("%s-CREATE-%d", msisdn, time.Now().Unix()),
 					"assetId":     msisdn,
 					"transType":   "CREATE",
-					"amount":      1000.0,
-					"prevBalance": 0.0,
-					"newBalance":  1000.0,
+					"amount":      Money{Amount: 100000, Currency: "USD"},
+					"prevBalance": Money{Currency: "USD"},
+					"newBalance":  Money{Amount: 100000, Currency: "USD"},
 					"remarks":     "Account creation",
 					"timestamp":   time.Now().Format(time.RFC3339),
 				},
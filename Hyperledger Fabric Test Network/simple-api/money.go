@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// currencyMinorUnits lists the supported ISO-4217 codes and their decimal places
+var currencyMinorUnits = map[string]int{
+	"EUR": 2,
+	"GBP": 2,
+	"INR": 2,
+	"JPY": 0,
+	"KES": 2,
+	"USD": 2,
+}
+
+var decimalPattern = regexp.MustCompile(`^([0-9]+)(\.([0-9]+))?$`)
+
+// Money is an amount held as integer minor units of an ISO-4217 currency,
+// matching the representation used by the chaincode
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// parseMoney strictly parses a non-negative decimal string in the given currency
+func parseMoney(value, currency string) (Money, error) {
+	digits, ok := currencyMinorUnits[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("unsupported currency %q", currency)
+	}
+
+	match := decimalPattern.FindStringSubmatch(value)
+	if match == nil {
+		return Money{}, fmt.Errorf("invalid amount %q", value)
+	}
+	fraction := match[3]
+	if len(fraction) > digits {
+		return Money{}, fmt.Errorf("amount %q has more than %d decimal places for %s", value, digits, currency)
+	}
+
+	amount, err := strconv.ParseInt(match[1]+fraction+strings.Repeat("0", digits-len(fraction)), 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("amount %q is out of range", value)
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// String formats the amount as a plain decimal, e.g. "1250.50"
+func (m Money) String() string {
+	digits := currencyMinorUnits[m.Currency]
+	if digits == 0 {
+		return strconv.FormatInt(m.Amount, 10)
+	}
+
+	scale := int64(math.Pow10(digits))
+	return fmt.Sprintf("%d.%0*d", m.Amount/scale, digits, m.Amount%scale)
+}
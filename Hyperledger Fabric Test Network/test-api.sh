@@ -4,8 +4,17 @@
 
 API_URL="http://localhost:8080/api/v1"
 TEST_MSISDN="9876543210"
-TEST_DEALER="DEALER999"
+TEST_DEALER="DEALER001"
 TEST_MPIN="9999"
+# Asset of TEST_DEALER created by InitLedger, which receives the transfer and
+# the settlement when the test asset is closed
+PEER_MSISDN="1234567890"
+
+# API keys of the gateway identities, written by ./network.sh enrollUsers
+API_KEYS_FILE=${API_KEYS_FILE:-"organizations/peerOrganizations/org1.example.com/gateway-api-keys.env"}
+if [ -f "$API_KEYS_FILE" ]; then
+    . "$API_KEYS_FILE"
+fi
 
 # Colors for output
 RED='\033[0;31m'
@@ -44,10 +53,23 @@ check_api_health() {
     fi
 }
 
+# Function to test that requests without an API key are refused
+test_unauthenticated() {
+    print_status "Testing request without an API key..."
+    response=$(curl -s -o /dev/null -w "%{http_code}" "$API_URL/assets")
+    if [ "$response" = "401" ]; then
+        print_success "Unauthenticated test passed (correctly rejected)"
+        return 0
+    else
+        print_warning "Unauthenticated test unexpected result (HTTP $response)"
+        return 1
+    fi
+}
+
 # Function to initialize ledger
 init_ledger() {
     print_status "Initializing ledger..."
-    response=$(curl -s -X POST "$API_URL/ledger/init" -w "%{http_code}")
+    response=$(curl -s -X POST -H "Authorization: Bearer $ADMIN_API_KEY" "$API_URL/ledger/init" -w "%{http_code}")
     http_code="${response: -3}"
     if [ "$http_code" = "200" ]; then
         print_success "Ledger initialized successfully"
@@ -58,16 +80,17 @@ init_ledger() {
     fi
 }
 
-# Function to create a test asset
+# Function to create a test asset. Assets open with a zero balance; the
+# dealer creates them for its own dealer ID.
 create_test_asset() {
     print_status "Creating test asset..."
-    response=$(curl -s -X POST "$API_URL/assets" \
+    response=$(curl -s -X POST -H "Authorization: Bearer $DEALER001_API_KEY" "$API_URL/assets" \
         -H "Content-Type: application/json" \
         -d "{
             \"msisdn\": \"$TEST_MSISDN\",
             \"dealerId\": \"$TEST_DEALER\",
             \"mpin\": \"$TEST_MPIN\",
-            \"balance\": 5000.0,
+            \"currency\": \"USD\",
             \"status\": \"ACTIVE\",
             \"remarks\": \"Test account creation\"
         }" \
@@ -87,7 +110,7 @@ create_test_asset() {
 # Function to get asset
 get_asset() {
     print_status "Getting asset $TEST_MSISDN..."
-    response=$(curl -s "$API_URL/assets/$TEST_MSISDN" -w "%{http_code}")
+    response=$(curl -s -H "Authorization: Bearer $DEALER001_API_KEY" "$API_URL/assets/$TEST_MSISDN" -w "%{http_code}")
     http_code="${response: -3}"
     if [ "$http_code" = "200" ]; then
         print_success "Asset retrieved successfully"
@@ -99,10 +122,10 @@ get_asset() {
     fi
 }
 
-# Function to get all assets
+# Function to get all assets. A dealer only sees its own.
 get_all_assets() {
-    print_status "Getting all assets..."
-    response=$(curl -s "$API_URL/assets" -w "%{http_code}")
+    print_status "Getting all assets of $TEST_DEALER..."
+    response=$(curl -s -H "Authorization: Bearer $DEALER001_API_KEY" "$API_URL/assets" -w "%{http_code}")
     http_code="${response: -3}"
     if [ "$http_code" = "200" ]; then
         print_success "All assets retrieved successfully"
@@ -114,27 +137,96 @@ get_all_assets() {
     fi
 }
 
-# Function to credit account
-credit_account() {
+# Function to upgrade the KYC tier; TIER0 assets cannot send transfers
+upgrade_kyc() {
+    print_status "Upgrading asset to TIER1..."
+    response=$(curl -s -X POST -H "Authorization: Bearer $ADMIN_API_KEY" "$API_URL/assets/$TEST_MSISDN/kyc/upgrade" \
+        -H "Content-Type: application/json" \
+        -d "{
+            \"tier\": \"TIER1\",
+            \"evidenceHash\": \"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08\",
+            \"remarks\": \"Test KYC upgrade\"
+        }" \
+        -w "%{http_code}")
+    
+    http_code="${response: -3}"
+    if [ "$http_code" = "200" ]; then
+        print_success "KYC tier upgraded successfully"
+        return 0
+    else
+        print_error "Failed to upgrade KYC tier (HTTP $http_code)"
+        echo "Response: ${response%???}"
+        return 1
+    fi
+}
+
+# Function to issue float to the dealer. Only the treasury creates value.
+issue_float() {
     local amount=$1
-    print_status "Crediting account with $amount..."
-    response=$(curl -s -X PUT "$API_URL/assets/$TEST_MSISDN/balance" \
+    print_status "Issuing $amount of float to $TEST_DEALER..."
+    response=$(curl -s -X POST -H "Authorization: Bearer $TREASURY_API_KEY" "$API_URL/supply/issue" \
+        -H "Content-Type: application/json" \
+        -d "{
+            \"dealerId\": \"$TEST_DEALER\",
+            \"amount\": \"$amount\",
+            \"reference\": \"Test bank transfer\"
+        }" \
+        -w "%{http_code}")
+    
+    http_code="${response: -3}"
+    if [ "$http_code" = "200" ]; then
+        print_success "Float issued successfully"
+        return 0
+    else
+        print_error "Failed to issue float (HTTP $http_code)"
+        echo "Response: ${response%???}"
+        return 1
+    fi
+}
+
+# Function to fund the account from its dealer's float
+distribute_float() {
+    local amount=$1
+    print_status "Distributing $amount of float to the account..."
+    response=$(curl -s -X POST -H "Authorization: Bearer $DEALER001_API_KEY" "$API_URL/dealers/$TEST_DEALER/distribute" \
+        -H "Content-Type: application/json" \
+        -d "{
+            \"msisdn\": \"$TEST_MSISDN\",
+            \"amount\": \"$amount\",
+            \"remarks\": \"Test float distribution\"
+        }" \
+        -w "%{http_code}")
+    
+    http_code="${response: -3}"
+    if [ "$http_code" = "200" ]; then
+        print_success "Float distributed successfully"
+        return 0
+    else
+        print_error "Failed to distribute float (HTTP $http_code)"
+        echo "Response: ${response%???}"
+        return 1
+    fi
+}
+
+# Function to test that CREDIT is refused; funds only arrive from a debited counterparty
+test_credit_refused() {
+    print_status "Testing that CREDIT is refused..."
+    response=$(curl -s -X PUT -H "Authorization: Bearer $DEALER001_API_KEY" "$API_URL/assets/$TEST_MSISDN/balance" \
         -H "Content-Type: application/json" \
         -d "{
             \"mpin\": \"$TEST_MPIN\",
-            \"amount\": $amount,
+            \"amount\": \"100.00\",
             \"transType\": \"CREDIT\",
             \"remarks\": \"Test credit transaction\"
         }" \
         -w "%{http_code}")
     
     http_code="${response: -3}"
-    if [ "$http_code" = "200" ]; then
-        print_success "Account credited successfully"
+    if [ "$http_code" = "400" ]; then
+        print_success "CREDIT test passed (correctly rejected)"
         return 0
     else
-        print_error "Failed to credit account (HTTP $http_code)"
-        echo "Response: ${response%???}"
+        print_warning "CREDIT test unexpected result (HTTP $http_code)"
         return 1
     fi
 }
@@ -143,11 +235,11 @@ credit_account() {
 debit_account() {
     local amount=$1
     print_status "Debiting account with $amount..."
-    response=$(curl -s -X PUT "$API_URL/assets/$TEST_MSISDN/balance" \
+    response=$(curl -s -X PUT -H "Authorization: Bearer $DEALER001_API_KEY" "$API_URL/assets/$TEST_MSISDN/balance" \
         -H "Content-Type: application/json" \
         -d "{
             \"mpin\": \"$TEST_MPIN\",
-            \"amount\": $amount,
+            \"amount\": \"$amount\",
             \"transType\": \"DEBIT\",
             \"remarks\": \"Test debit transaction\"
         }" \
@@ -164,14 +256,40 @@ debit_account() {
     fi
 }
 
+# Function to transfer to another asset
+transfer_balance() {
+    local amount=$1
+    print_status "Transferring $amount to $PEER_MSISDN..."
+    response=$(curl -s -X POST -H "Authorization: Bearer $DEALER001_API_KEY" "$API_URL/transfers" \
+        -H "Content-Type: application/json" \
+        -d "{
+            \"fromMsisdn\": \"$TEST_MSISDN\",
+            \"toMsisdn\": \"$PEER_MSISDN\",
+            \"mpin\": \"$TEST_MPIN\",
+            \"amount\": \"$amount\",
+            \"remarks\": \"Test transfer\"
+        }" \
+        -w "%{http_code}")
+    
+    http_code="${response: -3}"
+    if [ "$http_code" = "200" ]; then
+        print_success "Transfer completed successfully"
+        return 0
+    else
+        print_error "Failed to transfer (HTTP $http_code)"
+        echo "Response: ${response%???}"
+        return 1
+    fi
+}
+
 # Function to test insufficient balance
 test_insufficient_balance() {
     print_status "Testing insufficient balance scenario..."
-    response=$(curl -s -X PUT "$API_URL/assets/$TEST_MSISDN/balance" \
+    response=$(curl -s -X PUT -H "Authorization: Bearer $DEALER001_API_KEY" "$API_URL/assets/$TEST_MSISDN/balance" \
         -H "Content-Type: application/json" \
         -d "{
             \"mpin\": \"$TEST_MPIN\",
-            \"amount\": 999999.0,
+            \"amount\": \"999999.00\",
             \"transType\": \"DEBIT\",
             \"remarks\": \"Test insufficient balance\"
         }" \
@@ -187,21 +305,22 @@ test_insufficient_balance() {
     fi
 }
 
-# Function to test wrong MPIN
+# Function to test wrong MPIN. The failed attempt is committed so that it
+# counts towards locking the account, and reported as 401.
 test_wrong_mpin() {
     print_status "Testing wrong MPIN scenario..."
-    response=$(curl -s -X PUT "$API_URL/assets/$TEST_MSISDN/balance" \
+    response=$(curl -s -X PUT -H "Authorization: Bearer $DEALER001_API_KEY" "$API_URL/assets/$TEST_MSISDN/balance" \
         -H "Content-Type: application/json" \
         -d "{
             \"mpin\": \"0000\",
-            \"amount\": 100.0,
-            \"transType\": \"CREDIT\",
+            \"amount\": \"100.00\",
+            \"transType\": \"DEBIT\",
             \"remarks\": \"Test wrong MPIN\"
         }" \
         -w "%{http_code}")
     
     http_code="${response: -3}"
-    if [ "$http_code" = "500" ]; then
+    if [ "$http_code" = "401" ]; then
         print_success "Wrong MPIN test passed (correctly rejected)"
         return 0
     else
@@ -214,7 +333,7 @@ test_wrong_mpin() {
 update_status() {
     local status=$1
     print_status "Updating asset status to $status..."
-    response=$(curl -s -X PUT "$API_URL/assets/$TEST_MSISDN/status" \
+    response=$(curl -s -X PUT -H "Authorization: Bearer $ADMIN_API_KEY" "$API_URL/assets/$TEST_MSISDN/status" \
         -H "Content-Type: application/json" \
         -d "{
             \"status\": \"$status\",
@@ -235,7 +354,7 @@ update_status() {
 # Function to get transaction history
 get_transaction_history() {
     print_status "Getting transaction history..."
-    response=$(curl -s "$API_URL/assets/$TEST_MSISDN/transactions" -w "%{http_code}")
+    response=$(curl -s -H "Authorization: Bearer $DEALER001_API_KEY" "$API_URL/assets/$TEST_MSISDN/transactions" -w "%{http_code}")
     http_code="${response: -3}"
     if [ "$http_code" = "200" ]; then
         print_success "Transaction history retrieved successfully"
@@ -247,16 +366,32 @@ get_transaction_history() {
     fi
 }
 
-# Function to delete asset
+# Function to check that the issued float matches what assets and dealers hold
+check_supply() {
+    print_status "Checking total supply..."
+    response=$(curl -s -H "Authorization: Bearer $TREASURY_API_KEY" "$API_URL/supply/check" -w "%{http_code}")
+    http_code="${response: -3}"
+    if [ "$http_code" = "200" ]; then
+        print_success "Supply check retrieved successfully"
+        echo "Supply: ${response%???}" | jq '.' 2>/dev/null || echo "${response%???}"
+        return 0
+    else
+        print_error "Failed to check supply (HTTP $http_code)"
+        return 1
+    fi
+}
+
+# Function to close the test asset, settling its remaining balance
 delete_asset() {
-    print_status "Deleting test asset..."
-    response=$(curl -s -X DELETE "$API_URL/assets/$TEST_MSISDN" -w "%{http_code}")
+    print_status "Closing test asset..."
+    response=$(curl -s -X DELETE -H "Authorization: Bearer $ADMIN_API_KEY" "$API_URL/assets/$TEST_MSISDN?settlementMsisdn=$PEER_MSISDN&remarks=Test+cleanup" -w "%{http_code}")
     http_code="${response: -3}"
     if [ "$http_code" = "200" ]; then
-        print_success "Asset deleted successfully"
+        print_success "Asset closed successfully"
         return 0
     else
-        print_error "Failed to delete asset (HTTP $http_code)"
+        print_error "Failed to close asset (HTTP $http_code)"
+        echo "Response: ${response%???}"
         return 1
     fi
 }
@@ -272,6 +407,13 @@ main() {
         print_error "API is not running. Please start the API gateway first."
         exit 1
     fi
+    if [ -z "$ADMIN_API_KEY" ]; then
+        print_error "No API keys found in $API_KEYS_FILE. Run ./network.sh enrollUsers first."
+        exit 1
+    fi
+    
+    # Test authentication
+    test_unauthenticated
     
     # Initialize ledger
     init_ledger
@@ -284,16 +426,27 @@ main() {
         # Test getting all assets
         get_all_assets
         
-        # Test credit operation
-        credit_account 1000.0
+        # Allow the asset to send transfers
+        upgrade_kyc
+        
+        # Fund the asset: the treasury issues float to the dealer, which
+        # distributes it to the asset
+        issue_float 5000.00
+        distribute_float 1000.00
         
-        # Get asset after credit
+        # Get asset after funding
         get_asset
         
+        # Test that CREDIT is refused
+        test_credit_refused
+        
         # Test debit operation
-        debit_account 500.0
+        debit_account 500.00
         
-        # Get asset after debit
+        # Test transfer
+        transfer_balance 100.00
+        
+        # Get asset after debit and transfer
         get_asset
         
         # Test insufficient balance
@@ -312,7 +465,10 @@ main() {
         # Get transaction history
         get_transaction_history
         
-        # Clean up - delete test asset
+        # Check supply
+        check_supply
+        
+        # Clean up - close test asset
         delete_asset
         
         print_success "All tests completed!"